@@ -0,0 +1,86 @@
+package integration
+
+import (
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jyane/jnes/nes"
+)
+
+// dmcPeriod is CPU cycles per bit at the DMC rate 15 (NTSC).
+const dmcPeriod = 54
+
+// dmcLevel converts a mixer output to the DMC level, the other channels are silent.
+// https://www.nesdev.org/wiki/APU_Mixer
+func dmcLevel(output float32) int {
+	if output == 0 {
+		return 0
+	}
+	return int(math.Round(24329 / (163.67/float64(output) - 100)))
+}
+
+// TestDMC runs testdata/dmc.nes (see testdata/dmc.asm), which plays a DMC sample once, and compares the levels
+// of the raw audio with testdata/dmc_levels.txt.
+func TestDMC(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/dmc.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels, err := ioutil.ReadFile("testdata/dmc_levels.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []int
+	for _, s := range strings.Fields(string(levels)) {
+		level, err := strconv.Atoi(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, level)
+	}
+	cartridge, err := nes.NewCartridge(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console, err := nes.NewConsole(cartridge, false /* debug */, nes.CaptureRawAudio())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := console.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// The sample takes 17 * 8 * 54 CPU cycles, about a quarter of a frame.
+	for i := 0; i < 2; i++ {
+		nextFrame(t, console)
+	}
+	// Collects the level changes and how many CPU cycles each level lasts, the level is 0 until the ROM sets it.
+	var got, lengths []int
+	for _, output := range console.RawAudio() {
+		level := dmcLevel(output)
+		if len(got) == 0 && level == 0 {
+			continue
+		}
+		if len(got) == 0 || got[len(got)-1] != level {
+			got = append(got, level)
+			lengths = append(lengths, 0)
+		}
+		lengths[len(lengths)-1]++
+	}
+	if len(got) != len(want) {
+		t.Fatalf("levels: got=%v, want=%v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("level %d: got=%d, want=%d", i, got[i], want[i])
+		}
+	}
+	// The first level starts at the $4011 write and the last level is kept after the sample ends.
+	for i := 1; i < len(lengths)-1; i++ {
+		if lengths[i] != dmcPeriod {
+			t.Errorf("cycles of level %d: got=%d, want=%d", i, lengths[i], dmcPeriod)
+		}
+	}
+}
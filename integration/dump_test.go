@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jyane/jnes/nes"
+)
+
+func TestDumpFrames(t *testing.T) {
+	f, _ := os.Open("testdata/sample1.nes")
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	cartridge, _ := nes.NewCartridge(b)
+	console, _ := nes.NewConsole(cartridge, false /* debug */)
+	console.Reset()
+	dir := t.TempDir()
+	const n = 5
+	if err := console.DumpFrames(dir, n); err != nil {
+		t.Fatal(err)
+	}
+	pngs, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pngs) != n {
+		t.Errorf("PNG files: got=%d, want=%d", len(pngs), n)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("frame_%05d.png", i))); err != nil {
+			t.Errorf("frame %d: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "info.txt")); err != nil {
+		t.Errorf("The frame info is missing: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jyane/jnes/nes"
+)
+
+func TestFrameCallback(t *testing.T) {
+	f, _ := os.Open("testdata/sample1.nes")
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	cartridge, _ := nes.NewCartridge(b)
+	console, _ := nes.NewConsole(cartridge, false /* debug */)
+	r, _ := os.Open("testdata/helloworld.png")
+	defer r.Close()
+	want, _ := png.Decode(r)
+	var called int
+	var got *image.RGBA
+	console.SetFrameCallback(func(frame *image.RGBA) {
+		called++
+		got = frame
+	})
+	console.Reset()
+	frames := 0
+	for frames < 3 {
+		console.Step()
+		frame, ok := console.Frame()
+		if ok {
+			frames++
+			if called != frames {
+				t.Fatalf("The frame callback was called %d times for %d frames", called, frames)
+			}
+			if got != frame {
+				t.Fatalf("The frame callback got %p, want %p", got, frame)
+			}
+			// The callback gets the completed frame, not the one being rendered.
+			for y := 0; y < got.Rect.Max.Y; y++ {
+				for x := 0; x < got.Rect.Max.X; x++ {
+					if got.At(x, y) != want.At(x, y) {
+						t.Fatalf("Got a color of frame %d at (%d, %d) = %v, want %v", frames, x, y, got.At(x, y), want.At(x, y))
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package integration
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jyane/jnes/nes"
+)
+
+var update = flag.Bool("update", false, "update golden images")
+
+// nextFrame steps the console until a frame is completed.
+func nextFrame(t *testing.T, console nes.Console) *image.RGBA {
+	for {
+		if _, err := console.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if frame, ok := console.Frame(); ok {
+			return frame
+		}
+	}
+}
+
+// TestInput runs testdata/input.nes (see testdata/input.asm), which reads the controller in NMI and sets the backdrop color
+// by the buttons, A: red, B: green, A+B: yellow, otherwise blue. The palette is updated in vblank, so it shows on the next frame.
+// Golden images can be (re)generated by `go test ./integration -run TestInput -update`.
+func TestInput(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/input.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cartridge, err := nes.NewCartridge(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console, err := nes.NewConsole(cartridge, false /* debug */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := console.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// Waits for the ROM to enable NMI after 2 vblanks.
+	for i := 0; i < 3; i++ {
+		nextFrame(t, console)
+	}
+	tests := []struct {
+		name    string
+		buttons [8]bool
+		golden  string
+	}{
+		{"unpressed", [8]bool{}, "testdata/input_unpressed.png"},
+		{"A", [8]bool{nes.ButtonA: true}, "testdata/input_a.png"},
+		{"B", [8]bool{nes.ButtonB: true}, "testdata/input_b.png"},
+		{"released", [8]bool{}, "testdata/input_unpressed.png"},
+	}
+	for _, test := range tests {
+		console.SetButtons(test.buttons)
+		// The first frame may be rendered with the previous buttons.
+		nextFrame(t, console)
+		got := nextFrame(t, console)
+		if *update {
+			w, err := os.Create(test.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := png.Encode(w, got); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			continue
+		}
+		r, err := os.Open(test.golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := png.Decode(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < got.Rect.Max.Y; y++ {
+			for x := 0; x < got.Rect.Max.X; x++ {
+				if got.At(x, y) != want.At(x, y) {
+					t.Fatalf("%s: got a rendered color at (%d, %d) = %v, want %v", test.name, x, y, got.At(x, y), want.At(x, y))
+				}
+			}
+		}
+	}
+}
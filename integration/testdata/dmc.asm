@@ -0,0 +1,28 @@
+; dmc.nes: NROM-128, plays a 17 bytes DMC sample once at the fastest rate (54 CPU cycles per bit).
+; The output level starts at $40 (64) and each bit of the sample, LSB first, adds 2 (1) or subtracts 2 (0).
+; dmc_levels.txt is the expected levels, which are delta-decoded from the sample by hand, the level never reaches
+; 0 or 127 so it changes on every bit.
+; https://www.nesdev.org/wiki/APU_DMC
+
+        .org $8000
+reset:  SEI
+        CLD
+        LDX #$FF
+        TXS
+        LDA #$0F        ; no IRQ, no loop, rate 15
+        STA $4010
+        LDA #$40        ; output level
+        STA $4011
+        LDA #$02        ; sample address = $C000 + $02 * 64 = $C080 ($8080 mirrored)
+        STA $4012
+        LDA #$01        ; sample length = $01 * 16 + 1 = 17 bytes
+        STA $4013
+        LDA #$10        ; DMC on
+        STA $4015
+loop:   JMP loop
+
+        .org $8080
+sample: .byte $FF, $FF, $FF, $00, $00, $55, $AA, $0F, $F0, $33, $CC, $FF, $00, $81, $7E, $01, $80
+
+        .org $BFFA
+        .word reset, reset, reset
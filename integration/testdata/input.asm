@@ -0,0 +1,50 @@
+; input.nes: NROM-128, the backdrop color shows the buttons of 1P.
+; A: $16 (red), B: $1A (green), A+B: $28 (yellow), otherwise $12 (blue).
+; The name table and CHR are all 0, so every pixel is the backdrop.
+
+        .org $8000
+reset:  SEI
+        CLD
+        LDX #$FF
+        TXS
+vb1:    LDA $2002       ; waits 2 vblanks for the PPU.
+        BPL vb1
+vb2:    LDA $2002
+        BPL vb2
+        LDA #$80        ; NMI on
+        STA $2000
+        LDA #$0A        ; background on
+        STA $2001
+loop:   JMP loop
+
+nmi:    LDA #$01        ; strobes the controller.
+        STA $4016
+        LDA #$00
+        STA $4016
+        LDA $4016       ; A
+        AND #$01
+        STA $00
+        LDA $4016       ; B
+        AND #$01
+        ASL A
+        ORA $00
+        TAX
+        LDA #$3F        ; $3F00 = colors[X]
+        STA $2006
+        LDA #$00
+        STA $2006
+        LDA colors,X
+        STA $2007
+        LDA #$20        ; resets the address and the scroll.
+        STA $2006
+        LDA #$00
+        STA $2006
+        STA $2005
+        STA $2005
+        RTI
+
+        .org $8100
+colors: .byte $12, $16, $1A, $28
+
+        .org $BFFA
+        .word nmi, reset, reset
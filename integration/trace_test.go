@@ -0,0 +1,39 @@
+package integration
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jyane/jnes/nes"
+)
+
+func TestTrace(t *testing.T) {
+	f, _ := os.Open("testdata/sample1.nes")
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	cartridge, _ := nes.NewCartridge(b)
+	console, _ := nes.NewConsole(cartridge, false /* debug */)
+	console.Reset()
+	var buf bytes.Buffer
+	if err := console.Trace(&buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"8000  78        SEI                             A:00 X:00 Y:00 P:24 SP:FD PPU:240,  0 CYC:7",
+		"8001  A2 FF     LDX #$FF                        A:00 X:00 Y:00 P:24 SP:FD PPU:240,  6 CYC:9",
+		"8003  9A        TXS                             A:00 X:FF Y:00 P:A4 SP:FD PPU:240, 12 CYC:11",
+		"8004  A9 00     LDA #$00                        A:00 X:FF Y:00 P:A4 SP:FF PPU:240, 18 CYC:13",
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("Trace: got %d lines, want %d lines\n%s", len(got), len(want), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Trace line %d:\ngot:  %s\nwant: %s", i, got[i], want[i])
+		}
+	}
+}
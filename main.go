@@ -2,10 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 
 	"github.com/golang/glog"
 
@@ -15,10 +18,28 @@ import (
 
 var (
 	path       = flag.String("path", "./rom/sample1.nes", "path to NES ROM file")
-	width      = flag.Int("width", 256*4, "widow width")
-	height     = flag.Int("height", 240*4, "widow height")
+	scale      = flag.Int("scale", 0, "window scale of the NES screen (256x240), 4 if no size is specified")
+	width      = flag.Int("width", 0, "widow width, the height is derived if not specified")
+	height     = flag.Int("height", 0, "widow height, the width is derived if not specified")
+	chr        = flag.String("chr", "", "path to CHR data file which overrides the CHR ROM")
+	latency    = flag.Duration("audiolatency", 0, "audio buffer size (e.g. 20ms), larger is stable but delayed, 0 lets PortAudio choose")
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	debug      = flag.Bool("debug", false, "run as debug mode")
+	strict     = flag.Bool("strict", false, "fail on unofficial opcodes instead of executing them")
+	recovery   = flag.Bool("recover", false, "return panics in the emulator as errors")
+	quietIO    = flag.Bool("quietio", false, "don't log writes to unimplemented I/O registers")
+	divider    = flag.Int("cpudivider", 1, "run the CPU at 1/N speed relative to the PPU, inaccurate and only for diagnostics")
+	cheats     = flag.String("cheats", "", "comma separated Game Genie codes")
+	trace      = flag.Int("trace", 0, "run N instructions headlessly, print the trace in nestest.log format and exit")
+	dump       = flag.String("dump", "", "directory to write frames as PNG files headlessly, used with -frames")
+	frames     = flag.Int("frames", 600, "number of frames to write with -dump, or the time limit of -test")
+	testROM    = flag.Bool("test", false, "run a test ROM headlessly, print the $6004 message and exit with the $6000 result code")
+	turbo      = flag.String("turbo", "hold", "fast-forward key (Space) mode, hold or toggle")
+	turboSpeed = flag.Int("turbospeed", 4, "emulation speed while fast-forwarding, e.g. 4 means 4x")
+	rapidFire  = flag.Int("rapidfire", 15, "presses per second of the turbo A (U) and turbo B (Y) keys")
+	rewind     = flag.Int("rewind", 10, "seconds which can be rewound by holding Backspace, 0 disables rewind")
+	screenshot = flag.String("screenshotdir", ".", "directory to write screenshots taken by F12")
+	region     = flag.String("region", "", "ntsc or pal, detected from the ROM header and the file name if not specified")
 )
 
 // readFile reads file as bytes
@@ -60,13 +81,108 @@ func main() {
 	if err != nil {
 		glog.Fatalln("Failed to initiate Cartridge: ", err)
 	}
-	glog.Infof("ROM path=%s, Mapper=%d, Mirror=%d\n", *path, cartridge.MapperIndex(), cartridge.Mirror())
-	console, err := nes.NewConsole(cartridge, *debug)
+	if *chr != "" {
+		data, err := readFile(*chr)
+		if err != nil {
+			glog.Fatalln("Failed to read: " + *chr)
+		}
+		if err := cartridge.OverrideCHR(data); err != nil {
+			glog.Fatalln("Failed to override CHR: ", err)
+		}
+	}
+	glog.Infof("ROM path=%s, Mapper=%d (%s), Mirror=%d\n", *path, cartridge.MapperIndex(), cartridge.Name(), cartridge.Mirror())
+	// Battery-backed PRG RAM is saved next to the ROM, e.g. rom/zelda.nes -> rom/zelda.sav
+	savePath := strings.TrimSuffix(*path, filepath.Ext(*path)) + ".sav"
+	statePath := strings.TrimSuffix(*path, filepath.Ext(*path)) + ".state"
+	if err := cartridge.LoadSRAM(savePath); err != nil {
+		glog.Fatalln("Failed to load SRAM: ", err)
+	}
+	var options []nes.Option
+	if *strict {
+		options = append(options, nes.StrictMode())
+	}
+	if *divider != 1 {
+		options = append(options, nes.CPUClockDivider(*divider))
+	}
+	if *recovery {
+		options = append(options, nes.RecoverPanics())
+	}
+	if *quietIO {
+		options = append(options, nes.SilenceUnimplementedIO())
+	}
+	if 0 < *rewind {
+		options = append(options, nes.EnableRewind(*rewind))
+	}
+	// Bank switches happen many times in a frame, so they are logged only with -v=2.
+	if glog.V(2) {
+		options = append(options, nes.LogBankSwitches(os.Stderr))
+	}
+	console, err := nes.NewConsole(cartridge, *debug, options...)
 	if err != nil {
 		glog.Fatalln("Failed to initiate Console: ", err)
 	}
+	r := nes.DetectRegion(cartridge, *path)
+	if *region != "" {
+		var ok bool
+		if r, ok = nes.ParseRegion(*region); !ok {
+			glog.Fatalln("Unknown region: " + *region)
+		}
+	}
+	glog.Infof("Region=%s\n", r)
+	console.SetRegion(r)
+	if *cheats != "" {
+		for _, code := range strings.Split(*cheats, ",") {
+			if err := console.AddCheat(code); err != nil {
+				glog.Fatalln("Failed to add a cheat: ", err)
+			}
+		}
+	}
 	if err := console.Reset(); err != nil {
 		glog.Fatalln("Failed to reset the console.")
 	}
-	ui.Start(console, *width, *height)
+	if 0 < *trace {
+		if err := console.Trace(os.Stdout, *trace); err != nil {
+			glog.Fatalln("Failed to trace: ", err)
+		}
+		return
+	}
+	if *dump != "" {
+		if err := console.DumpFrames(*dump, *frames); err != nil {
+			glog.Fatalln("Failed to dump frames: ", err)
+		}
+		return
+	}
+	if *testROM {
+		result, err := console.RunTestROM(*frames)
+		if err != nil {
+			glog.Fatalln("Failed to run the test ROM: ", err)
+		}
+		fmt.Println(result.Message)
+		glog.Flush()
+		os.Exit(result.ExitCode())
+	}
+	var turboMode ui.TurboMode
+	switch *turbo {
+	case "hold":
+		turboMode = ui.TurboHold
+	case "toggle":
+		turboMode = ui.TurboToggle
+	default:
+		glog.Fatalln("Unknown turbo mode: " + *turbo)
+	}
+	w, h := ui.WindowSize(*scale, *width, *height)
+	// SRAM is saved when it has been changed, and before exiting even if the emulation fails.
+	persist := func() error {
+		if !cartridge.SRAMChanged() {
+			return nil
+		}
+		return cartridge.SaveSRAM(savePath)
+	}
+	err = ui.Start(console, w, h, *latency, turboMode, *turboSpeed, ui.DefaultKeymap(), *rapidFire, statePath, *screenshot, persist)
+	if err := cartridge.SaveSRAM(savePath); err != nil {
+		glog.Errorln("Failed to save SRAM: ", err)
+	}
+	if err != nil {
+		glog.Fatalln("Emulation failed: ", err)
+	}
 }
@@ -1,21 +1,60 @@
 package nes
 
-import "math"
+// defaultSampleRate is the rate of samples which APU sends to the audio output unless SetAudioOut specifies it.
+const defaultSampleRate = 44100
 
 type APU struct {
-	pulse1 pulse
-	pulse2 pulse
-	out    chan float32
-	sample int
+	pulse1   pulse
+	pulse2   pulse
+	triangle triangle
+	noise    noise
+	dmc      dmc
+	out      chan float32
+	cycle    uint64
+	// muted stops sending samples to out, e.g. while rewinding.
+	muted bool
+
+	// Downsampler from the CPU clock to the sample rate, outputs are averaged over CPU cycles of a sample.
+	sampleRate  int
+	sampleClock int // accumulates sampleRate every CPU cycle, a sample is sent when this reaches CPUFrequency.
+	sampleSum   float32
+	sampleCount int
+
+	// Frame counter https://www.nesdev.org/wiki/APU_Frame_Counter
+	frameCycle      int  // CPU cycles since the sequence started.
+	fiveStep        bool // 0: 4-step, 1: 5-step
+	frameIRQInhibit bool
+	frameIRQ        bool
 }
 
 func NewAPU() *APU {
-	return &APU{}
+	return &APU{sampleRate: defaultSampleRate, pulse1: pulse{onesComplement: true}, noise: noise{shiftRegister: 1}, dmc: dmc{timerPeriod: dmcRateTable[0], bitsRemaining: 8, silence: true, bufferEmpty: true}}
 }
 
 func (a *APU) Step() {
-	sampleRate := 44100
-	x := float32(math.Sin(2.0 * math.Pi * 440 * float64(a.sample) / float64(sampleRate)))
+	// The triangle, noise and DMC timers are clocked on every CPU cycle, the pulse timers are clocked on every APU cycle (2 CPU cycles).
+	a.triangle.stepTimer()
+	a.noise.stepTimer()
+	a.dmc.stepTimer()
+	if a.cycle%2 == 0 {
+		a.pulse1.stepTimer()
+		a.pulse2.stepTimer()
+	}
+	a.cycle++
+	a.stepFrameCounter()
+	a.sampleSum += a.output()
+	a.sampleCount++
+	a.sampleClock += a.sampleRate
+	if a.sampleClock < CPUFrequency {
+		return
+	}
+	a.sampleClock -= CPUFrequency
+	x := a.sampleSum / float32(a.sampleCount)
+	a.sampleSum = 0
+	a.sampleCount = 0
+	if a.muted {
+		return
+	}
 	select {
 	case a.out <- x: // l
 	default:
@@ -24,31 +63,714 @@ func (a *APU) Step() {
 	case a.out <- x: // r
 	default:
 	}
-	a.sample++
-	if a.sample >= sampleRate*10 {
-		a.sample = 0
+}
+
+// stepFrameCounter clocks the frame counter by a CPU cycle.
+// mode 0: 4-step    mode 1: 5-step
+// ---------------   ---------------
+//  7457  - l        7457  - l
+//  14913 h l        14913 h l
+//  22371 - l        22371 - l
+//  29829 h l F      29829 - -
+//                   37281 h l
+// l: quarter frame (envelopes, triangle's linear counter), h: half frame (length counters, sweeps), F: frame IRQ
+func (a *APU) stepFrameCounter() {
+	a.frameCycle++
+	switch a.frameCycle {
+	case 7457, 22371:
+		a.quarterFrame()
+	case 14913:
+		a.quarterFrame()
+		a.halfFrame()
+	case 29829:
+		if !a.fiveStep {
+			a.quarterFrame()
+			a.halfFrame()
+			if !a.frameIRQInhibit {
+				a.frameIRQ = true
+			}
+			a.frameCycle = 0
+		}
+	case 37281:
+		a.quarterFrame()
+		a.halfFrame()
+		a.frameCycle = 0
+	}
+}
+
+// quarterFrame clocks envelopes and the triangle's linear counter.
+func (a *APU) quarterFrame() {
+	a.pulse1.envelope.step()
+	a.pulse2.envelope.step()
+	a.noise.envelope.step()
+	a.triangle.stepLinear()
+}
+
+// halfFrame clocks length counters and sweep units.
+func (a *APU) halfFrame() {
+	a.pulse1.stepLength()
+	a.pulse2.stepLength()
+	a.triangle.stepLength()
+	a.noise.stepLength()
+	a.pulse1.stepSweep()
+	a.pulse2.stepSweep()
+}
+
+// writeFrameCounter writes $4017, MI-- ----
+// M: 5-step mode, I: IRQ inhibit
+func (a *APU) writeFrameCounter(data byte) {
+	a.fiveStep = data>>7&1 == 1
+	a.frameIRQInhibit = data>>6&1 == 1
+	if a.frameIRQInhibit {
+		a.frameIRQ = false
+	}
+	// TODO(jyane): The sequencer is reset 3 or 4 CPU cycles after the write on hardware.
+	a.frameCycle = 0
+	// Writing with the 5-step mode clocks the quarter and half frame units immediately.
+	if a.fiveStep {
+		a.quarterFrame()
+		a.halfFrame()
+	}
+}
+
+// irq returns true if the APU asserts the IRQ line.
+func (a *APU) irq() bool {
+	return a.frameIRQ || a.dmc.interrupt
+}
+
+// dmcRequest returns the address of the next sample byte if the DMC memory reader needs it,
+// the caller must read it through the CPU bus and give it by fillDMC.
+func (a *APU) dmcRequest() (uint16, bool) {
+	return a.dmc.currentAddress, a.dmc.bufferEmpty && 0 < a.dmc.bytesRemaining
+}
+
+// fillDMC gives the sample byte read from dmcRequest's address to the DMC.
+func (a *APU) fillDMC(data byte) {
+	a.dmc.fill(data)
+}
+
+// output mixes the channels into [0, 1] with the non-linear mixer.
+func (a *APU) output() float32 {
+	pulse := pulseTable[a.pulse1.output()+a.pulse2.output()]
+	tnd := tndTable[3*int(a.triangle.output())+2*int(a.noise.output())+int(a.dmc.output())]
+	return pulse + tnd
+}
+
+// Lookup tables of the non-linear mixer.
+// https://www.nesdev.org/wiki/APU_Mixer#Lookup_Table
+var (
+	pulseTable [31]float32
+	tndTable   [203]float32
+)
+
+func init() {
+	for i := 1; i < len(pulseTable); i++ {
+		pulseTable[i] = float32(95.88 / (8128.0/float64(i) + 100))
+	}
+	for i := 1; i < len(tndTable); i++ {
+		tndTable[i] = float32(163.67 / (24329.0/float64(i) + 100))
 	}
 }
 
-func (a *APU) SetAudioOut(c chan float32) {
+// SetAudioOut sets the channel which receives stereo samples (left and right) at sampleRate.
+func (a *APU) SetAudioOut(c chan float32, sampleRate int) {
 	a.out = c
+	a.sampleRate = sampleRate
+	a.sampleClock = 0
+	a.sampleSum = 0
+	a.sampleCount = 0
 }
 
+// writeControl writes $4015, which enables channels.
+// bit 0: pulse 1, bit 1: pulse 2, bit 2: triangle, bit 3: noise, bit 4: DMC
+// https://www.nesdev.org/wiki/APU#Status_($4015)
 func (a *APU) writeControl(data byte) {
+	a.pulse1.setEnabled(data&1 == 1)
+	a.pulse2.setEnabled(data>>1&1 == 1)
+	a.triangle.setEnabled(data>>2&1 == 1)
+	a.noise.setEnabled(data>>3&1 == 1)
+	a.dmc.setEnabled(data>>4&1 == 1)
+}
+
+// readStatus reads $4015, each bit is set if the length counter of the channel is greater than 0.
+// bit 0: pulse 1, bit 1: pulse 2, bit 2: triangle, bit 3: noise, bit 4: DMC (bytes remaining),
+// bit 6: frame interrupt, bit 7: DMC interrupt
+// Reading this clears the frame interrupt flag but not the DMC interrupt flag.
+func (a *APU) readStatus() byte {
+	var res byte
+	if a.frameIRQ {
+		res |= 1 << 6
+		a.frameIRQ = false
+	}
+	if 0 < a.pulse1.lengthCounter {
+		res |= 1
+	}
+	if 0 < a.pulse2.lengthCounter {
+		res |= 1 << 1
+	}
+	if 0 < a.triangle.lengthCounter {
+		res |= 1 << 2
+	}
+	if 0 < a.noise.lengthCounter {
+		res |= 1 << 3
+	}
+	if 0 < a.dmc.bytesRemaining {
+		res |= 1 << 4
+	}
+	if a.dmc.interrupt {
+		res |= 1 << 7
+	}
+	return res
+}
+
+func (a *APU) saveState(w *stateWriter) {
+	a.pulse1.saveState(w)
+	a.pulse2.saveState(w)
+	a.triangle.saveState(w)
+	a.noise.saveState(w)
+	a.dmc.saveState(w)
+	w.write(a.cycle, a.frameCycle, a.fiveStep, a.frameIRQInhibit, a.frameIRQ, a.sampleClock, a.sampleSum, a.sampleCount)
+}
+
+func (a *APU) loadState(r *stateReader) {
+	a.pulse1.loadState(r)
+	a.pulse2.loadState(r)
+	a.triangle.loadState(r)
+	a.noise.loadState(r)
+	a.dmc.loadState(r)
+	r.read(&a.cycle, &a.frameCycle, &a.fiveStep, &a.frameIRQInhibit, &a.frameIRQ, &a.sampleClock, &a.sampleSum, &a.sampleCount)
 }
 
 // Pulse
+// https://www.nesdev.org/wiki/APU_Pulse
+var dutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0}, // 12.5%
+	{0, 1, 1, 0, 0, 0, 0, 0}, // 25%
+	{0, 1, 1, 1, 1, 0, 0, 0}, // 50%
+	{1, 0, 0, 1, 1, 1, 1, 1}, // 25% negated
+}
+
 type pulse struct {
+	enabled       bool
+	lengthCounter byte
+	duty          byte
+	envelope      envelope // the envelope loop flag is also the length counter halt flag.
+	timerPeriod   uint16
+	timer         uint16
+	dutyIndex     byte
+
+	// Sweep https://www.nesdev.org/wiki/APU_Sweep
+	sweepEnabled   bool
+	sweepPeriod    byte
+	sweepNegate    bool
+	sweepShift     byte
+	sweepReload    bool
+	sweepDivider   byte
+	onesComplement bool // pulse 1 negates with one's complement, pulse 2 uses two's complement.
 }
 
+// writeControl writes $4000/$4004, DDLC VVVV
 func (p *pulse) writeControl(data byte) {
+	p.duty = data >> 6
+	p.envelope.write(data)
 }
 
+// writeSweep writes $4001/$4005, EPPP NSSS
+// E: enabled, P: divider period, N: negate, S: shift count
 func (p *pulse) writeSweep(data byte) {
+	p.sweepEnabled = data>>7&1 == 1
+	p.sweepPeriod = data >> 4 & 7
+	p.sweepNegate = data>>3&1 == 1
+	p.sweepShift = data & 7
+	p.sweepReload = true
+}
+
+// sweepTarget calculates the target period, which is continuously calculated even if the sweep is disabled.
+func (p *pulse) sweepTarget() uint16 {
+	change := p.timerPeriod >> p.sweepShift
+	if !p.sweepNegate {
+		return p.timerPeriod + change
+	}
+	if p.onesComplement {
+		change++
+	}
+	if p.timerPeriod < change {
+		return 0
+	}
+	return p.timerPeriod - change
+}
+
+// muted returns true if the current period is less than 8 or the target period overflows.
+func (p *pulse) muted() bool {
+	return p.timerPeriod < 8 || 0x7FF < p.sweepTarget()
+}
+
+// stepSweep is clocked by the half frame.
+func (p *pulse) stepSweep() {
+	if p.sweepDivider == 0 && p.sweepEnabled && 0 < p.sweepShift && !p.muted() {
+		p.timerPeriod = p.sweepTarget()
+	}
+	if p.sweepDivider == 0 || p.sweepReload {
+		p.sweepDivider = p.sweepPeriod
+		p.sweepReload = false
+	} else {
+		p.sweepDivider--
+	}
 }
 
 func (p *pulse) writeTimerLow(data byte) {
+	p.timerPeriod = (p.timerPeriod & 0xFF00) | uint16(data)
 }
 
+// writeTimerHigh writes $4003/$4007, LLLL LHHH, the sequencer and the envelope are restarted.
 func (p *pulse) writeTimerHigh(data byte) {
+	p.timerPeriod = (p.timerPeriod & 0x00FF) | (uint16(data)&7)<<8
+	p.dutyIndex = 0
+	p.envelope.restart()
+	if p.enabled {
+		p.lengthCounter = lengthTable[data>>3]
+	}
+}
+
+// setEnabled enables the channel, disabling forces the length counter to 0.
+func (p *pulse) setEnabled(enabled bool) {
+	p.enabled = enabled
+	if !enabled {
+		p.lengthCounter = 0
+	}
+}
+
+// stepLength is clocked by the half frame.
+func (p *pulse) stepLength() {
+	if !p.envelope.loop && 0 < p.lengthCounter {
+		p.lengthCounter--
+	}
+}
+
+func (p *pulse) stepTimer() {
+	if p.timer == 0 {
+		p.timer = p.timerPeriod
+		p.dutyIndex = (p.dutyIndex + 1) % 8
+	} else {
+		p.timer--
+	}
+}
+
+func (p *pulse) output() byte {
+	if p.lengthCounter == 0 || p.muted() || dutyTable[p.duty][p.dutyIndex] == 0 {
+		return 0
+	}
+	return p.envelope.output()
+}
+
+func (p *pulse) saveState(w *stateWriter) {
+	w.write(p.enabled, p.lengthCounter, p.duty, p.timerPeriod, p.timer, p.dutyIndex,
+		p.sweepEnabled, p.sweepPeriod, p.sweepNegate, p.sweepShift, p.sweepReload, p.sweepDivider, p.onesComplement)
+	p.envelope.saveState(w)
+}
+
+func (p *pulse) loadState(r *stateReader) {
+	r.read(&p.enabled, &p.lengthCounter, &p.duty, &p.timerPeriod, &p.timer, &p.dutyIndex,
+		&p.sweepEnabled, &p.sweepPeriod, &p.sweepNegate, &p.sweepShift, &p.sweepReload, &p.sweepDivider, &p.onesComplement)
+	p.envelope.loadState(r)
+	r.checkRange("Pulse duty", int(p.duty), len(dutyTable))
+	r.checkRange("Pulse sequence", int(p.dutyIndex), len(dutyTable[0]))
+}
+
+// https://www.nesdev.org/wiki/APU_Length_Counter
+var lengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// Envelope is shared by the pulse and noise channels.
+// https://www.nesdev.org/wiki/APU_Envelope
+type envelope struct {
+	// loop is a single bit of $4000/$4004/$400C, which loops the envelope and halts the length counter at once.
+	loop           bool
+	constantVolume bool
+	volume         byte // the constant volume or the envelope period.
+	start          bool
+	divider        byte
+	decay          byte
+}
+
+// write writes --LC VVVV of $4000/$4004/$400C.
+func (e *envelope) write(data byte) {
+	e.loop = data>>5&1 == 1
+	e.constantVolume = data>>4&1 == 1
+	e.volume = data & 0x0F
+}
+
+// restart is called by writing $4003/$4007/$400F, the decay level is reset at the next clock.
+func (e *envelope) restart() {
+	e.start = true
+}
+
+// step is clocked by the quarter frame, the decay level counts down from 15 and restarts from 15 if looped.
+func (e *envelope) step() {
+	if e.start {
+		e.start = false
+		e.decay = 15
+		e.divider = e.volume
+		return
+	}
+	if 0 < e.divider {
+		e.divider--
+		return
+	}
+	e.divider = e.volume
+	if 0 < e.decay {
+		e.decay--
+	} else if e.loop {
+		e.decay = 15
+	}
+}
+
+// output returns the volume nibble in the constant volume mode, otherwise the decay level.
+func (e *envelope) output() byte {
+	if e.constantVolume {
+		return e.volume
+	}
+	return e.decay
+}
+
+func (e *envelope) saveState(w *stateWriter) {
+	w.write(e.loop, e.constantVolume, e.volume, e.start, e.divider, e.decay)
+}
+
+func (e *envelope) loadState(r *stateReader) {
+	r.read(&e.loop, &e.constantVolume, &e.volume, &e.start, &e.divider, &e.decay)
+}
+
+// Triangle
+// https://www.nesdev.org/wiki/APU_Triangle
+var triangleSequence = [32]byte{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+type triangle struct {
+	enabled            bool
+	lengthCounter      byte
+	control            bool // also the length counter halt flag.
+	linearCounterLoad  byte
+	linearCounterReset bool
+	linearCounter      byte
+	timerPeriod        uint16
+	timer              uint16
+	sequenceIndex      byte
+}
+
+func (t *triangle) writeControl(data byte) {
+	t.control = data>>7&1 == 1
+	t.linearCounterLoad = data & 0x7F
+}
+
+func (t *triangle) writeTimerLow(data byte) {
+	t.timerPeriod = (t.timerPeriod & 0xFF00) | uint16(data)
+}
+
+func (t *triangle) writeTimerHigh(data byte) {
+	t.timerPeriod = (t.timerPeriod & 0x00FF) | (uint16(data)&7)<<8
+	t.linearCounterReset = true
+	if t.enabled {
+		t.lengthCounter = lengthTable[data>>3]
+	}
+}
+
+// setEnabled enables the channel, disabling forces the length counter to 0 but keeps the timer and the sequencer.
+func (t *triangle) setEnabled(enabled bool) {
+	t.enabled = enabled
+	if !enabled {
+		t.lengthCounter = 0
+	}
+}
+
+// stepLength is clocked by the half frame, the control flag halts the length counter.
+func (t *triangle) stepLength() {
+	if !t.control && 0 < t.lengthCounter {
+		t.lengthCounter--
+	}
+}
+
+// stepLinear is clocked by the quarter frame, the linear counter is reloaded after $400B writes
+// and keeps reloading while the control flag is set.
+// https://www.nesdev.org/wiki/APU_Triangle#Linear_counter
+func (t *triangle) stepLinear() {
+	if t.linearCounterReset {
+		t.linearCounter = t.linearCounterLoad
+	} else if 0 < t.linearCounter {
+		t.linearCounter--
+	}
+	if !t.control {
+		t.linearCounterReset = false
+	}
+}
+
+func (t *triangle) stepTimer() {
+	if t.timer == 0 {
+		t.timer = t.timerPeriod
+		// Periods 0 and 1 produce ultrasonic frequencies, real hardware outputs them but
+		// it sounds like a pop, so here holds the current value like other emulators do.
+		if t.timerPeriod < 2 {
+			return
+		}
+		// The sequencer is stopped while the length counter or the linear counter is 0.
+		if t.lengthCounter == 0 || t.linearCounter == 0 {
+			return
+		}
+		t.sequenceIndex = (t.sequenceIndex + 1) % 32
+	} else {
+		t.timer--
+	}
+}
+
+func (t *triangle) saveState(w *stateWriter) {
+	w.write(t.enabled, t.lengthCounter, t.control, t.linearCounterLoad, t.linearCounterReset, t.linearCounter, t.timerPeriod, t.timer, t.sequenceIndex)
+}
+
+func (t *triangle) loadState(r *stateReader) {
+	r.read(&t.enabled, &t.lengthCounter, &t.control, &t.linearCounterLoad, &t.linearCounterReset, &t.linearCounter, &t.timerPeriod, &t.timer, &t.sequenceIndex)
+	r.checkRange("Triangle sequence", int(t.sequenceIndex), len(triangleSequence))
+}
+
+func (t *triangle) output() byte {
+	if !t.enabled {
+		return 0
+	}
+	return triangleSequence[t.sequenceIndex]
+}
+
+// Noise
+// https://www.nesdev.org/wiki/APU_Noise
+// noisePeriodTable is in CPU cycles (NTSC).
+var noisePeriodTable = [16]uint16{
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+type noise struct {
+	enabled       bool
+	lengthCounter byte
+	envelope      envelope // the envelope loop flag is also the length counter halt flag.
+	mode          bool     // the short mode uses bit 6 for the feedback.
+	timerPeriod   uint16
+	timer         uint16
+	shiftRegister uint16 // 15 bits, 1 on power-up.
+}
+
+// writeControl writes $400C, --LC VVVV
+func (n *noise) writeControl(data byte) {
+	n.envelope.write(data)
+}
+
+// writePeriod writes $400E, M--- PPPP
+func (n *noise) writePeriod(data byte) {
+	n.mode = data>>7&1 == 1
+	n.timerPeriod = noisePeriodTable[data&0x0F]
+}
+
+// writeLength writes $400F, LLLL L---, the envelope is restarted.
+func (n *noise) writeLength(data byte) {
+	n.envelope.restart()
+	if n.enabled {
+		n.lengthCounter = lengthTable[data>>3]
+	}
+}
+
+// setEnabled enables the channel, disabling forces the length counter to 0.
+func (n *noise) setEnabled(enabled bool) {
+	n.enabled = enabled
+	if !enabled {
+		n.lengthCounter = 0
+	}
+}
+
+// stepLength is clocked by the half frame.
+func (n *noise) stepLength() {
+	if !n.envelope.loop && 0 < n.lengthCounter {
+		n.lengthCounter--
+	}
+}
+
+func (n *noise) stepTimer() {
+	// The table is in CPU cycles, so the shift register is clocked every timerPeriod cycles.
+	if n.timer <= 1 {
+		n.timer = n.timerPeriod
+		n.stepShiftRegister()
+	} else {
+		n.timer--
+	}
+}
+
+// stepShiftRegister clocks the LFSR, the feedback is bit 0 XOR bit 1 (bit 6 in the short mode).
+// In the short mode, the sequence length is 93 or 31 depending on the current value.
+func (n *noise) stepShiftRegister() {
+	shift := 1
+	if n.mode {
+		shift = 6
+	}
+	feedback := (n.shiftRegister ^ n.shiftRegister>>shift) & 1
+	n.shiftRegister = n.shiftRegister>>1 | feedback<<14
+}
+
+func (n *noise) output() byte {
+	if n.lengthCounter == 0 || n.shiftRegister&1 == 1 {
+		return 0
+	}
+	return n.envelope.output()
+}
+
+func (n *noise) saveState(w *stateWriter) {
+	w.write(n.enabled, n.lengthCounter, n.mode, n.timerPeriod, n.timer, n.shiftRegister)
+	n.envelope.saveState(w)
+}
+
+func (n *noise) loadState(r *stateReader) {
+	r.read(&n.enabled, &n.lengthCounter, &n.mode, &n.timerPeriod, &n.timer, &n.shiftRegister)
+	n.envelope.loadState(r)
+	r.checkRange("Noise shift register", int(n.shiftRegister), 1<<15)
+}
+
+// DMC
+// https://www.nesdev.org/wiki/APU_DMC
+// dmcRateTable is in CPU cycles (NTSC).
+var dmcRateTable = [16]uint16{
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+type dmc struct {
+	irqEnabled bool
+	loop       bool
+	interrupt  bool
+
+	timerPeriod uint16
+	timer       uint16
+
+	// Memory reader
+	sampleAddress  uint16
+	sampleLength   uint16
+	currentAddress uint16
+	bytesRemaining uint16
+	sampleBuffer   byte
+	bufferEmpty    bool
+
+	// Output unit
+	shiftRegister byte
+	bitsRemaining byte
+	silence       bool
+	level         byte
+}
+
+// writeControl writes $4010, IL-- RRRR
+func (d *dmc) writeControl(data byte) {
+	d.irqEnabled = data>>7&1 == 1
+	d.loop = data>>6&1 == 1
+	d.timerPeriod = dmcRateTable[data&0x0F]
+	if !d.irqEnabled {
+		d.interrupt = false
+	}
+}
+
+// writeLevel writes $4011, -DDD DDDD
+func (d *dmc) writeLevel(data byte) {
+	d.level = data & 0x7F
+}
+
+// writeAddress writes $4012, the sample address is %11AAAAAA.AA000000 ($C000 + A * 64).
+func (d *dmc) writeAddress(data byte) {
+	d.sampleAddress = 0xC000 | uint16(data)<<6
+}
+
+// writeLength writes $4013, the sample length is %LLLL.LLLL0001 (L * 16 + 1 bytes).
+func (d *dmc) writeLength(data byte) {
+	d.sampleLength = uint16(data)<<4 | 1
+}
+
+// setEnabled is called by writing $4015, which clears the interrupt flag.
+// Enabling restarts the sample only if no bytes remain, disabling stops the memory reader
+// but the output unit keeps playing the remaining bits.
+func (d *dmc) setEnabled(enabled bool) {
+	d.interrupt = false
+	if !enabled {
+		d.bytesRemaining = 0
+	} else if d.bytesRemaining == 0 {
+		d.restart()
+	}
+}
+
+func (d *dmc) restart() {
+	d.currentAddress = d.sampleAddress
+	d.bytesRemaining = d.sampleLength
+}
+
+// fill loads the sample byte read by the memory reader into the sample buffer.
+func (d *dmc) fill(data byte) {
+	d.sampleBuffer = data
+	d.bufferEmpty = false
+	// The address wraps around to $8000.
+	if d.currentAddress == 0xFFFF {
+		d.currentAddress = 0x8000
+	} else {
+		d.currentAddress++
+	}
+	d.bytesRemaining--
+	if d.bytesRemaining == 0 {
+		if d.loop {
+			d.restart()
+		} else if d.irqEnabled {
+			d.interrupt = true
+		}
+	}
+}
+
+func (d *dmc) stepTimer() {
+	if d.timer <= 1 {
+		d.timer = d.timerPeriod
+		d.stepOutput()
+	} else {
+		d.timer--
+	}
+}
+
+// stepOutput changes the level by 2 with each bit of the shift register, the level is kept in [0, 127].
+func (d *dmc) stepOutput() {
+	if !d.silence {
+		if d.shiftRegister&1 == 1 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else {
+			if 2 <= d.level {
+				d.level -= 2
+			}
+		}
+	}
+	d.shiftRegister >>= 1
+	d.bitsRemaining--
+	// An output cycle ends, the next one starts with the sample buffer.
+	if d.bitsRemaining == 0 {
+		d.bitsRemaining = 8
+		if d.bufferEmpty {
+			d.silence = true
+		} else {
+			d.silence = false
+			d.shiftRegister = d.sampleBuffer
+			d.bufferEmpty = true
+		}
+	}
+}
+
+func (d *dmc) output() byte {
+	return d.level
+}
+
+func (d *dmc) saveState(w *stateWriter) {
+	w.write(d.irqEnabled, d.loop, d.interrupt, d.timerPeriod, d.timer, d.sampleAddress, d.sampleLength, d.currentAddress,
+		d.bytesRemaining, d.sampleBuffer, d.bufferEmpty, d.shiftRegister, d.bitsRemaining, d.silence, d.level)
+}
+
+func (d *dmc) loadState(r *stateReader) {
+	r.read(&d.irqEnabled, &d.loop, &d.interrupt, &d.timerPeriod, &d.timer, &d.sampleAddress, &d.sampleLength, &d.currentAddress,
+		&d.bytesRemaining, &d.sampleBuffer, &d.bufferEmpty, &d.shiftRegister, &d.bitsRemaining, &d.silence, &d.level)
 }
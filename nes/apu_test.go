@@ -0,0 +1,741 @@
+package nes
+
+import (
+	"math"
+	"testing"
+)
+
+// playDMC steps the APU for the given CPU cycles serving the DMC sample reads from rom mapped at $C000,
+// and returns the output levels at every output clock.
+func playDMC(a *APU, rom []byte, cycles int) []byte {
+	var levels []byte
+	for i := 0; i < cycles; i++ {
+		a.Step()
+		if a.dmc.timer == a.dmc.timerPeriod {
+			levels = append(levels, a.dmc.output())
+		}
+		if address, ok := a.dmcRequest(); ok {
+			a.fillDMC(rom[address-0xC000])
+		}
+	}
+	return levels
+}
+
+func TestDMCPlayback(t *testing.T) {
+	a := NewAPU()
+	a.dmc.writeControl(0x0F) // rate 54
+	a.dmc.writeLevel(0x40)
+	a.dmc.writeAddress(0x00)
+	a.dmc.writeLength(0x00) // 1 byte
+	a.writeControl(0x10)
+	// The first output cycle is silent, the sample byte is played in the next cycle.
+	levels := playDMC(a, []byte{0x5F}, 54*16)
+	want := []byte{
+		64, 64, 64, 64, 64, 64, 64, 64,
+		66, 68, 70, 72, 74, 72, 74, 72, // 0b01011111 from the LSB.
+	}
+	if len(levels) != len(want) {
+		t.Fatalf("The number of output clocks: got=%d, want=%d", len(levels), len(want))
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("Level at output clock %d: got=%d, want=%d", i, levels[i], want[i])
+		}
+	}
+	if got := a.readStatus(); got&0x10 != 0 {
+		t.Errorf("$4015 after the sample ended: got=0x%02x, want bit 4 cleared", got)
+	}
+}
+
+func TestDMCLevelClamp(t *testing.T) {
+	d := &dmc{level: 126, shiftRegister: 0xFF, bitsRemaining: 8}
+	d.stepOutput()
+	if d.level != 126 {
+		t.Errorf("Level above 125: got=%d, want=126", d.level)
+	}
+	d = &dmc{level: 1, shiftRegister: 0x00, bitsRemaining: 8}
+	d.stepOutput()
+	if d.level != 1 {
+		t.Errorf("Level below 2: got=%d, want=1", d.level)
+	}
+}
+
+func TestDMCLoopAndIRQ(t *testing.T) {
+	tests := []struct {
+		name          string
+		control       byte
+		wantInterrupt bool
+		wantRemaining uint16
+	}{
+		{"no loop", 0x80, true, 0},
+		{"loop restarts without IRQ", 0xC0, false, 17},
+		{"IRQ disabled", 0x00, false, 0},
+	}
+	for _, test := range tests {
+		a := NewAPU()
+		a.dmc.writeControl(test.control)
+		a.dmc.writeAddress(0x01)
+		a.dmc.writeLength(0x01) // 17 bytes
+		a.writeControl(0x10)
+		for i := 0; i < 17; i++ {
+			address, ok := a.dmcRequest()
+			if !ok {
+				t.Fatalf("%s: DMC should request byte %d", test.name, i)
+			}
+			if want := uint16(0xC040 + i); address != want {
+				t.Errorf("%s: address of byte %d: got=0x%04x, want=0x%04x", test.name, i, address, want)
+			}
+			a.fillDMC(0)
+			a.dmc.bufferEmpty = true
+		}
+		if a.dmc.interrupt != test.wantInterrupt {
+			t.Errorf("%s: interrupt: got=%t, want=%t", test.name, a.dmc.interrupt, test.wantInterrupt)
+		}
+		if a.irq() != test.wantInterrupt {
+			t.Errorf("%s: irq: got=%t, want=%t", test.name, a.irq(), test.wantInterrupt)
+		}
+		if a.dmc.bytesRemaining != test.wantRemaining {
+			t.Errorf("%s: bytes remaining: got=%d, want=%d", test.name, a.dmc.bytesRemaining, test.wantRemaining)
+		}
+		// Writing $4015 clears the DMC interrupt.
+		a.writeControl(0x00)
+		if a.dmc.interrupt {
+			t.Errorf("%s: interrupt after writing $4015 should be cleared", test.name)
+		}
+	}
+}
+
+func TestDMCAddressWrap(t *testing.T) {
+	d := &dmc{currentAddress: 0xFFFF, bytesRemaining: 2, bufferEmpty: true}
+	d.fill(0)
+	if d.currentAddress != 0x8000 {
+		t.Errorf("Address after $FFFF: got=0x%04x, want=0x8000", d.currentAddress)
+	}
+}
+
+func TestTriangleUltrasonicHoldsOutput(t *testing.T) {
+	tri := &triangle{sequenceIndex: 5}
+	tri.setEnabled(true)
+	tri.writeTimerLow(0)
+	tri.writeTimerHigh(0)
+	want := tri.output()
+	for i := 0; i < 100; i++ {
+		tri.stepTimer()
+		if got := tri.output(); got != want {
+			t.Fatalf("Triangle output with period 0 at step %d: got=%d, want=%d", i, got, want)
+		}
+	}
+}
+
+func TestTriangleSequence(t *testing.T) {
+	tri := &triangle{}
+	tri.setEnabled(true)
+	tri.writeControl(0x7F)
+	tri.writeTimerLow(2)
+	tri.writeTimerHigh(0)
+	tri.stepLinear()
+	// The sequencer advances every period+1 timer clocks.
+	for i := 0; i < 3*4; i++ {
+		tri.stepTimer()
+	}
+	if got, want := tri.output(), triangleSequence[4]; got != want {
+		t.Errorf("Triangle output: got=%d, want=%d", got, want)
+	}
+}
+
+func TestTriangleLinearCounter(t *testing.T) {
+	a := NewAPU()
+	a.writeControl(0x04)
+	a.triangle.writeControl(0x02) // control clear, reload value 2
+	a.triangle.writeTimerLow(2)
+	a.triangle.writeTimerHigh(0x08) // length counter = 254
+	// The sequencer doesn't run until the quarter frame reloads the linear counter.
+	a.triangle.stepTimer()
+	a.triangle.stepTimer()
+	a.triangle.stepTimer()
+	if got := a.triangle.sequenceIndex; got != 0 {
+		t.Errorf("Triangle sequence before the reload: got=%d, want=0", got)
+	}
+	a.quarterFrame()
+	if got := a.triangle.linearCounter; got != 2 {
+		t.Errorf("Linear counter after the reload: got=%d, want=2", got)
+	}
+	if a.triangle.linearCounterReset {
+		t.Error("Reload flag with the control flag clear: got=true, want=false")
+	}
+	a.triangle.stepTimer()
+	if got := a.triangle.sequenceIndex; got != 1 {
+		t.Errorf("Triangle sequence after the reload: got=%d, want=1", got)
+	}
+	// The note stops after 2 quarter frames, even though the length counter is still running.
+	a.quarterFrame()
+	a.quarterFrame()
+	if got := a.triangle.linearCounter; got != 0 {
+		t.Errorf("Linear counter after 2 quarter frames: got=%d, want=0", got)
+	}
+	for i := 0; i < 3*4; i++ {
+		a.triangle.stepTimer()
+	}
+	if got := a.triangle.sequenceIndex; got != 1 {
+		t.Errorf("Triangle sequence with the linear counter 0: got=%d, want=1", got)
+	}
+	// With the control flag set, the linear counter keeps reloading.
+	a.triangle.writeControl(0x82)
+	a.triangle.writeTimerHigh(0x08)
+	for i := 0; i < 5; i++ {
+		a.quarterFrame()
+	}
+	if got := a.triangle.linearCounter; got != 2 || !a.triangle.linearCounterReset {
+		t.Errorf("Linear counter with the control flag: got=(%d, %t), want=(2, true)", got, a.triangle.linearCounterReset)
+	}
+}
+
+func TestPulseDuty(t *testing.T) {
+	tests := []struct {
+		duty byte
+		want [8]byte
+	}{
+		{0, [8]byte{0, 1, 0, 0, 0, 0, 0, 0}},
+		{1, [8]byte{0, 1, 1, 0, 0, 0, 0, 0}},
+		{2, [8]byte{0, 1, 1, 1, 1, 0, 0, 0}},
+		{3, [8]byte{1, 0, 0, 1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		p := &pulse{dutyIndex: 5}
+		p.setEnabled(true)
+		p.writeControl(tt.duty<<6 | 0x10 | 0x0A) // constant volume 10
+		p.writeTimerLow(0x10)
+		p.writeTimerHigh(0x08) // restarts the sequencer, length counter = 254
+		var got [8]byte
+		for i := 0; i < 8; i++ {
+			if p.output() != 0 {
+				got[i] = 1
+			}
+			// The sequencer advances every period+1 timer clocks.
+			for j := 0; j < 0x11; j++ {
+				p.stepTimer()
+			}
+		}
+		if got != tt.want {
+			t.Errorf("Duty %d: got=%v, want=%v", tt.duty, got, tt.want)
+		}
+	}
+}
+
+func TestPulseMutedWithSmallPeriod(t *testing.T) {
+	p := &pulse{}
+	p.writeControl(0x3F)
+	p.writeTimerLow(0x07)
+	p.writeTimerHigh(0x00)
+	for i := 0; i < 100; i++ {
+		p.stepTimer()
+		if got := p.output(); got != 0 {
+			t.Fatalf("Pulse output with period 7: got=%d, want=0", got)
+		}
+	}
+}
+
+func TestPulseSweepNegate(t *testing.T) {
+	a := NewAPU()
+	for _, p := range []*pulse{&a.pulse1, &a.pulse2} {
+		p.writeTimerLow(0x00)
+		p.writeTimerHigh(0x01) // $100
+		p.writeSweep(0x89)     // enabled, period 0, negate, shift 1
+	}
+	// Pulse 1 uses one's complement: $100 - $80 - 1, pulse 2 uses two's complement: $100 - $80.
+	if got := a.pulse1.sweepTarget(); got != 0x7F {
+		t.Errorf("Pulse 1 target period: got=0x%03x, want=0x07f", got)
+	}
+	if got := a.pulse2.sweepTarget(); got != 0x80 {
+		t.Errorf("Pulse 2 target period: got=0x%03x, want=0x080", got)
+	}
+	a.halfFrame()
+	if a.pulse1.timerPeriod != 0x7F || a.pulse2.timerPeriod != 0x80 {
+		t.Errorf("Swept periods: got=0x%03x (pulse 1), 0x%03x (pulse 2), want=0x07f, 0x080", a.pulse1.timerPeriod, a.pulse2.timerPeriod)
+	}
+}
+
+func TestPulseSweepDivider(t *testing.T) {
+	p := &pulse{}
+	p.writeTimerLow(0x00)
+	p.writeTimerHigh(0x01) // $100
+	p.writeSweep(0xA4)     // enabled, period 2, shift 4
+	// The divider is 0 at first, so the first clock updates the period and reloads the divider with 2.
+	want := []uint16{0x110, 0x110, 0x110, 0x121, 0x121, 0x121, 0x133}
+	for i, w := range want {
+		p.stepSweep()
+		if p.timerPeriod != w {
+			t.Errorf("Period at half frame %d: got=0x%03x, want=0x%03x", i, p.timerPeriod, w)
+		}
+	}
+	// Writing the sweep register reloads the divider at the next clock without updating the period.
+	p.sweepDivider = 1
+	p.writeSweep(0xA4)
+	p.stepSweep()
+	if p.timerPeriod != 0x133 || p.sweepDivider != 2 {
+		t.Errorf("After reloading: got period=0x%03x, divider=%d, want period=0x133, divider=2", p.timerPeriod, p.sweepDivider)
+	}
+}
+
+func TestPulseSweepMute(t *testing.T) {
+	tests := []struct {
+		name   string
+		period uint16
+		sweep  byte
+		want   bool
+	}{
+		{"target overflows even if disabled", 0x600, 0x01, true},
+		{"target doesn't overflow", 0x3FF, 0x00, false},
+		{"negate never overflows", 0x7FF, 0x08, false},
+		{"small period", 0x007, 0x08, true},
+	}
+	for _, test := range tests {
+		p := &pulse{timerPeriod: test.period}
+		p.writeSweep(test.sweep)
+		if got := p.muted(); got != test.want {
+			t.Errorf("%s: got=%t, want=%t", test.name, got, test.want)
+		}
+	}
+	// The muted channel doesn't update the period.
+	p := &pulse{timerPeriod: 0x600}
+	p.writeSweep(0x81)
+	p.stepSweep()
+	if p.timerPeriod != 0x600 {
+		t.Errorf("Period of the muted channel: got=0x%03x, want=0x600", p.timerPeriod)
+	}
+}
+
+func TestPulseLengthCounter(t *testing.T) {
+	p := &pulse{}
+	p.setEnabled(true)
+	p.writeControl(0x1F)
+	p.writeTimerHigh(0x18) // length index 3 -> 2
+	if p.lengthCounter != 2 {
+		t.Fatalf("Length counter: got=%d, want=2", p.lengthCounter)
+	}
+	p.stepLength()
+	p.stepLength()
+	p.stepLength()
+	if p.lengthCounter != 0 {
+		t.Errorf("Length counter after 3 half frames: got=%d, want=0", p.lengthCounter)
+	}
+	// Halted
+	p.writeControl(0x3F)
+	p.writeTimerHigh(0x18)
+	p.stepLength()
+	if p.lengthCounter != 2 {
+		t.Errorf("Halted length counter: got=%d, want=2", p.lengthCounter)
+	}
+}
+
+func TestNoiseSequenceLength(t *testing.T) {
+	tests := []struct {
+		mode  bool
+		seed  uint16
+		wants []int
+	}{
+		{false, 1, []int{32767}},
+		// The short mode has two sequences of 93 and 31 steps depending on the seed.
+		{true, 1, []int{31, 93}},
+	}
+	for _, test := range tests {
+		n := &noise{mode: test.mode, shiftRegister: test.seed}
+		period := 0
+		for {
+			n.stepShiftRegister()
+			period++
+			if n.shiftRegister == test.seed || 32767 < period {
+				break
+			}
+		}
+		ok := false
+		for _, want := range test.wants {
+			ok = ok || period == want
+		}
+		if !ok {
+			t.Errorf("Noise sequence length with mode=%t: got=%d, want=%v", test.mode, period, test.wants)
+		}
+	}
+}
+
+func TestNoiseOutput(t *testing.T) {
+	n := &noise{shiftRegister: 1}
+	n.setEnabled(true)
+	n.writeControl(0x1A)
+	n.writePeriod(0x00)
+	n.writeLength(0x18) // length index 3 -> 2
+	if n.lengthCounter != 2 {
+		t.Fatalf("Length counter: got=%d, want=2", n.lengthCounter)
+	}
+	// Muted while bit 0 is set.
+	if got := n.output(); got != 0 {
+		t.Errorf("Output with bit 0 set: got=%d, want=0", got)
+	}
+	n.shiftRegister = 2
+	if got := n.output(); got != 10 {
+		t.Errorf("Output with bit 0 cleared: got=%d, want=10", got)
+	}
+	// The shift register is clocked every 4 CPU cycles with period index 0.
+	for i := 0; i < 4; i++ {
+		n.stepTimer()
+	}
+	// 0b10: the feedback is 0 XOR 1.
+	if n.shiftRegister != 0x4001 {
+		t.Errorf("Shift register after 4 cycles: got=0x%04x, want=0x4001", n.shiftRegister)
+	}
+	n.setEnabled(false)
+	n.shiftRegister = 2
+	if got := n.output(); got != 0 {
+		t.Errorf("Output while disabled: got=%d, want=0", got)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	e := &envelope{}
+	e.write(0x02) // period 2, not looped.
+	e.restart()
+	// The start flag resets the decay level to 15, then the decay level counts down every period+1 clocks.
+	want := []byte{15, 15, 15, 14, 14, 14, 13}
+	for i, w := range want {
+		e.step()
+		if got := e.output(); got != w {
+			t.Errorf("Envelope output at quarter frame %d: got=%d, want=%d", i, got, w)
+		}
+	}
+	// The decay level stays at 0 without the loop flag.
+	e.decay = 0
+	e.divider = 0
+	e.step()
+	if got := e.output(); got != 0 {
+		t.Errorf("Envelope output after decayed: got=%d, want=0", got)
+	}
+	// The constant volume mode outputs the volume nibble.
+	e.write(0x17)
+	if got := e.output(); got != 7 {
+		t.Errorf("Constant volume output: got=%d, want=7", got)
+	}
+}
+
+func TestEnvelopeRestartedByLengthLoad(t *testing.T) {
+	a := NewAPU()
+	a.writeControl(0x0F)
+	a.pulse1.writeTimerHigh(0x08)
+	a.pulse2.writeTimerHigh(0x08)
+	a.noise.writeLength(0x08)
+	a.quarterFrame()
+	for _, e := range []*envelope{&a.pulse1.envelope, &a.pulse2.envelope, &a.noise.envelope} {
+		if e.decay != 15 || e.start {
+			t.Errorf("Envelope after the length counter load: got decay=%d, start=%t, want decay=15, start=false", e.decay, e.start)
+		}
+	}
+}
+
+func TestLengthHaltAndEnvelopeLoop(t *testing.T) {
+	p := &pulse{}
+	p.setEnabled(true)
+	p.writeControl(0x20) // the shared bit with the envelope period 0.
+	p.writeTimerHigh(0x18)
+	n := &noise{}
+	n.setEnabled(true)
+	n.writeControl(0x20)
+	n.writeLength(0x18)
+	for _, e := range []*envelope{&p.envelope, &n.envelope} {
+		// The start flag set by the length counter load resets the decay level to 15.
+		e.step()
+		// The decay level counts down to 0 and loops back to 15.
+		e.decay = 1
+		e.step()
+		e.step()
+		if e.decay != 15 {
+			t.Errorf("Looped envelope decay: got=%d, want=15", e.decay)
+		}
+	}
+	p.stepLength()
+	n.stepLength()
+	if p.lengthCounter != 2 || n.lengthCounter != 2 {
+		t.Errorf("Halted length counters: got=%d (pulse), %d (noise), want=2", p.lengthCounter, n.lengthCounter)
+	}
+	// Clearing the bit stops both.
+	p.writeControl(0x00)
+	p.envelope.decay = 0
+	p.envelope.step()
+	if p.envelope.decay != 0 {
+		t.Errorf("Not looped envelope decay: got=%d, want=0", p.envelope.decay)
+	}
+	p.stepLength()
+	if p.lengthCounter != 1 {
+		t.Errorf("Not halted length counter: got=%d, want=1", p.lengthCounter)
+	}
+}
+
+func TestAPUStatus(t *testing.T) {
+	c := newTestCPUWithProgram(nil)
+	bus := c.bus
+	write := func(address uint16, data byte) {
+		if err := bus.write(address, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() byte {
+		data, err := bus.read(0x4015)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	write(0x4015, 0x07)
+	write(0x4003, 0x08)
+	write(0x400B, 0x08)
+	if got := read(); got != 0x05 {
+		t.Errorf("$4015 with pulse 1 and triangle: got=0x%02x, want=0x05", got)
+	}
+	write(0x4007, 0x08)
+	if got := read(); got != 0x07 {
+		t.Errorf("$4015 with all channels: got=0x%02x, want=0x07", got)
+	}
+	// Disabling clears the length counter.
+	write(0x4015, 0x02)
+	if got := read(); got != 0x02 {
+		t.Errorf("$4015 after disabling pulse 1 and triangle: got=0x%02x, want=0x02", got)
+	}
+	// The length counter isn't loaded while disabled.
+	write(0x4003, 0x08)
+	if got := read(); got != 0x02 {
+		t.Errorf("$4015 after writing $4003 while disabled: got=0x%02x, want=0x02", got)
+	}
+	write(0x4015, 0x08)
+	write(0x400F, 0x08)
+	if got := read(); got != 0x08 {
+		t.Errorf("$4015 with noise: got=0x%02x, want=0x08", got)
+	}
+}
+
+func TestFrameCounter(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        byte // $4017
+		wantLengths []byte
+		wantIRQ     bool
+	}{
+		// The length counters are clocked at 14913 and 29829.
+		{"4-step", 0x00, []byte{10, 10, 9, 9, 8}, true},
+		{"4-step IRQ inhibit", 0x40, []byte{10, 10, 9, 9, 8}, false},
+		// Clocked immediately by the write, then at 14913 and 37281.
+		{"5-step", 0x80, []byte{9, 9, 8, 8, 8, 7}, false},
+	}
+	for _, tt := range tests {
+		a := NewAPU()
+		a.writeControl(0x01)
+		a.pulse1.writeTimerHigh(0x58) // length index 11 -> 10
+		a.pulse1.writeTimerHigh(0x58)
+		a.writeFrameCounter(tt.data)
+		if got := a.pulse1.lengthCounter; got != tt.wantLengths[0] {
+			t.Errorf("%s: length counter after the $4017 write: got=%d, want=%d", tt.name, got, tt.wantLengths[0])
+		}
+		// Checks the length counter right before and at each step.
+		for i, cycle := range []int{14912, 14913, 29828, 29829, 37281}[:len(tt.wantLengths)-1] {
+			for int(a.cycle) < cycle {
+				a.Step()
+			}
+			if got := a.pulse1.lengthCounter; got != tt.wantLengths[i+1] {
+				t.Errorf("%s: length counter at cycle %d: got=%d, want=%d", tt.name, cycle, got, tt.wantLengths[i+1])
+			}
+		}
+		for a.cycle < 29829 {
+			a.Step()
+		}
+		if got := a.irq(); got != tt.wantIRQ {
+			t.Errorf("%s: IRQ: got=%t, want=%t", tt.name, got, tt.wantIRQ)
+		}
+		// Reading $4015 returns and clears the flag.
+		if got := a.readStatus()>>6&1 == 1; got != tt.wantIRQ {
+			t.Errorf("%s: $4015 bit 6: got=%t, want=%t", tt.name, got, tt.wantIRQ)
+		}
+		if a.irq() {
+			t.Errorf("%s: IRQ was not cleared by reading $4015", tt.name)
+		}
+	}
+}
+
+func TestAPUState(t *testing.T) {
+	a := NewAPU()
+	a.writeControl(0x04)
+	a.triangle.writeControl(0x81)
+	a.triangle.writeTimerLow(0x20)
+	a.triangle.writeTimerHigh(0x01)
+	a.writeControl(0x05)
+	a.pulse1.writeControl(0xBF)
+	a.pulse1.writeTimerLow(0x40)
+	a.pulse1.writeTimerHigh(0x08)
+	a.writeControl(0x0D)
+	a.noise.writeControl(0x3F)
+	a.noise.writePeriod(0x83)
+	a.noise.writeLength(0x08)
+	a.dmc.writeControl(0x4F)
+	a.dmc.writeLength(0x01)
+	a.writeControl(0x1D)
+	// mid-note
+	for i := 0; i < 1000; i++ {
+		a.Step()
+	}
+	want := *a
+	w := &stateWriter{}
+	a.saveState(w)
+	data, err := w.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		a.Step()
+	}
+	r := newStateReader(data)
+	a.loadState(r)
+	if err := r.error(); err != nil {
+		t.Fatal(err)
+	}
+	if *a != want {
+		t.Errorf("Restored APU: got=%+v, want=%+v", *a, want)
+	}
+}
+
+func TestTriangleDisabled(t *testing.T) {
+	a := NewAPU()
+	a.writeControl(0x04)
+	a.triangle.writeTimerLow(2)
+	a.triangle.writeControl(0x7F)
+	a.triangle.writeTimerHigh(0x08) // length counter = 254
+	a.triangle.stepLinear()
+	for i := 0; i < 3*5; i++ {
+		a.Step()
+	}
+	index := a.triangle.sequenceIndex
+	timer := a.triangle.timer
+	a.writeControl(0x00)
+	if a.triangle.lengthCounter != 0 {
+		t.Errorf("Length counter after disabling: got=%d, want=0", a.triangle.lengthCounter)
+	}
+	for i := 0; i < 3*5; i++ {
+		a.Step()
+		if got := a.triangle.output(); got != 0 {
+			t.Fatalf("Triangle output while disabled: got=%d, want=0", got)
+		}
+	}
+	if a.triangle.sequenceIndex != index || a.triangle.timer != timer {
+		t.Errorf("Disabling changed the phase: sequence=%d, timer=%d, want sequence=%d, timer=%d",
+			a.triangle.sequenceIndex, a.triangle.timer, index, timer)
+	}
+	// Writing $400B while disabled doesn't reload the length counter.
+	a.triangle.writeTimerHigh(0x08)
+	if a.triangle.lengthCounter != 0 {
+		t.Errorf("Length counter reloaded while disabled: got=%d, want=0", a.triangle.lengthCounter)
+	}
+	a.writeControl(0x04)
+	a.triangle.writeTimerHigh(0x08)
+	if got, want := a.triangle.output(), triangleSequence[index]; got != want {
+		t.Errorf("Triangle output after re-enabling: got=%d, want=%d", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		a.Step()
+	}
+	if got, want := a.triangle.sequenceIndex, (index+1)%32; got != want {
+		t.Errorf("Triangle sequence after re-enabling: got=%d, want=%d", got, want)
+	}
+}
+
+func TestMixer(t *testing.T) {
+	a := NewAPU()
+	if got := a.output(); got != 0 {
+		t.Errorf("Output with all channels silent: got=%f, want=0", got)
+	}
+	// The maximum output is about 1.
+	a.writeControl(0x0F)
+	a.pulse1.writeControl(0x1F)
+	a.pulse1.writeTimerLow(0x10)
+	a.pulse1.writeTimerHigh(0x08)
+	a.pulse1.dutyIndex = 3 // 50%, the duty is high.
+	a.pulse1.duty = 2
+	a.pulse2 = a.pulse1
+	a.triangle.sequenceIndex = 0
+	a.noise.writeControl(0x1F)
+	a.noise.writeLength(0x08)
+	a.noise.shiftRegister = 2
+	a.dmc.writeLevel(0x7F)
+	if got := a.output(); got < 0.99 || 1.01 < got {
+		t.Errorf("Output with all channels at the maximum: got=%f, want=~1", got)
+	}
+	// The non-linear mixer: doubling the pulse doesn't double the output.
+	a = NewAPU()
+	a.writeControl(0x01)
+	a.pulse1.writeControl(0x1F)
+	a.pulse1.writeTimerLow(0x10)
+	a.pulse1.writeTimerHigh(0x08)
+	a.pulse1.duty, a.pulse1.dutyIndex = 2, 3
+	one := a.output()
+	a.pulse2 = a.pulse1
+	a.pulse2.enabled = true
+	two := a.output()
+	if !(one < two && two < 2*one) {
+		t.Errorf("Output with 2 pulses: got=%f, want between %f and %f", two, one, 2*one)
+	}
+	// The triangle is louder than a pulse at the same volume.
+	a = NewAPU()
+	a.triangle.sequenceIndex = 0
+	a.triangle.setEnabled(true)
+	if tri := a.output(); tri <= one {
+		t.Errorf("Triangle output: got=%f, want > %f (a pulse)", tri, one)
+	}
+}
+
+// TestMixerTables compares the lookup tables with the formulas of the non-linear mixer.
+// https://www.nesdev.org/wiki/APU_Mixer
+func TestMixerTables(t *testing.T) {
+	for n := 0; n <= 30; n++ {
+		want := 0.0
+		if n != 0 {
+			want = 95.88 / (8128/float64(n) + 100)
+		}
+		if got := float64(pulseTable[n]); 1e-6 < math.Abs(got-want) {
+			t.Errorf("pulseTable[%d]: got=%f, want=%f", n, got, want)
+		}
+	}
+	for n := 0; n <= 202; n++ {
+		want := 0.0
+		if n != 0 {
+			want = 163.67 / (24329/float64(n) + 100)
+		}
+		if got := float64(tndTable[n]); 1e-6 < math.Abs(got-want) {
+			t.Errorf("tndTable[%d]: got=%f, want=%f", n, got, want)
+		}
+	}
+}
+
+func TestAPUSampleRate(t *testing.T) {
+	for _, rate := range []int{44100, 48000, 22050} {
+		a := NewAPU()
+		out := make(chan float32, 2*rate+2)
+		a.SetAudioOut(out, rate)
+		// A second of CPU cycles emits exactly the sample rate, the fraction isn't dropped.
+		for i := 0; i < CPUFrequency; i++ {
+			a.Step()
+		}
+		if got, want := len(out), 2*rate; got != want {
+			t.Errorf("Samples in a second at %dHz: got=%d, want=%d", rate, got, want)
+		}
+	}
+}
+
+// BenchmarkAPUStep runs the APU for a second of CPU cycles.
+// The mixer lookup tables are about 12% faster than the formulas per sample, the medians of 5 runs on the same machine:
+//   formulas: 47163265 ns/op
+//   tables:   41310819 ns/op
+func BenchmarkAPUStep(b *testing.B) {
+	a := NewAPU()
+	a.writeControl(0x1F)
+	a.triangle.writeTimerLow(0xFD)
+	a.triangle.writeTimerHigh(0x08)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < CPUFrequency; j++ {
+			a.Step()
+		}
+	}
+}
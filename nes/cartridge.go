@@ -1,29 +1,68 @@
 package nes
 
-import "fmt"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+)
 
 const (
 	chrROMSizeUnit      int  = 0x2000 // 8 bytes
 	prgROMSizeUnit      int  = 0x4000 // 16 bytes
 	inesHeaderSizeBytes int  = 16     // The valid INES header has 16 bytes
 	msDOSEOF            byte = 0x1A
+	prgRAMSize          int  = 0x2000 // $6000-$7FFF
+	trainerSizeBytes    int  = 512    // The trainer is loaded to $7000-$71FF
 )
 
-type tableMirrorMode int
+// MirrorMode is the name table mirroring, this may be changed at runtime by the mapper.
+// https://www.nesdev.org/wiki/Mirroring#Nametable_Mirroring
+type MirrorMode int
 
 const (
-	horizontal tableMirrorMode = iota
+	horizontal MirrorMode = iota
 	vertical
+	fourScreen       // uses 2KB extra VRAM on the cartridge.
+	singleScreenLow  // all name tables use the first 1KB of the VRAM.
+	singleScreenHigh // all name tables use the second 1KB of the VRAM.
 )
 
+func (m MirrorMode) String() string {
+	switch m {
+	case horizontal:
+		return "horizontal"
+	case vertical:
+		return "vertical"
+	case fourScreen:
+		return "four-screen"
+	case singleScreenLow:
+		return "single-screen low"
+	case singleScreenHigh:
+		return "single-screen high"
+	}
+	return fmt.Sprintf("MirrorMode(%d)", int(m))
+}
+
 // https://www.nesdev.org/wiki/INES
 type Cartridge struct {
 	Mapper
+	prgROM  []byte
+	chrROM  []byte
+	chrRAM  bool // true if the board has CHR RAM instead of CHR ROM, chrROM is used as the RAM.
+	prgRAM  []byte
 	flags6  byte // https://www.nesdev.org/wiki/INES#Flags_6
 	flags7  byte // https://www.nesdev.org/wiki/INES#Flags_7
 	flags8  byte // https://www.nesdev.org/wiki/INES#Flags_8
 	flags9  byte // https://www.nesdev.org/wiki/INES#Flags_9
 	flags10 byte // https://www.nesdev.org/wiki/INES#Flags_10
+	flags12 byte // https://www.nesdev.org/wiki/NES_2.0#CPU/PPU_Timing
+	// servePRGRAM is true if the cartridge serves PRG RAM ($6000-$7FFF) for a mapper which doesn't handle it.
+	servePRGRAM bool
+	// savedSRAM is PRG RAM at the last LoadSRAM or SaveSRAM, to tell whether it needs to be saved.
+	savedSRAM []byte
 }
 
 // IsValid checks whether the cartridge is valid INES format.
@@ -39,31 +78,118 @@ func isValid(data []byte) bool {
 	}
 }
 
+// hasTrainer returns true if flags6 bit 2 is set, then 512 bytes trainer is placed between the header and PRG ROM.
+// https://www.nesdev.org/wiki/INES#Trainer
+func hasTrainer(data []byte) bool {
+	return data[6]&4 == 4
+}
+
+// prgROMOffset returns the offset of PRG ROM in the file.
+func prgROMOffset(data []byte) int {
+	if hasTrainer(data) {
+		return inesHeaderSizeBytes + trainerSizeBytes
+	}
+	return inesHeaderSizeBytes
+}
+
+// isNES20Header returns true if the header is NES 2.0 format, flags7 bit 2-3 = %10.
+// https://www.nesdev.org/wiki/NES_2.0#Identification
+func isNES20Header(data []byte) bool {
+	return data[7]&0x0C == 0x08
+}
+
+// romSize returns the ROM size in bytes, NES 2.0 extends the size in byte 4/5 with a nibble of byte 9.
+// https://www.nesdev.org/wiki/NES_2.0#PRG-ROM_Area
+func romSize(lsb byte, msb byte, unit int) int {
+	if msb != 0x0F {
+		return (int(msb)<<8 | int(lsb)) * unit
+	}
+	// Exponent-multiplier notation, lsb is EEEEEEMM and the size is 2^E * (MM*2+1) bytes.
+	e := lsb >> 2
+	if 30 < e {
+		// Too large for any ROM file, this fails the size check.
+		return math.MaxInt32
+	}
+	return (1 << e) * int(lsb&3*2+1)
+}
+
+func prgROMSize(data []byte) int {
+	if isNES20Header(data) {
+		return romSize(data[4], data[9]&0x0F, prgROMSizeUnit)
+	}
+	return int(data[4]) * prgROMSizeUnit
+}
+
+func chrROMSize(data []byte) int {
+	if isNES20Header(data) {
+		return romSize(data[5], data[9]>>4, chrROMSizeUnit)
+	}
+	return int(data[5]) * chrROMSizeUnit
+}
+
+// ramSize returns the larger RAM size of the volatile (low nibble) and the non-volatile (high nibble) one.
+// Each nibble is a shift count, the size is 64 << shift bytes or 0 if the shift is 0.
+// https://www.nesdev.org/wiki/NES_2.0#PRG-(NV)RAM/EEPROM
+func ramSize(shifts byte) int {
+	size := 0
+	for _, shift := range []byte{shifts & 0x0F, shifts >> 4} {
+		if shift != 0 && size < 64<<shift {
+			size = 64 << shift
+		}
+	}
+	return size
+}
+
 // ReadPRGROM retrieves Program ROM from cartridge.
 func readPRGROM(data []byte) []byte {
-	var l = inesHeaderSizeBytes
-	var r = inesHeaderSizeBytes + int(data[4])*prgROMSizeUnit
+	var l = prgROMOffset(data)
+	var r = l + prgROMSize(data)
 	return data[l:r]
 }
 
 // ReadCHRROM retrieves Character ROM from cartridge.
 func readCHRROM(data []byte) []byte {
-	var l = inesHeaderSizeBytes + int(data[4])*prgROMSizeUnit
-	var r = l + int(data[5])*chrROMSizeUnit
+	var l = prgROMOffset(data) + prgROMSize(data)
+	var r = l + chrROMSize(data)
 	return data[l:r]
 }
 
-func (c *Cartridge) Mirror() tableMirrorMode {
-	if c.flags6&1 == 1 {
+// mirrorer is implemented by mappers which control the name table mirroring.
+type mirrorer interface {
+	// mirror returns the mirroring mode, false if the mapper follows the header.
+	mirror() (MirrorMode, bool)
+}
+
+// Mirror returns the current mirroring, the mapper is queried on each call since it can switch the mirroring.
+func (c *Cartridge) Mirror() MirrorMode {
+	if m, ok := c.Mapper.(mirrorer); ok {
+		if mode, ok := m.mirror(); ok {
+			return mode
+		}
+	}
+	if c.flags6&8 == 8 {
+		return fourScreen
+	} else if c.flags6&1 == 1 {
 		return vertical
 	} else {
 		return horizontal
 	}
 }
 
-func (c *Cartridge) MapperIndex() byte {
-	l := c.flags6 & 0xF0
-	h := c.flags7 & 0xF0
+// isNES20 returns true if the header is NES 2.0 format.
+// https://www.nesdev.org/wiki/NES_2.0#Identification
+func (c *Cartridge) isNES20() bool {
+	return c.flags7&0x0C == 0x08
+}
+
+// MapperIndex returns the mapper number, NES 2.0 extends it to 12 bits with flags8 bit 0-3.
+// https://www.nesdev.org/wiki/NES_2.0#Byte_8_(Mapper_MSB/Submapper)
+func (c *Cartridge) MapperIndex() uint16 {
+	l := uint16(c.flags6 & 0xF0)
+	h := uint16(c.flags7 & 0xF0)
+	if c.isNES20() {
+		h |= uint16(c.flags8&0x0F) << 8
+	}
 	return h | (l >> 4)
 }
 
@@ -78,9 +204,167 @@ func NewCartridge(data []byte) (*Cartridge, error) {
 	c.flags8 = data[8]
 	c.flags9 = data[9]
 	c.flags10 = data[10]
-	c.Mapper = NewMapper(c.MapperIndex(), readPRGROM(data), readCHRROM(data))
-	if c.Mapper == nil {
-		return nil, fmt.Errorf("Mapper%d is not implemented.", c.MapperIndex())
+	c.flags12 = data[12]
+	if size := prgROMOffset(data) + prgROMSize(data) + chrROMSize(data); len(data) < size {
+		return nil, fmt.Errorf("The ROM is too short: got=%d bytes, want=%d bytes", len(data), size)
 	}
+	// The exponent-multiplier notation can declare any size, but the mappers switch banks in the units.
+	if size := prgROMSize(data); size%prgROMSizeUnit != 0 {
+		return nil, fmt.Errorf("The PRG ROM size is not a multiple of 16KB: got=%d bytes", size)
+	}
+	if size := chrROMSize(data); size%chrROMSizeUnit != 0 {
+		return nil, fmt.Errorf("The CHR ROM size is not a multiple of 8KB: got=%d bytes", size)
+	}
+	// The ROMs are copied not to share the buffer of the caller, since UNROM 512 flashes PRG ROM and OverrideCHR writes CHR ROM.
+	c.prgROM = append([]byte{}, readPRGROM(data)...)
+	c.chrROM = append([]byte{}, readCHRROM(data)...)
+	if len(c.chrROM) == 0 {
+		// 0 CHR ROM banks means the board has 8KB CHR RAM, it is allocated here so that
+		// pattern table reads before the game writes CHR return 0.
+		c.chrROM = make([]byte, chrROMSizeUnit)
+		c.chrRAM = true
+		if isNES20Header(data) && chrROMSizeUnit < ramSize(data[11]) {
+			// NES 2.0 may declare larger CHR RAM.
+			c.chrROM = make([]byte, ramSize(data[11]))
+		}
+	}
+	c.prgRAM = make([]byte, prgRAMSize)
+	if isNES20Header(data) && prgRAMSize < ramSize(data[10]) {
+		// NES 2.0 may declare larger PRG RAM, mappers use the first 8KB unless they bank it.
+		c.prgRAM = make([]byte, ramSize(data[10]))
+	}
+	if hasTrainer(data) {
+		copy(c.prgRAM[0x1000:], data[inesHeaderSizeBytes:inesHeaderSizeBytes+trainerSizeBytes])
+	}
+	c.savedSRAM = append([]byte{}, c.prgRAM...)
+	mapper, err := NewMapper(c.MapperIndex(), c.prgROM, c.chrROM, board{prgRAM: c.prgRAM, chrRAM: c.chrRAM, flags6: c.flags6})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a mapper: %w", err)
+	}
+	c.Mapper = mapper
+	// The battery only decides whether PRG RAM is persisted, $6000-$7FFF is PRG RAM for any mapper which doesn't control it.
+	_, controlled := mapper.(prgRAMController)
+	c.servePRGRAM = !controlled
 	return c, nil
 }
+
+// ReadFromCPU reads PRG RAM if the cartridge serves it, otherwise the mapper handles the read.
+func (c *Cartridge) ReadFromCPU(address uint16) (byte, error) {
+	if c.servePRGRAM && 0x6000 <= address && address < 0x8000 {
+		return c.prgRAM[address-0x6000], nil
+	}
+	return c.Mapper.ReadFromCPU(address)
+}
+
+// WriteFromCPU writes PRG RAM if the cartridge serves it, otherwise the mapper handles the write.
+func (c *Cartridge) WriteFromCPU(address uint16, data byte) error {
+	if c.servePRGRAM && 0x6000 <= address && address < 0x8000 {
+		c.prgRAM[address-0x6000] = data
+		return nil
+	}
+	return c.Mapper.WriteFromCPU(address, data)
+}
+
+// HasBattery returns true if the header has the battery bit (flags6 bit 1), then PRG RAM should be persisted.
+func (c *Cartridge) HasBattery() bool {
+	if c.MapperIndex() == 30 {
+		// UNROM 512 uses the bit for the self-flashable PRG ROM.
+		// TODO(jyane): persist the flashed PRG ROM.
+		return false
+	}
+	return c.flags6&2 == 2
+}
+
+// LoadSRAM loads the battery-backed PRG RAM from the file, this does nothing if the cartridge has no battery
+// or the file doesn't exist yet.
+func (c *Cartridge) LoadSRAM(path string) error {
+	if !c.HasBattery() {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read SRAM: %w", err)
+	}
+	if len(data) != len(c.prgRAM) {
+		return fmt.Errorf("SRAM size mismatch: %s has %d bytes, want=%d bytes", path, len(data), len(c.prgRAM))
+	}
+	copy(c.prgRAM, data)
+	c.savedSRAM = append(c.savedSRAM[:0], c.prgRAM...)
+	return nil
+}
+
+// SRAMChanged returns true if the battery-backed PRG RAM has been changed since the last LoadSRAM or SaveSRAM.
+func (c *Cartridge) SRAMChanged() bool {
+	return c.HasBattery() && !bytes.Equal(c.prgRAM, c.savedSRAM)
+}
+
+// SaveSRAM saves the battery-backed PRG RAM to the file, this does nothing if the cartridge has no battery.
+// The data is written to a temporary file first, so that a failure doesn't corrupt the existing save.
+func (c *Cartridge) SaveSRAM(path string) error {
+	if !c.HasBattery() {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, c.prgRAM, 0644); err != nil {
+		return fmt.Errorf("Failed to write SRAM: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Failed to write SRAM: %w", err)
+	}
+	c.savedSRAM = append(c.savedSRAM[:0], c.prgRAM...)
+	return nil
+}
+
+// saveState saves PRG RAM, CHR RAM and the mapper state.
+func (c *Cartridge) saveState(w *stateWriter) {
+	w.write(c.prgRAM)
+	if c.chrRAM {
+		w.write(c.chrROM)
+	}
+	if m, ok := c.Mapper.(stateSaver); ok {
+		m.saveState(w)
+	}
+}
+
+func (c *Cartridge) loadState(r *stateReader) {
+	r.read(c.prgRAM)
+	if c.chrRAM {
+		r.read(c.chrROM)
+	}
+	if m, ok := c.Mapper.(stateSaver); ok {
+		m.loadState(r)
+	}
+}
+
+// PRGROM returns a copy of the PRG ROM.
+func (c *Cartridge) PRGROM() []byte {
+	return append([]byte{}, c.prgROM...)
+}
+
+// CHRROM returns a copy of the CHR ROM, this returns an empty slice if the cartridge has CHR RAM.
+func (c *Cartridge) CHRROM() []byte {
+	if c.chrRAM {
+		return []byte{}
+	}
+	return append([]byte{}, c.chrROM...)
+}
+
+// OverrideCHR replaces the CHR ROM with the given data, e.g. graphics of ROM hacks.
+// The data must have the same size as the CHR ROM of the cartridge.
+func (c *Cartridge) OverrideCHR(data []byte) error {
+	if c.chrRAM {
+		return fmt.Errorf("The cartridge has no CHR ROM to override.")
+	}
+	if _, ok := c.Mapper.(chrOwner); ok {
+		return fmt.Errorf("%s doesn't use CHR ROM, the CHR can't be overridden", c.Mapper.Name())
+	}
+	if len(data) != len(c.chrROM) {
+		return fmt.Errorf("CHR size mismatch: got=%d bytes, want=%d bytes", len(data), len(c.chrROM))
+	}
+	// The mapper reads the CHR ROM buffer of the cartridge.
+	copy(c.chrROM, data)
+	return nil
+}
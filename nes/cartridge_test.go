@@ -0,0 +1,390 @@
+package nes
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverrideCHR(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+	override := make([]byte, chrROMSizeUnit)
+	for i := range override {
+		override[i] = byte(i * 7)
+	}
+	if err := cartridge.OverrideCHR(override); err != nil {
+		t.Fatalf("OverrideCHR returned an error: %v", err)
+	}
+	for _, address := range []uint16{0x0000, 0x0001, 0x0FFF, 0x1FFF} {
+		got, err := cartridge.ReadFromPPU(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != override[address] {
+			t.Errorf("ReadFromPPU(0x%04x): got=0x%02x, want=0x%02x", address, got, override[address])
+		}
+	}
+}
+
+func TestOverrideCHRKeepsROMData(t *testing.T) {
+	header := []byte{'N', 'E', 'S', msDOSEOF, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(append(header, make([]byte, prgROMSizeUnit)...), make([]byte, chrROMSizeUnit)...)
+	cartridge, err := NewCartridge(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	override := bytes.Repeat([]byte{0xFF}, chrROMSizeUnit)
+	if err := cartridge.OverrideCHR(override); err != nil {
+		t.Fatal(err)
+	}
+	if chr := data[len(data)-chrROMSizeUnit:]; !bytes.Equal(chr, make([]byte, chrROMSizeUnit)) {
+		t.Errorf("OverrideCHR changed the ROM data given to NewCartridge")
+	}
+}
+
+func TestOverrideCHRUnusedCHR(t *testing.T) {
+	// UxROM and UNROM 512 have their own CHR RAM even if the header declares CHR ROM.
+	for _, mapper := range []byte{2, 30} {
+		cartridge := newTestCartridge(mapper, make([]byte, prgROMSizeUnit*2), make([]byte, chrROMSizeUnit))
+		if err := cartridge.OverrideCHR(make([]byte, chrROMSizeUnit)); err == nil {
+			t.Errorf("Mapper%d: OverrideCHR on a mapper which ignores the CHR ROM returned no error", mapper)
+		}
+	}
+}
+
+func TestOverrideCHRSizeMismatch(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	if err := cartridge.OverrideCHR(make([]byte, chrROMSizeUnit/2)); err == nil {
+		t.Errorf("OverrideCHR with a smaller CHR returned no error")
+	}
+}
+
+func TestCHRRAMReadBeforeWrite(t *testing.T) {
+	for _, mapper := range []byte{0, 2} {
+		cartridge := newTestCartridge(mapper, make([]byte, prgROMSizeUnit*2), nil)
+		for _, address := range []uint16{0x0000, 0x0FFF, 0x1000, 0x1FFF} {
+			got, err := cartridge.ReadFromPPU(address)
+			if err != nil {
+				t.Fatalf("Mapper%d: %v", mapper, err)
+			}
+			if got != 0 {
+				t.Errorf("Mapper%d: ReadFromPPU(0x%04x): got=0x%02x, want=0x00", mapper, address, got)
+			}
+		}
+		// The pattern tables are writable.
+		if err := cartridge.WriteFromPPU(0x1234, 0x5A); err != nil {
+			t.Fatalf("Mapper%d: %v", mapper, err)
+		}
+		if got, err := cartridge.ReadFromPPU(0x1234); err != nil || got != 0x5A {
+			t.Errorf("Mapper%d: ReadFromPPU(0x1234) after the write: got=0x%02x, %v, want=0x5a", mapper, got, err)
+		}
+		if err := cartridge.OverrideCHR(make([]byte, chrROMSizeUnit)); err == nil {
+			t.Errorf("Mapper%d: OverrideCHR on a CHR RAM board returned no error", mapper)
+		}
+	}
+}
+
+func TestPRGROMAndCHRROM(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit*2)
+	for i := range prgROM {
+		prgROM[i] = byte(i * 3)
+	}
+	chrROM := make([]byte, chrROMSizeUnit)
+	for i := range chrROM {
+		chrROM[i] = byte(i * 5)
+	}
+	cartridge := newTestCartridge(0, prgROM, chrROM)
+	if got := cartridge.PRGROM(); !bytes.Equal(got, prgROM) {
+		t.Errorf("PRGROM doesn't match the PRG ROM of the input")
+	}
+	if got := cartridge.CHRROM(); !bytes.Equal(got, chrROM) {
+		t.Errorf("CHRROM doesn't match the CHR ROM of the input")
+	}
+	// The returned bytes are copies.
+	cartridge.PRGROM()[0] = 0xFF
+	cartridge.CHRROM()[1] = 0xFF
+	if cartridge.prgROM[0] != prgROM[0] || cartridge.chrROM[1] != chrROM[1] {
+		t.Errorf("Modifying the returned bytes changed the cartridge")
+	}
+	if got := newTestCartridge(0, prgROM, nil).CHRROM(); len(got) != 0 {
+		t.Errorf("CHRROM of a CHR RAM cartridge: got=%d bytes, want=0 bytes", len(got))
+	}
+}
+
+func newTestCartridgeWithFlags6(mapper byte, flags6 byte) *Cartridge {
+	header := []byte{'N', 'E', 'S', msDOSEOF, 2, 1, (mapper&0x0F)<<4 | flags6, mapper & 0xF0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(append(header, make([]byte, prgROMSizeUnit*2)...), make([]byte, chrROMSizeUnit)...)
+	cartridge, err := NewCartridge(data)
+	if err != nil {
+		panic(err)
+	}
+	return cartridge
+}
+
+func TestSRAMSaveAndLoad(t *testing.T) {
+	// MMC1 controls PRG RAM, NROM uses the cartridge's PRG RAM.
+	for _, mapper := range []byte{0, 1, 4} {
+		path := filepath.Join(t.TempDir(), "game.sav")
+		cartridge := newTestCartridgeWithFlags6(mapper, 0x02)
+		if err := cartridge.WriteFromCPU(0x6123, 0x42); err != nil {
+			t.Fatalf("Mapper%d: %v", mapper, err)
+		}
+		if err := cartridge.SaveSRAM(path); err != nil {
+			t.Fatalf("Mapper%d: %v", mapper, err)
+		}
+		loaded := newTestCartridgeWithFlags6(mapper, 0x02)
+		if err := loaded.LoadSRAM(path); err != nil {
+			t.Fatalf("Mapper%d: %v", mapper, err)
+		}
+		if got, _ := loaded.ReadFromCPU(0x6123); got != 0x42 {
+			t.Errorf("Mapper%d: $6123 after loading: got=0x%02x, want=0x42", mapper, got)
+		}
+	}
+}
+
+func TestSRAMChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.sav")
+	cartridge := newTestCartridgeWithFlags6(1, 0x02)
+	if cartridge.SRAMChanged() {
+		t.Error("SRAMChanged before writes: got=true, want=false")
+	}
+	if err := cartridge.WriteFromCPU(0x6000, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	if !cartridge.SRAMChanged() {
+		t.Error("SRAMChanged after a write: got=false, want=true")
+	}
+	if err := cartridge.SaveSRAM(path); err != nil {
+		t.Fatal(err)
+	}
+	if cartridge.SRAMChanged() {
+		t.Error("SRAMChanged after SaveSRAM: got=true, want=false")
+	}
+	// PRG RAM without the battery is never saved.
+	noBattery := newTestCartridgeWithFlags6(1, 0)
+	if err := noBattery.WriteFromCPU(0x6000, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	if noBattery.SRAMChanged() {
+		t.Error("SRAMChanged without the battery: got=true, want=false")
+	}
+}
+
+func TestSRAMWithoutBattery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.sav")
+	save := bytes.Repeat([]byte{0xAA}, prgRAMSize)
+	if err := os.WriteFile(path, save, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cartridge := newTestCartridgeWithFlags6(1, 0)
+	// The existing save is neither loaded nor overwritten.
+	if err := cartridge.LoadSRAM(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromCPU(0x6000); got != 0 {
+		t.Errorf("$6000 without the battery: got=0x%02x, want=0x00", got)
+	}
+	if err := cartridge.SaveSRAM(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, save) {
+		t.Errorf("The save file was overwritten without the battery")
+	}
+}
+
+func TestPRGRAMWithoutBattery(t *testing.T) {
+	// The cartridge serves PRG RAM for the mappers which don't control it.
+	for _, mapper := range []byte{0, 2, 3, 7, 30} {
+		cartridge := newTestCartridgeWithFlags6(mapper, 0)
+		if err := cartridge.WriteFromCPU(0x7FFF, 0x42); err != nil {
+			t.Fatalf("Mapper%d: %v", mapper, err)
+		}
+		if got, err := cartridge.ReadFromCPU(0x7FFF); err != nil || got != 0x42 {
+			t.Errorf("Mapper%d: $7FFF: got=0x%02x, %v, want=0x42", mapper, got, err)
+		}
+	}
+}
+
+func TestSRAMLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	cartridge := newTestCartridgeWithFlags6(1, 0x02)
+	// The first run has no save file.
+	if err := cartridge.LoadSRAM(filepath.Join(dir, "missing.sav")); err != nil {
+		t.Errorf("Loading a missing save: got=%v, want no error", err)
+	}
+	path := filepath.Join(dir, "short.sav")
+	if err := os.WriteFile(path, []byte{1, 2, 3}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cartridge.LoadSRAM(path); err == nil {
+		t.Errorf("Loading a save with a wrong size returned no error")
+	}
+}
+
+func TestTrainer(t *testing.T) {
+	header := []byte{'N', 'E', 'S', msDOSEOF, 1, 1, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	trainer := make([]byte, trainerSizeBytes)
+	for i := range trainer {
+		trainer[i] = byte(i)
+	}
+	prgROM := make([]byte, prgROMSizeUnit)
+	prgROM[0] = 0x11
+	chrROM := make([]byte, chrROMSizeUnit)
+	chrROM[0] = 0x22
+	data := append(append(append(header, trainer...), prgROM...), chrROM...)
+	cartridge, err := NewCartridge(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromCPU(0x8000); got != 0x11 {
+		t.Errorf("PRG ROM $8000: got=0x%02x, want=0x11", got)
+	}
+	if got, _ := cartridge.ReadFromPPU(0x0000); got != 0x22 {
+		t.Errorf("CHR ROM $0000: got=0x%02x, want=0x22", got)
+	}
+	// The trainer is loaded to $7000-$71FF.
+	for _, address := range []uint16{0x7000, 0x7001, 0x71FF} {
+		got, err := cartridge.ReadFromCPU(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := trainer[address-0x7000]; got != want {
+			t.Errorf("Trainer 0x%04x: got=0x%02x, want=0x%02x", address, got, want)
+		}
+	}
+	// Without the trainer bytes the ROM is too short.
+	if _, err := NewCartridge(append(header, append(prgROM, chrROM...)...)); err == nil {
+		t.Errorf("NewCartridge returned no error for a ROM without the trainer bytes")
+	}
+}
+
+func TestNES20Header(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      [16]byte
+		size        int // bytes after the header
+		wantMapper  uint16
+		wantPRGROM  int
+		wantCHRROM  int
+		wantCHRRAM  int
+		wantPRGRAM  int
+		wantErrored bool
+		wantErr     string // a part of the error message if set
+	}{
+		{
+			name:       "iNES ignores flags8 and flags9",
+			header:     [16]byte{'N', 'E', 'S', msDOSEOF, 2, 1, 0x10, 0x00, 0x01, 0x10},
+			size:       prgROMSizeUnit*2 + chrROMSizeUnit,
+			wantMapper: 1,
+			wantPRGROM: prgROMSizeUnit * 2,
+			wantCHRROM: chrROMSizeUnit,
+			wantPRGRAM: prgRAMSize,
+		},
+		{
+			name:        "NES 2.0 mapper beyond 255",
+			header:      [16]byte{'N', 'E', 'S', msDOSEOF, 2, 1, 0x10, 0x08, 0x01},
+			size:        prgROMSizeUnit*2 + chrROMSizeUnit,
+			wantMapper:  0x101,
+			wantErrored: true,
+		},
+		{
+			// The PRG ROM size is 0x102 * 16KB.
+			name:       "NES 2.0 ROM size MSB",
+			header:     [16]byte{'N', 'E', 'S', msDOSEOF, 0x02, 0x00, 0x40, 0x08, 0x00, 0x01},
+			size:       prgROMSizeUnit * 0x102,
+			wantMapper: 4,
+			wantPRGROM: prgROMSizeUnit * 0x102,
+			wantCHRRAM: chrROMSizeUnit,
+			wantPRGRAM: prgRAMSize,
+		},
+		{
+			// 2^15 * 1 = 32KB PRG ROM in the exponent-multiplier notation.
+			name:       "NES 2.0 exponent-multiplier",
+			header:     [16]byte{'N', 'E', 'S', msDOSEOF, 15 << 2, 1, 0x10, 0x08, 0x00, 0x0F},
+			size:       prgROMSizeUnit*2 + chrROMSizeUnit,
+			wantMapper: 1,
+			wantPRGROM: prgROMSizeUnit * 2,
+			wantCHRROM: chrROMSizeUnit,
+			wantPRGRAM: prgRAMSize,
+		},
+		{
+			// 2^13 * 1 = 8KB PRG ROM is not a whole 16KB bank.
+			name:        "NES 2.0 exponent-multiplier PRG ROM not in 16KB",
+			header:      [16]byte{'N', 'E', 'S', msDOSEOF, 13 << 2, 1, 0x10, 0x08, 0x00, 0x0F},
+			size:        0x2000 + chrROMSizeUnit,
+			wantErrored: true,
+			wantErr:     "The PRG ROM size is not a multiple of 16KB: got=8192 bytes",
+		},
+		{
+			// 2^12 * 3 = 12KB CHR ROM is not a whole 8KB bank.
+			name:        "NES 2.0 exponent-multiplier CHR ROM not in 8KB",
+			header:      [16]byte{'N', 'E', 'S', msDOSEOF, 2, 12<<2 | 1, 0x10, 0x08, 0x00, 0xF0},
+			size:        prgROMSizeUnit*2 + 0x3000,
+			wantErrored: true,
+			wantErr:     "The CHR ROM size is not a multiple of 8KB: got=12288 bytes",
+		},
+		{
+			// PRG RAM 64 << 9 = 32KB, CHR NVRAM 64 << 9 = 32KB.
+			name:       "NES 2.0 RAM sizes",
+			header:     [16]byte{'N', 'E', 'S', msDOSEOF, 2, 0, 0x10, 0x08, 0x00, 0x00, 0x09, 0x90},
+			size:       prgROMSizeUnit * 2,
+			wantMapper: 1,
+			wantPRGROM: prgROMSizeUnit * 2,
+			wantCHRRAM: chrROMSizeUnit * 4,
+			wantPRGRAM: prgRAMSize * 4,
+		},
+		{
+			// The PRG ROM size MSB makes 0x102 * 16KB.
+			name:        "NES 2.0 too short",
+			header:      [16]byte{'N', 'E', 'S', msDOSEOF, 2, 1, 0x00, 0x08, 0x00, 0x01},
+			size:        prgROMSizeUnit*2 + chrROMSizeUnit,
+			wantErrored: true,
+		},
+	}
+	for _, tt := range tests {
+		data := append(tt.header[:], make([]byte, tt.size)...)
+		cartridge, err := NewCartridge(data)
+		if tt.wantErrored {
+			if err == nil {
+				t.Errorf("%s: got no error, want an error", tt.name)
+			} else if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got=%q, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := cartridge.MapperIndex(); got != tt.wantMapper {
+			t.Errorf("%s: MapperIndex: got=%d, want=%d", tt.name, got, tt.wantMapper)
+		}
+		if got := len(cartridge.prgROM); got != tt.wantPRGROM {
+			t.Errorf("%s: PRG ROM: got=%d bytes, want=%d bytes", tt.name, got, tt.wantPRGROM)
+		}
+		gotCHRROM, gotCHRRAM := len(cartridge.chrROM), 0
+		if cartridge.chrRAM {
+			gotCHRROM, gotCHRRAM = 0, gotCHRROM
+		}
+		if gotCHRROM != tt.wantCHRROM || gotCHRRAM != tt.wantCHRRAM {
+			t.Errorf("%s: CHR: got=%d bytes ROM and %d bytes RAM, want=%d bytes ROM and %d bytes RAM", tt.name, gotCHRROM, gotCHRRAM, tt.wantCHRROM, tt.wantCHRRAM)
+		}
+		if got := len(cartridge.prgRAM); got != tt.wantPRGRAM {
+			t.Errorf("%s: PRG RAM: got=%d bytes, want=%d bytes", tt.name, got, tt.wantPRGRAM)
+		}
+	}
+}
+
+func TestNES20MapperIndex(t *testing.T) {
+	cartridge := &Cartridge{flags6: 0x10, flags7: 0x28, flags8: 0x01}
+	if got, want := cartridge.MapperIndex(), uint16(0x121); got != want {
+		t.Errorf("MapperIndex: got=%d, want=%d", got, want)
+	}
+	// The same flags are ignored in iNES.
+	cartridge.flags7 = 0x20
+	if got, want := cartridge.MapperIndex(), uint16(0x21); got != want {
+		t.Errorf("MapperIndex: got=%d, want=%d", got, want)
+	}
+}
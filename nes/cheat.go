@@ -0,0 +1,85 @@
+package nes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Game Genie codes patch values which CPU reads from the cartridge.
+// Reference: https://www.nesdev.org/wiki/Game_Genie
+
+const gameGenieLetters = "APZLGITYEOXUKSVN"
+
+type cheat struct {
+	address uint16
+	value   byte
+	// compare is available on 8 letter codes, the value is patched only when the original value equals compare.
+	compare    byte
+	hasCompare bool
+}
+
+// decodeGameGenie decodes a 6 or 8 letter Game Genie code.
+func decodeGameGenie(code string) (cheat, error) {
+	code = strings.ToUpper(code)
+	if len(code) != 6 && len(code) != 8 {
+		return cheat{}, fmt.Errorf("Game Genie code must have 6 or 8 letters: %s", code)
+	}
+	n := make([]uint16, len(code))
+	for i, letter := range code {
+		v := strings.IndexRune(gameGenieLetters, letter)
+		if v < 0 {
+			return cheat{}, fmt.Errorf("Invalid Game Genie letter %c in %s", letter, code)
+		}
+		n[i] = uint16(v)
+	}
+	c := cheat{
+		address: 0x8000 + ((n[3]&7)<<12 | (n[5]&7)<<8 | (n[4]&8)<<8 | (n[2]&7)<<4 | (n[1]&8)<<4 | (n[4] & 7) | (n[3] & 8)),
+	}
+	if len(code) == 6 {
+		c.value = byte((n[1]&7)<<4 | (n[0]&8)<<4 | (n[0] & 7) | (n[5] & 8))
+	} else {
+		c.value = byte((n[1]&7)<<4 | (n[0]&8)<<4 | (n[0] & 7) | (n[7] & 8))
+		c.compare = byte((n[7]&7)<<4 | (n[6]&8)<<4 | (n[6] & 7) | (n[5] & 8))
+		c.hasCompare = true
+	}
+	return c, nil
+}
+
+// apply returns the patched value if the cheat matches.
+func (c *cheat) apply(address uint16, data byte) byte {
+	if c.address == address && (!c.hasCompare || c.compare == data) {
+		return c.value
+	}
+	return data
+}
+
+// FreezeAddress keeps the value at the address by writing it back after each CPU step, e.g. for infinite lives.
+// Only WRAM ($0000-$1FFF) and PRG RAM ($6000-$7FFF) can be frozen, the value is written here to check the address is writable.
+func (c *NesConsole) FreezeAddress(address uint16, value byte) error {
+	if !(address < 0x2000 || 0x6000 <= address && address < 0x8000) {
+		return fmt.Errorf("Only WRAM and PRG RAM can be frozen: address=0x%04x", address)
+	}
+	if err := c.cpu.bus.write(address, value); err != nil {
+		return fmt.Errorf("Failed to freeze 0x%04x: %w", address, err)
+	}
+	if c.frozen == nil {
+		c.frozen = map[uint16]byte{}
+	}
+	c.frozen[address] = value
+	return nil
+}
+
+// UnfreezeAddress stops freezing the address.
+func (c *NesConsole) UnfreezeAddress(address uint16) {
+	delete(c.frozen, address)
+}
+
+// applyFreezes writes the frozen values back.
+func (c *NesConsole) applyFreezes() error {
+	for address, value := range c.frozen {
+		if err := c.cpu.bus.write(address, value); err != nil {
+			return fmt.Errorf("Failed to write the frozen value: %w", err)
+		}
+	}
+	return nil
+}
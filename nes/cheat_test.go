@@ -0,0 +1,116 @@
+package nes
+
+import "testing"
+
+func TestDecodeGameGenie(t *testing.T) {
+	tests := []struct {
+		code string
+		want cheat
+	}{
+		{"GOSSIP", cheat{address: 0xD1DD, value: 0x14}},
+		{"ZEXPYGLA", cheat{address: 0x94A7, value: 0x02, compare: 0x03, hasCompare: true}},
+		{"sxiopo", cheat{address: 0x91D9, value: 0xAD}},
+	}
+	for _, tt := range tests {
+		got, err := decodeGameGenie(tt.code)
+		if err != nil {
+			t.Fatalf("decodeGameGenie(%s) returned an error: %v", tt.code, err)
+		}
+		if got != tt.want {
+			t.Errorf("decodeGameGenie(%s): got=%+v, want=%+v", tt.code, got, tt.want)
+		}
+	}
+	for _, code := range []string{"GOSSI", "GOSSIPS", "GOSSIB"} {
+		if _, err := decodeGameGenie(code); err == nil {
+			t.Errorf("decodeGameGenie(%s) returned no error", code)
+		}
+	}
+}
+
+func TestCheatRead(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit*2)
+	prgROM[0x14A7] = 0x03 // $94A7
+	prgROM[0x51DD] = 0x99 // $D1DD
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, false /* debug */)
+	c := console.(*NesConsole)
+	for _, code := range []string{"GOSSIP", "ZEXPYGLA"} {
+		if err := c.AddCheat(code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for address, want := range map[uint16]byte{0xD1DD: 0x14, 0x94A7: 0x02} {
+		got, err := c.cpu.bus.read(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Reading 0x%04x with cheats: got=0x%02x, want=0x%02x", address, got, want)
+		}
+	}
+	// The compare value doesn't match.
+	cartridge.prgROM[0x14A7] = 0x04
+	if got, _ := c.cpu.bus.read(0x94A7); got != 0x04 {
+		t.Errorf("Reading 0x94a7 with an unmatched compare value: got=0x%02x, want=0x04", got)
+	}
+}
+
+func TestFreezeAddress(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	copy(prgROM, []byte{
+		0xC6, 0x10, // DEC $10
+		0x4C, 0x00, 0x80, // JMP $8000
+	})
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	c, err := newNesConsole(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FreezeAddress(0x0010, 0x09); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.cpu.bus.wram.read(0x10); got != 0x09 {
+			t.Fatalf("Frozen $0010 after step %d: got=0x%02x, want=0x09", i, got)
+		}
+	}
+	c.UnfreezeAddress(0x0010)
+	// DEC $10, JMP $8000, DEC $10
+	for i := 0; i < 3; i++ {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.cpu.bus.wram.read(0x10); got != 0x07 {
+		t.Errorf("Unfrozen $0010: got=0x%02x, want=0x07", got)
+	}
+	if err := c.FreezeAddress(0x8000, 0x00); err == nil {
+		t.Errorf("Freezing PRG ROM returned no error")
+	}
+	// NROM without the battery has PRG RAM.
+	if err := c.FreezeAddress(0x6000, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.cpu.bus.read(0x6000); got != 0x42 {
+		t.Errorf("Frozen $6000: got=0x%02x, want=0x42", got)
+	}
+	// A board without PRG RAM fails on FreezeAddress, not on the following steps.
+	c.UnfreezeAddress(0x6000)
+	cartridge.servePRGRAM = false
+	if err := c.FreezeAddress(0x7000, 0x42); err == nil {
+		t.Errorf("Freezing $7000 without PRG RAM returned no error")
+	}
+	if _, err := c.Step(); err != nil {
+		t.Errorf("Step after the failed FreezeAddress: %v", err)
+	}
+}
@@ -1,76 +1,334 @@
 package nes
 
-import "image"
+import (
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+	"os"
+
+	"github.com/golang/glog"
+)
 
 type Console interface {
 	Reset() error
 	Step() (int, error)
 	Frame() (*image.RGBA, bool)
-	SetAudioOut(chan float32)
+	SetAudioOut(chan float32, int)
+	RawAudio() []float32
 	SetButtons([8]bool)
+	SetButtons2([8]bool)
+	SetFrameCallback(func(*image.RGBA))
+	SetScanlineCallback(func(int))
+	NameTables() (*image.RGBA, error)
+	PatternTables() (*image.RGBA, error)
+	Palettes() (*image.RGBA, error)
+	SetLayerMask(bool, bool)
+	Mapper() Mapper
+	MapperIRQ() bool
+	Trace(io.Writer, int) error
+	DumpFrames(string, int) error
+	RunTestROM(int) (TestROMResult, error)
+	SetRegion(Region)
+	WasLagFrame() bool
+	SetOutputScale(int)
+	AddCheat(string) error
+	FreezeAddress(uint16, byte) error
+	UnfreezeAddress(uint16)
+	SetInputSource(func() [8]bool)
+	SaveState() ([]byte, error)
+	LoadState([]byte) error
+	Rewind() bool
 }
 
 type NesConsole struct {
+	cartridge    *Cartridge
 	cpu          *CPU
 	ppu          *PPU
 	apu          *APU
 	controller   *Controller
+	controller2  *Controller
 	lastFrame    uint64
 	currentFrame uint64
 	buffer       *image.RGBA
+	// frameCallback is called once when each frame completes.
+	frameCallback func(*image.RGBA)
+	// outputScale is a scale of frames, scaled is a buffer for the scaled frame.
+	outputScale int
+	scaled      *image.RGBA
+	// inputSource is polled once per frame.
+	inputSource func() [8]bool
+	// region decides the timing, ppuRemainder keeps the fraction of PPU cycles for PAL.
+	region       Region
+	ppuRemainder int
+	// lagFrame is true if the last frame didn't read controllers, lagFrames counts them.
+	lagFrame  bool
+	lagFrames uint64
+	// recoverPanics converts panics in Step into errors.
+	recoverPanics bool
+	// frozen keeps values of addresses which are written back after each CPU step.
+	frozen map[uint16]byte
+	// cpuDivider slows the CPU down relative to the PPU for diagnostics, 1 is the normal speed.
+	cpuDivider int
+	// captureRaw keeps the APU output of every CPU cycle in rawAudio without resampling, for analysis tools.
+	captureRaw bool
+	rawAudio   []float32
+	// rewind keeps states for Rewind if it's enabled, rewindDue is true if a state should be taken after the step.
+	rewind    *rewindBuffer
+	rewindDue bool
+}
+
+// Option configures a console.
+type Option func(*NesConsole)
+
+// StrictMode makes the CPU fail on unofficial opcodes instead of executing them.
+func StrictMode() Option {
+	return func(c *NesConsole) {
+		c.cpu.strict = true
+	}
+}
+
+// LogPPURegisters logs every PPU register ($2000-$2007) access to w.
+func LogPPURegisters(w io.Writer) Option {
+	return func(c *NesConsole) {
+		c.cpu.bus.ppuLog = w
+	}
+}
+
+// SilenceUnimplementedIO discards writes to unhandled I/O registers without logging them.
+func SilenceUnimplementedIO() Option {
+	return func(c *NesConsole) {
+		c.cpu.bus.logUnimplemented = nil
+	}
+}
+
+// LogBankSwitches logs bank and mirroring switches of the mapper to w, this does nothing if the mapper doesn't support it.
+func LogBankSwitches(w io.Writer) Option {
+	return func(c *NesConsole) {
+		if m, ok := c.cartridge.Mapper.(bankLogger); ok {
+			m.setBankLog(w)
+		}
+	}
+}
+
+// RecoverPanics makes Step return an error instead of panicking, so that frontends can clean up, e.g. saving SRAM.
+func RecoverPanics() Option {
+	return func(c *NesConsole) {
+		c.recoverPanics = true
+	}
+}
+
+// RandomOAM fills OAM with random data on power-on like real hardware, to find games which use OAM without initializing.
+func RandomOAM(seed int64) Option {
+	return func(c *NesConsole) {
+		rand.New(rand.NewSource(seed)).Read(c.ppu.primaryOAM[:])
+	}
+}
+
+// CPUClockDivider runs the CPU at 1/n speed relative to the PPU, this is only for diagnosing timing issues.
+func CPUClockDivider(n int) Option {
+	return func(c *NesConsole) {
+		if n < 1 {
+			n = 1
+		}
+		if n != 1 {
+			glog.Warningf("The CPU runs at 1/%d speed relative to the PPU, the emulation is NOT accurate.\n", n)
+		}
+		c.cpuDivider = n
+	}
+}
+
+// HoldButtonsAtBoot holds buttons of 1P controller from power-on, e.g. for test ROMs which select a test by the buttons in init.
+// The buttons are kept until SetButtons or the input source changes them.
+func HoldButtonsAtBoot(buttons [8]bool) Option {
+	return func(c *NesConsole) {
+		c.controller.Set(buttons)
+	}
+}
+
+// CaptureRawAudio captures the APU output of every CPU cycle (1789773Hz) besides the resampled audio out,
+// this is for analysis tools and RawAudio should be drained regularly since the capture grows unbounded.
+func CaptureRawAudio() Option {
+	return func(c *NesConsole) {
+		c.captureRaw = true
+	}
 }
 
 // NewConsole creates a console. If debug is true, this creates a debug console.
-func NewConsole(cartridge *Cartridge, debug bool) (Console, error) {
+func NewConsole(cartridge *Cartridge, debug bool, options ...Option) (Console, error) {
+	if debug {
+		c, err := NewDebugConsole(cartridge, os.Stdin, os.Stdout, nil, options...)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	c, err := newNesConsole(cartridge, options...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newNesConsole(cartridge *Cartridge, options ...Option) (*NesConsole, error) {
 	controller := NewController()
+	controller2 := NewController()
 	ppuBus := NewPPUBus(NewRAM(), cartridge)
 	ppu := NewPPU(ppuBus)
 	apu := NewAPU()
-	cpuBus := NewCPUBus(NewRAM(), ppu, apu, cartridge, controller)
+	cpuBus := NewCPUBus(NewRAM(), ppu, apu, cartridge, controller, controller2)
 	cpu := NewCPU(cpuBus)
-	console := &NesConsole{cpu: cpu, ppu: ppu, apu: apu, controller: controller}
-	if debug {
-		return &DebugConsole{NesConsole: console}, nil
-	} else {
-		return console, nil
+	console := &NesConsole{cartridge: cartridge, cpu: cpu, ppu: ppu, apu: apu, controller: controller, controller2: controller2, outputScale: 1, cpuDivider: 1}
+	for _, option := range options {
+		option(console)
 	}
+	return console, nil
 }
 
+// Reset resets the console like the reset button, WRAM and the mapper banks are kept and the reset vector is read
+// from the bank mapped at $FFFC at that time. This is also the power-on since all states start cleared.
 func (c *NesConsole) Reset() error {
 	c.currentFrame = 0
 	c.lastFrame = 0
+	c.resetControllers()
 	if err := c.cpu.Reset(); err != nil {
 		return err
 	}
 	c.ppu.Reset()
+	// The APU is silenced as $4015 = 0.
+	c.apu.writeControl(0)
 	return nil
 }
 
+// resetControllers resets controllers, so that the first read after Reset reflects the buttons set before.
+// The input source is still polled only at vblank to keep the input latency deterministic.
+func (c *NesConsole) resetControllers() {
+	c.controller.reset()
+	c.controller2.reset()
+}
+
 // Step executes a CPU step and returns how many cycles are consumed.
-func (c *NesConsole) Step() (int, error) {
+func (c *NesConsole) Step() (cycles int, err error) {
+	defer c.recoverStep(&err)
+	return c.step()
+}
+
+// recoverStep converts a panic into err if RecoverPanics is set, Step of each console defers this.
+func (c *NesConsole) recoverStep(err *error) {
+	if !c.recoverPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("Recovered from a panic: %v, PC=0x%04x, last execution: %s", r, c.cpu.pc, c.cpu.lastExecution)
+	}
+}
+
+func (c *NesConsole) step() (int, error) {
 	cycles, err := c.cpu.Step()
 	if err != nil {
 		return cycles, err
 	}
-	for i := 0; i < cycles; i++ {
-		c.apu.Step()
+	return cycles, c.catchUp(cycles)
+}
+
+// catchUp runs the other components for the cycles consumed by a CPU step.
+func (c *NesConsole) catchUp(cycles int) error {
+	if err := c.applyFreezes(); err != nil {
+		return err
 	}
-	// PPU's clock is exactly 3x faster than CPU's
-	for i := 0; i < cycles*3; i++ {
+	if err := c.stepAPU(cycles); err != nil {
+		return err
+	}
+	// PPU's clock is exactly 3x faster than CPU's for NTSC, 3.2x for PAL.
+	hijacked := false
+	n := c.ppuCycles(cycles)
+	for i := 1; i <= n; i++ {
 		nmi, err := c.ppu.Step()
 		if err != nil {
-			return cycles, err
+			return err
 		}
+		c.pollInput()
 		if nmi {
 			c.cpu.nmiTriggered = true
+			// An NMI in the first 4 cycles of a BRK or IRQ sequence is detected before the vector fetch.
+			hijacked = c.cpu.hijackable && i*cycles <= 4*n
 		}
 		ok, f := c.ppu.Frame()
 		if ok {
-			c.currentFrame++
-			c.buffer = f
+			c.completeFrame(f)
+		}
+	}
+	if hijacked {
+		if err := c.cpu.hijackByNMI(); err != nil {
+			return err
 		}
 	}
-	return cycles, nil
+	c.updateIRQLine()
+	return c.snapshotRewind()
+}
+
+// stepAPU steps the APU by the CPU cycles, serving the DMC sample reads.
+func (c *NesConsole) stepAPU(cycles int) error {
+	for i := 0; i < cycles; i++ {
+		c.apu.Step()
+		if c.captureRaw {
+			c.rawAudio = append(c.rawAudio, c.apu.output())
+		}
+		if address, ok := c.apu.dmcRequest(); ok {
+			data, err := c.cpu.bus.read(address)
+			if err != nil {
+				return fmt.Errorf("Failed to read a DMC sample: %w", err)
+			}
+			c.apu.fillDMC(data)
+			// The DMC sample fetch stalls the CPU.
+			// TODO(jyane): This takes 1-4 cycles depending on what the CPU is doing, here always uses 4.
+			// https://www.nesdev.org/wiki/APU_DMC#Memory_reader
+			c.cpu.stall += 4
+		}
+	}
+	return nil
+}
+
+// updateIRQLine sets the IRQ line from the IRQ sources, this is called after the PPU catches up with the CPU
+// so that the mapper IRQ raised while rendering is seen by the next instruction.
+func (c *NesConsole) updateIRQLine() {
+	c.cpu.irqLine = c.apu.irq() || c.cartridge.Mapper.IRQPending()
+}
+
+// pollInput sets buttons from the input source at the start of vblank, right before NMI.
+// Games usually read controllers in the NMI handler, so this makes the input latency deterministic.
+func (c *NesConsole) pollInput() {
+	if c.inputSource != nil && c.ppu.scanline == 241 && c.ppu.cycle == 1 {
+		c.controller.Set(c.inputSource())
+	}
+}
+
+// completeFrame stores a completed frame and notifies it to the frame callback.
+func (c *NesConsole) completeFrame(f *image.RGBA) {
+	c.currentFrame++
+	c.lagFrame = !c.cpu.bus.controllerRead
+	if c.lagFrame {
+		c.lagFrames++
+	}
+	c.cpu.bus.controllerRead = false
+	c.apu.muted = false
+	if c.rewind != nil && c.currentFrame%rewindInterval == 0 {
+		c.rewindDue = true
+	}
+	if 1 < c.outputScale {
+		scaleImage(c.scaled, f, c.outputScale)
+		f = c.scaled
+	}
+	c.buffer = f
+	if c.frameCallback != nil {
+		c.frameCallback(f)
+	}
+}
+
+// WasLagFrame returns true if the game didn't read controllers during the last completed frame.
+// A frame starts when the previous frame completes, so the read in the NMI handler after a frame counts for the next frame.
+func (c *NesConsole) WasLagFrame() bool {
+	return c.lagFrame
 }
 
 // Frame returns a new frame.
@@ -83,10 +341,110 @@ func (c *NesConsole) Frame() (*image.RGBA, bool) {
 	}
 }
 
-func (c *NesConsole) SetAudioOut(channel chan float32) {
-	c.apu.SetAudioOut(channel)
+func (c *NesConsole) SetAudioOut(channel chan float32, sampleRate int) {
+	c.apu.SetAudioOut(channel, sampleRate)
+}
+
+// RawAudio returns the raw samples captured since the last call, one sample per CPU cycle.
+func (c *NesConsole) RawAudio() []float32 {
+	raw := c.rawAudio
+	c.rawAudio = nil
+	return raw
 }
 
+// SetButtons sets buttons of 1P controller, this takes effect immediately, also before the first Step after Reset.
 func (c *NesConsole) SetButtons(buttons [8]bool) {
 	c.controller.Set(buttons)
 }
+
+// SetButtons2 sets buttons of 2P controller.
+func (c *NesConsole) SetButtons2(buttons [8]bool) {
+	c.controller2.Set(buttons)
+}
+
+// SetFrameCallback sets a callback which is called with the completed frame once per frame.
+// The given image is reused for following frames, copy it if it needs to be kept.
+func (c *NesConsole) SetFrameCallback(callback func(*image.RGBA)) {
+	c.frameCallback = callback
+}
+
+// SetScanlineCallback sets a callback which is called right after the last pixel of each visible scanline (0-239) is rendered,
+// e.g. for capturing the PPU state of raster splits.
+func (c *NesConsole) SetScanlineCallback(callback func(scanline int)) {
+	c.ppu.scanlineCallback = callback
+}
+
+// NameTables renders current name tables for debugging.
+func (c *NesConsole) NameTables() (*image.RGBA, error) {
+	return c.ppu.NameTables()
+}
+
+// PatternTables renders current pattern tables for debugging.
+func (c *NesConsole) PatternTables() (*image.RGBA, error) {
+	return c.ppu.PatternTables()
+}
+
+// Palettes renders current palettes for debugging.
+func (c *NesConsole) Palettes() (*image.RGBA, error) {
+	return c.ppu.Palettes()
+}
+
+// SetLayerMask shows or hides the background and the sprite layer for debugging, the game is not affected.
+func (c *NesConsole) SetLayerMask(bg, sprites bool) {
+	c.ppu.SetLayerMask(bg, sprites)
+}
+
+// Mapper returns the mapper of the inserted cartridge.
+func (c *NesConsole) Mapper() Mapper {
+	return c.cartridge.Mapper
+}
+
+// MapperIRQ returns true while the mapper of the inserted cartridge asserts the IRQ line.
+func (c *NesConsole) MapperIRQ() bool {
+	return c.cartridge.Mapper.IRQPending()
+}
+
+// SetOutputScale sets a scale of frames, frames will be n*256 x n*240 scaled by nearest-neighbor.
+func (c *NesConsole) SetOutputScale(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.outputScale = n
+	if 1 < n {
+		c.scaled = image.NewRGBA(image.Rect(0, 0, width*n, height*n))
+	}
+}
+
+// scaleImage scales src into dst by n with nearest-neighbor.
+func scaleImage(dst *image.RGBA, src *image.RGBA, n int) {
+	w := src.Rect.Dx()
+	h := src.Rect.Dy()
+	for y := 0; y < h; y++ {
+		srcRow := src.Pix[y*src.Stride : y*src.Stride+w*4]
+		dstRow := dst.Pix[y*n*dst.Stride : y*n*dst.Stride+w*n*4]
+		for x := 0; x < w; x++ {
+			for i := 0; i < n; i++ {
+				copy(dstRow[(x*n+i)*4:(x*n+i)*4+4], srcRow[x*4:x*4+4])
+			}
+		}
+		// The rest of rows are the same as the first row.
+		for i := 1; i < n; i++ {
+			copy(dst.Pix[(y*n+i)*dst.Stride:], dstRow)
+		}
+	}
+}
+
+// AddCheat adds a Game Genie code.
+func (c *NesConsole) AddCheat(code string) error {
+	cheat, err := decodeGameGenie(code)
+	if err != nil {
+		return err
+	}
+	c.cpu.bus.cheats = append(c.cpu.bus.cheats, cheat)
+	return nil
+}
+
+// SetInputSource sets a function which returns current buttons, this is polled once per frame.
+func (c *NesConsole) SetInputSource(source func() [8]bool) {
+	c.inputSource = source
+}
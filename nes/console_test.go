@@ -0,0 +1,519 @@
+package nes
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestSetOutputScale(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, err := NewConsole(cartridge, false /* debug */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := console.(*NesConsole)
+	c.SetOutputScale(3)
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	src.SetRGBA(0, 0, color.RGBA{1, 2, 3, 255})
+	src.SetRGBA(255, 239, color.RGBA{4, 5, 6, 255})
+	c.completeFrame(src)
+	got, ok := c.Frame()
+	if !ok {
+		t.Fatal("Frame returned no frame")
+	}
+	if got.Rect.Dx() != width*3 || got.Rect.Dy() != height*3 {
+		t.Fatalf("Scaled frame size: got=%dx%d, want=%dx%d", got.Rect.Dx(), got.Rect.Dy(), width*3, height*3)
+	}
+	for y := 0; y < height*3; y++ {
+		for x := 0; x < width*3; x++ {
+			if want := src.RGBAAt(x/3, y/3); got.RGBAAt(x, y) != want {
+				t.Fatalf("Scaled pixel (%d, %d): got=%v, want=%v", x, y, got.RGBAAt(x, y), want)
+			}
+		}
+	}
+}
+
+func TestOutputScaleNative(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, err := NewConsole(cartridge, false /* debug */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := console.(*NesConsole)
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	c.completeFrame(src)
+	// The native frame should be returned as is.
+	if got, _ := c.Frame(); got != src {
+		t.Errorf("Frame with the default scale returned a copy of the frame")
+	}
+}
+
+// newTestConsole creates a console which executes NOPs forever.
+func newTestConsole() *NesConsole {
+	prgROM := make([]byte, prgROMSizeUnit)
+	for i := range prgROM {
+		prgROM[i] = 0xEA // NOP
+	}
+	// Reset vector: $8000
+	prgROM[0x3FFC] = 0x00
+	prgROM[0x3FFD] = 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, false /* debug */)
+	return console.(*NesConsole)
+}
+
+func TestInputPolledOncePerFrame(t *testing.T) {
+	c := newTestConsole()
+	polled := 0
+	c.SetInputSource(func() [8]bool {
+		polled++
+		if c.ppu.scanline != 241 || c.ppu.cycle != 1 {
+			t.Errorf("Input was polled at scanline=%d, cycle=%d, want scanline=241, cycle=1", c.ppu.scanline, c.ppu.cycle)
+		}
+		return [8]bool{ButtonA: true}
+	})
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	frames := 0
+	for frames < 3 {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Frame(); ok {
+			frames++
+		}
+	}
+	// The console starts from scanline 240, so vblank comes before each frame.
+	if polled != 3 {
+		t.Errorf("Input was polled %d times for 3 frames, want 3", polled)
+	}
+	if !c.controller.buttons[ButtonA] {
+		t.Errorf("The polled input was not applied to the controller")
+	}
+}
+
+func TestNoNMIAfterResetUntilEnabled(t *testing.T) {
+	c := newTestConsole()
+	// Enables NMI before the reset, a soft reset must clear it.
+	c.ppu.writePPUCTRL(0x80)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// The PPU starts from vblank, runs 2 frames without enabling NMI.
+	for frames := 0; frames < 2; {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if c.cpu.nmiTriggered {
+			t.Fatalf("NMI was triggered before PPUCTRL enabled it: scanline=%d, cycle=%d", c.ppu.scanline, c.ppu.cycle)
+		}
+		if _, ok := c.Frame(); ok {
+			frames++
+		}
+	}
+	if err := c.cpu.bus.write(0x2000, 0x80); err != nil {
+		t.Fatal(err)
+	}
+	for frames := 0; frames < 2; {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if c.cpu.nmiTriggered {
+			return
+		}
+		if _, ok := c.Frame(); ok {
+			frames++
+		}
+	}
+	t.Errorf("NMI was not triggered after PPUCTRL enabled it")
+}
+
+func TestLagFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler []byte // NMI handler
+		want    bool
+	}{
+		{"polls every frame", []byte{0xAD, 0x16, 0x40, 0x40}, false}, // LDA $4016; RTI
+		{"skips polling", []byte{0x40}, true},                        // RTI
+	}
+	for _, tt := range tests {
+		prgROM := make([]byte, prgROMSizeUnit)
+		copy(prgROM, []byte{
+			0xA9, 0x80, // LDA #$80
+			0x8D, 0x00, 0x20, // STA $2000 (enables NMI)
+			0x4C, 0x05, 0x80, // JMP $8005
+		})
+		copy(prgROM[0x1000:], tt.handler)
+		// NMI vector: $9000, Reset vector: $8000
+		prgROM[0x3FFA], prgROM[0x3FFB] = 0x00, 0x90
+		prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+		cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+		c, err := newNesConsole(cartridge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		for frames := 0; frames < 3; {
+			if _, err := c.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.Frame(); ok {
+				frames++
+				if got := c.WasLagFrame(); got != tt.want {
+					t.Errorf("%s: WasLagFrame at frame %d: got=%t, want=%t", tt.name, frames, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+// panicMapper panics on every CPU read.
+type panicMapper struct {
+	Mapper
+}
+
+func (m *panicMapper) ReadFromCPU(address uint16) (byte, error) {
+	panic("induced panic")
+}
+
+func TestRecoverPanics(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	c, err := newNesConsole(cartridge, RecoverPanics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	cartridge.Mapper = &panicMapper{cartridge.Mapper}
+	_, err = c.Step()
+	if err == nil {
+		t.Fatal("Step returned no error for a panic")
+	}
+	if !strings.Contains(err.Error(), "induced panic") || !strings.Contains(err.Error(), "PC=0x") {
+		t.Errorf("The error doesn't have the panic and the CPU context: %v", err)
+	}
+}
+
+// irqMapper asserts the IRQ line while pending is true.
+type irqMapper struct {
+	Mapper
+	pending bool
+}
+
+func (m *irqMapper) IRQPending() bool {
+	return m.pending
+}
+
+func TestMapperIRQ(t *testing.T) {
+	c := newTestConsole()
+	m := &irqMapper{Mapper: c.cartridge.Mapper}
+	c.cartridge.Mapper = m
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pending := range []bool{true, false} {
+		m.pending = pending
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.MapperIRQ(); got != pending {
+			t.Errorf("MapperIRQ: got=%t, want=%t", got, pending)
+		}
+		if got := c.cpu.irqLine; got != pending {
+			t.Errorf("CPU IRQ line: got=%t, want=%t", got, pending)
+		}
+	}
+	d := newTestDebugConsole()
+	d.cartridge.Mapper = &irqMapper{Mapper: d.cartridge.Mapper, pending: true}
+	var out bytes.Buffer
+	d.out = &out
+	d.basePrint()
+	if want := "Mapper: NROM, IRQ=true"; !strings.Contains(out.String(), want) {
+		t.Errorf("Debugger output doesn't have %q:\n%s", want, out.String())
+	}
+}
+
+func TestHoldButtonsAtBoot(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	// LDA $4016; STA $00; LDA $4016; STA $01
+	copy(prgROM, []byte{0xAD, 0x16, 0x40, 0x85, 0x00, 0xAD, 0x16, 0x40, 0x85, 0x01})
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	var buttons [8]bool
+	buttons[ButtonA] = true
+	c, err := newNesConsole(cartridge, HoldButtonsAtBoot(buttons))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The shift register is in an unknown state before power-on.
+	c.controller.index = 5
+	c.controller.strobe = 1
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reads A, then B.
+	for address, want := range map[uint16]byte{0x00: 1, 0x01: 0} {
+		if got := c.cpu.bus.wram.read(address) & 1; got != want {
+			t.Errorf("Controller read stored at 0x%02x: got=%d, want=%d", address, got, want)
+		}
+	}
+}
+
+func TestScanlineCallback(t *testing.T) {
+	c := newTestConsole()
+	var scanlines []int
+	c.SetScanlineCallback(func(scanline int) {
+		scanlines = append(scanlines, scanline)
+	})
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for frames := 0; frames < 2; {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Frame(); ok {
+			frames++
+			if len(scanlines) != height {
+				t.Fatalf("Scanline callbacks in frame %d: got=%d, want=%d", frames, len(scanlines), height)
+			}
+			for i, scanline := range scanlines {
+				if scanline != i {
+					t.Fatalf("Scanline callback %d in frame %d: got=%d, want=%d", i, frames, scanline, i)
+				}
+			}
+			scanlines = nil
+		}
+	}
+}
+
+func TestCaptureRawAudio(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	for i := range prgROM {
+		prgROM[i] = 0xEA // NOP
+	}
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	c, err := newNesConsole(cartridge, CaptureRawAudio())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan float32, 2*defaultSampleRate)
+	c.SetAudioOut(out, defaultSampleRate)
+	cycles := 0
+	for cycles < CPUFrequency/100 {
+		n, err := c.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cycles += n
+	}
+	if got, want := len(c.RawAudio()), cycles; got != want {
+		t.Errorf("Raw samples in %d CPU cycles: got=%d, want=%d", cycles, got, want)
+	}
+	if got := c.RawAudio(); len(got) != 0 {
+		t.Errorf("Raw samples after draining: got=%d, want=0", len(got))
+	}
+	// The resampled stream is still sent.
+	if len(out) == 0 {
+		t.Error("No resampled samples while capturing the raw samples")
+	}
+}
+
+func TestRawAudioWithoutCapture(t *testing.T) {
+	c := newTestConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.RawAudio(); got != nil {
+		t.Errorf("Raw samples without CaptureRawAudio: got=%d, want=nil", len(got))
+	}
+}
+
+func TestRandomOAM(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	c1, _ := newNesConsole(cartridge, RandomOAM(1))
+	c2, _ := newNesConsole(cartridge, RandomOAM(1))
+	if c1.ppu.primaryOAM != c2.ppu.primaryOAM {
+		t.Errorf("OAM with the same seed differs")
+	}
+	if c := newTestConsole(); c1.ppu.primaryOAM == c.ppu.primaryOAM {
+		t.Errorf("OAM was not randomized")
+	}
+}
+
+func TestCPUClockDivider(t *testing.T) {
+	for _, divider := range []int{1, 2, 4} {
+		prgROM := make([]byte, prgROMSizeUnit)
+		for i := range prgROM {
+			prgROM[i] = 0xEA // NOP
+		}
+		prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+		cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+		c, err := newNesConsole(cartridge, CPUClockDivider(divider))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		// NOP takes 2 CPU cycles, 6 PPU cycles.
+		before := c.ppu.cycle
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := c.ppu.cycle-before, 6*divider; got != want {
+			t.Errorf("PPU cycles of NOP with divider %d: got=%d, want=%d", divider, got, want)
+		}
+	}
+}
+
+func TestFrameIRQ(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	copy(prgROM, []byte{
+		0x58,             // CLI
+		0x4C, 0x01, 0x80, // JMP $8001
+	})
+	copy(prgROM[0x2000:], []byte{
+		0xAD, 0x15, 0x40, // LDA $4015 (acknowledges the frame IRQ)
+		0x40, // RTI
+	})
+	// IRQ vector: $A000, Reset vector: $8000
+	prgROM[0x3FFE], prgROM[0x3FFF] = 0x00, 0xA0
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	c, err := newNesConsole(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	irqs := 0
+	for cycles := 0; cycles < 29830*2+100; {
+		v, err := c.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cycles += v
+		if strings.HasPrefix(c.cpu.lastExecution, "IRQ") {
+			irqs++
+		}
+	}
+	if irqs != 2 {
+		t.Errorf("Frame IRQs in 2 frame counter sequences: got=%d, want=2", irqs)
+	}
+}
+
+func TestDMCStallAndIRQ(t *testing.T) {
+	c := newTestConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// Inhibits the frame IRQ so that only the DMC asserts the IRQ line.
+	for _, w := range []struct {
+		address uint16
+		data    byte
+	}{{0x4017, 0x40}, {0x4010, 0x8F}, {0x4012, 0x00}, {0x4013, 0x00}, {0x4015, 0x10}} {
+		if err := c.cpu.bus.write(w.address, w.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	// The 1 byte sample is fetched right after enabling.
+	if c.cpu.stall == 0 {
+		t.Errorf("CPU stall after the DMC fetch: got=0, want>0")
+	}
+	if !c.cpu.irqLine {
+		t.Errorf("IRQ line after the last sample byte: got=false, want=true")
+	}
+	status, err := c.cpu.bus.read(0x4015)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 0x80 {
+		t.Errorf("$4015: got=0x%02x, want=0x80", status)
+	}
+}
+
+func TestPowerOnState(t *testing.T) {
+	c := newTestConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if c.cpu.s != 0xFD {
+		t.Errorf("S: got=0x%02x, want=0xfd", c.cpu.s)
+	}
+	if got := c.cpu.p.encode(); got != 0x24 {
+		t.Errorf("P: got=0x%02x, want=0x24", got)
+	}
+}
+
+func TestSoftReset(t *testing.T) {
+	c := newTestConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 2)
+	if err := c.cpu.bus.write(0x0010, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	c.cpu.a = 0x12
+	c.cpu.p.c = true
+	c.ppu.writePPUCTRL(0x80)
+	c.ppu.writePPUSCROLL(0x08)
+	c.ppu.v = 0x2345
+	c.ppu.buffer = 0x99
+	wantS := c.cpu.s - 3
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.cpu.bus.read(0x0010); err != nil || got != 0x42 {
+		t.Errorf("WRAM: got=(0x%02x, %v), want=(0x42, nil)", got, err)
+	}
+	if c.cpu.pc != 0x8000 {
+		t.Errorf("PC: got=0x%04x, want=0x8000", c.cpu.pc)
+	}
+	if c.cpu.s != wantS {
+		t.Errorf("S: got=0x%02x, want=0x%02x", c.cpu.s, wantS)
+	}
+	if c.cpu.a != 0x12 || !c.cpu.p.c || !c.cpu.p.i {
+		t.Errorf("A, C, I: got=(0x%02x, %v, %v), want=(0x12, true, true)", c.cpu.a, c.cpu.p.c, c.cpu.p.i)
+	}
+	if c.ppu.nmiOutput || c.ppu.w || c.ppu.x != 0 || c.ppu.t != 0 || c.ppu.buffer != 0 {
+		t.Errorf("PPU registers are not cleared: nmiOutput=%v, w=%v, x=%d, t=0x%04x, buffer=0x%02x",
+			c.ppu.nmiOutput, c.ppu.w, c.ppu.x, c.ppu.t, c.ppu.buffer)
+	}
+	if c.ppu.v != 0x2345 {
+		t.Errorf("v: got=0x%04x, want=0x2345", c.ppu.v)
+	}
+}
+
+func TestConsoleSetLayerMask(t *testing.T) {
+	c := newTestConsole()
+	c.SetLayerMask(false, true)
+	if !c.ppu.hideBackground || c.ppu.hideSprites {
+		t.Errorf("hidden layers: got=(%v, %v), want=(true, false)", c.ppu.hideBackground, c.ppu.hideSprites)
+	}
+}
@@ -35,6 +35,12 @@ func (c *Controller) Set(buttons [8]bool) {
 	c.buttons = buttons
 }
 
+// reset puts the shift register in a known state on power-on, the buttons are kept so that buttons held at boot are read.
+func (c *Controller) reset() {
+	c.index = 0
+	c.strobe = 0
+}
+
 func (c *Controller) read() byte {
 	ret := byte(0)
 	if c.index < 8 && c.buttons[c.index] {
@@ -51,9 +57,19 @@ func (c *Controller) read() byte {
 // https://bugzmanov.github.io/nes_ebook/chapter_7.html
 // - strobe bit on - controller reports only status of the button A on every read
 // - strobe bit off - controller cycles through all buttons
+// The buttons are reloaded while strobe is on, so the shift-out starts fresh from the falling edge (1 -> 0).
 func (c *Controller) write(data byte) {
-	c.strobe = data
-	if c.strobe&1 == 1 {
+	old := c.strobe
+	c.strobe = data & 1
+	if c.strobe == 1 || old == 1 {
 		c.index = 0
 	}
 }
+
+func (c *Controller) saveState(w *stateWriter) {
+	w.write(c.buttons, c.index, c.strobe)
+}
+
+func (c *Controller) loadState(r *stateReader) {
+	r.read(&c.buttons, &c.index, &c.strobe)
+}
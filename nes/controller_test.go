@@ -0,0 +1,29 @@
+package nes
+
+import "testing"
+
+func TestControllerStrobe(t *testing.T) {
+	c := NewController()
+	buttons := [8]bool{true, false, true, true, false, false, true, false}
+	c.Set(buttons)
+	// Reads some buttons before strobing, the sequence should start fresh after strobing.
+	c.read()
+	c.read()
+	c.write(1)
+	// While strobe is on, the controller reports only A.
+	for i := 0; i < 3; i++ {
+		if got := c.read(); got != 1 {
+			t.Fatalf("Read %d while strobe is on: got=%d, want=1", i, got)
+		}
+	}
+	c.write(0)
+	for i, b := range buttons {
+		want := byte(0)
+		if b {
+			want = 1
+		}
+		if got := c.read(); got != want {
+			t.Errorf("Read button %d after strobe 1->0: got=%d, want=%d", i, got, want)
+		}
+	}
+}
@@ -99,6 +99,11 @@ type CPU struct {
 	instructions []instruction
 	// interrupts
 	nmiTriggered bool
+	irqLine      bool // IRQ line is asserted while any IRQ sources are pending.
+	// hijackable is true if the last step was a BRK or IRQ sequence, which an NMI can hijack.
+	hijackable bool
+	// strict makes unofficial opcodes errors instead of executing them.
+	strict bool
 }
 
 // mnemonic will be empty if it still not implemented.
@@ -132,7 +137,7 @@ func (c *CPU) createInstructions() []instruction {
 		{"BPL", relative, c.bpl, 2, 2},    // 0x10
 		{"ORA", indirectY, c.ora, 2, 5},   // 0x11
 		{},                                // 0x12, STP
-		{"SLO", indirectY, c.slo, 2, 7},   // 0x13
+		{"SLO", indirectY, c.slo, 2, 8},   // 0x13
 		{"NOP", zeropageX, c.nop, 2, 4},   // 0x14
 		{"ORA", zeropageX, c.ora, 2, 4},   // 0x15
 		{"ASL", zeropageX, c.asl, 2, 6},   // 0x16
@@ -140,11 +145,11 @@ func (c *CPU) createInstructions() []instruction {
 		{"CLC", implied, c.clc, 1, 2},     // 0x18
 		{"ORA", absoluteY, c.ora, 3, 4},   // 0x19
 		{"NOP", implied, c.nop, 1, 2},     // 0x1A
-		{"SLO", absoluteY, c.slo, 3, 6},   // 0x1B
+		{"SLO", absoluteY, c.slo, 3, 7},   // 0x1B
 		{"NOP", absoluteX, c.nop, 3, 4},   // 0x1C
 		{"ORA", absoluteX, c.ora, 3, 4},   // 0x1D
 		{"ASL", absoluteX, c.asl, 3, 7},   // 0x1E
-		{"SLO", absoluteX, c.slo, 3, 6},   // 0x1F
+		{"SLO", absoluteX, c.slo, 3, 7},   // 0x1F
 		{"JSR", absolute, c.jsr, 3, 6},    // 0x20
 		{"AND", indirectX, c.and, 2, 6},   // 0x21
 		{},                                // 0x22, STP
@@ -164,7 +169,7 @@ func (c *CPU) createInstructions() []instruction {
 		{"BMI", relative, c.bmi, 2, 2},    // 0x30
 		{"AND", indirectY, c.and, 2, 5},   // 0x31
 		{},                                // 0x32, STP
-		{"RLA", indirectY, c.rla, 2, 7},   // 0x33
+		{"RLA", indirectY, c.rla, 2, 8},   // 0x33
 		{"NOP", zeropage, c.nop, 2, 4},    // 0x34
 		{"AND", zeropageX, c.and, 2, 4},   // 0x35
 		{"ROL", zeropageX, c.rol, 2, 6},   // 0x36
@@ -172,11 +177,11 @@ func (c *CPU) createInstructions() []instruction {
 		{"SEC", implied, c.sec, 1, 2},     // 0x38
 		{"AND", absoluteY, c.and, 3, 4},   // 0x39
 		{"NOP", implied, c.nop, 1, 2},     // 0x3A
-		{"RLA", absoluteY, c.rla, 3, 6},   // 0x3B
+		{"RLA", absoluteY, c.rla, 3, 7},   // 0x3B
 		{"NOP", absoluteX, c.nop, 3, 4},   // 0x3C
 		{"AND", absoluteX, c.and, 3, 4},   // 0x3D
 		{"ROL", absoluteX, c.rol, 3, 7},   // 0x3E
-		{"RLA", absoluteX, c.rla, 3, 6},   // 0x3F
+		{"RLA", absoluteX, c.rla, 3, 7},   // 0x3F
 		{"RTI", implied, c.rti, 1, 6},     // 0x40
 		{"EOR", indirectX, c.eor, 2, 6},   // 0x41
 		{},                                // 0x42, STP
@@ -196,7 +201,7 @@ func (c *CPU) createInstructions() []instruction {
 		{"BVC", relative, c.bvc, 2, 2},    // 0x50
 		{"EOR", indirectY, c.eor, 2, 5},   // 0x51
 		{},                                // 0x52, STP
-		{"SRE", indirectY, c.sre, 2, 7},   // 0x53
+		{"SRE", indirectY, c.sre, 2, 8},   // 0x53
 		{"NOP", zeropage, c.nop, 2, 4},    // 0x54
 		{"EOR", zeropageX, c.eor, 2, 4},   // 0x55
 		{"LSR", zeropageX, c.lsr, 2, 6},   // 0x56
@@ -204,11 +209,11 @@ func (c *CPU) createInstructions() []instruction {
 		{"CLI", implied, c.cli, 1, 2},     // 0x58
 		{"EOR", absoluteY, c.eor, 3, 4},   // 0x59
 		{"NOP", implied, c.nop, 1, 2},     // 0x5A
-		{"SRE", absoluteY, c.sre, 3, 6},   // 0x5B
+		{"SRE", absoluteY, c.sre, 3, 7},   // 0x5B
 		{"NOP", absoluteX, c.nop, 3, 4},   // 0x5C
 		{"EOR", absoluteX, c.eor, 3, 4},   // 0x5D
 		{"LSR", absoluteX, c.lsr, 3, 7},   // 0x5E
-		{"SRE", absoluteX, c.sre, 3, 6},   // 0x5F
+		{"SRE", absoluteX, c.sre, 3, 7},   // 0x5F
 		{"RTS", implied, c.rts, 1, 6},     // 0x60
 		{"ADC", indirectX, c.adc, 2, 6},   // 0x61
 		{},                                // 0x62, STP
@@ -228,7 +233,7 @@ func (c *CPU) createInstructions() []instruction {
 		{"BVS", relative, c.bvs, 2, 2},    // 0x70
 		{"ADC", indirectY, c.adc, 2, 5},   // 0x71
 		{},                                // 0x72, STP
-		{"RRA", indirectY, c.rra, 2, 7},   // 0x73
+		{"RRA", indirectY, c.rra, 2, 8},   // 0x73
 		{"NOP", zeropage, c.nop, 2, 4},    // 0x74
 		{"ADC", zeropageX, c.adc, 2, 4},   // 0x75
 		{"ROR", zeropageX, c.ror, 2, 6},   // 0x76
@@ -236,11 +241,11 @@ func (c *CPU) createInstructions() []instruction {
 		{"SEI", implied, c.sei, 1, 2},     // 0x78
 		{"ADC", absoluteY, c.adc, 3, 4},   // 0x79
 		{"NOP", implied, c.nop, 1, 2},     // 0x7A
-		{"RRA", absoluteY, c.rra, 3, 6},   // 0x7B
+		{"RRA", absoluteY, c.rra, 3, 7},   // 0x7B
 		{"NOP", absoluteX, c.nop, 3, 4},   // 0x7C
 		{"ADC", absoluteX, c.adc, 3, 4},   // 0x7D
 		{"ROR", absoluteX, c.ror, 3, 7},   // 0x7E
-		{"RRA", absoluteX, c.rra, 3, 6},   // 0x7F
+		{"RRA", absoluteX, c.rra, 3, 7},   // 0x7F
 		{"NOP", immediate, c.nop, 2, 2},   // 0x80
 		{"STA", indirectX, c.sta, 2, 6},   // 0x81
 		{"NOP", immediate, c.nop, 2, 2},   // 0x82
@@ -324,7 +329,7 @@ func (c *CPU) createInstructions() []instruction {
 		{"BNE", relative, c.bne, 2, 2},    // 0xD0
 		{"CMP", indirectY, c.cmp, 2, 5},   // 0xD1
 		{},                                // 0xD2, STP
-		{"DCP", indirectY, c.dcp, 2, 7},   // 0xD3
+		{"DCP", indirectY, c.dcp, 2, 8},   // 0xD3
 		{"NOP", zeropage, c.nop, 2, 4},    // 0xD4
 		{"CMP", zeropageX, c.cmp, 2, 4},   // 0xD5
 		{"DEC", zeropageX, c.dec, 2, 6},   // 0xD6
@@ -332,11 +337,11 @@ func (c *CPU) createInstructions() []instruction {
 		{"CLD", implied, c.cld, 1, 2},     // 0xD8
 		{"CMP", absoluteY, c.cmp, 3, 4},   // 0xD9
 		{"NOP", implied, c.nop, 1, 2},     // 0xDA
-		{"DCP", absoluteY, c.dcp, 3, 6},   // 0xDB
+		{"DCP", absoluteY, c.dcp, 3, 7},   // 0xDB
 		{"NOP", absoluteX, c.nop, 3, 4},   // 0xDC
 		{"CMP", absoluteX, c.cmp, 3, 4},   // 0xDD
 		{"DEC", absoluteX, c.dec, 3, 7},   // 0xDE
-		{"DCP", absoluteX, c.dcp, 3, 6},   // 0xDF
+		{"DCP", absoluteX, c.dcp, 3, 7},   // 0xDF
 		{"CPX", immediate, c.cpx, 2, 2},   // 0xE0
 		{"SBC", indirectX, c.sbc, 2, 6},   // 0xE1
 		{"NOP", immediate, c.nop, 2, 2},   // 0xE2
@@ -356,7 +361,7 @@ func (c *CPU) createInstructions() []instruction {
 		{"BEQ", relative, c.beq, 2, 2},    // 0xF0
 		{"SBC", indirectY, c.sbc, 2, 5},   // 0xF1
 		{},                                // 0xF2, STP
-		{"ISC", indirectY, c.isc, 2, 7},   // 0xF3
+		{"ISC", indirectY, c.isc, 2, 8},   // 0xF3
 		{"NOP", zeropage, c.nop, 2, 4},    // 0xF4
 		{"SBC", zeropageX, c.sbc, 2, 4},   // 0xF5
 		{"INC", zeropageX, c.inc, 2, 6},   // 0xF6
@@ -364,11 +369,11 @@ func (c *CPU) createInstructions() []instruction {
 		{"SED", implied, c.sed, 1, 2},     // 0xF8
 		{"SBC", absoluteY, c.sbc, 3, 4},   // 0xF9
 		{"NOP", implied, c.nop, 1, 2},     // 0xFA
-		{"ISC", absoluteY, c.isc, 3, 6},   // 0xFB
+		{"ISC", absoluteY, c.isc, 3, 7},   // 0xFB
 		{"NOP", absoluteX, c.nop, 3, 4},   // 0xFC
 		{"SBC", absoluteX, c.sbc, 3, 4},   // 0xFD
 		{"INC", absoluteX, c.inc, 3, 7},   // 0xFE
-		{"ISC", absoluteX, c.isc, 3, 6},   // 0xFF
+		{"ISC", absoluteX, c.isc, 3, 7},   // 0xFF
 	}
 }
 
@@ -376,7 +381,6 @@ func (c *CPU) createInstructions() []instruction {
 func NewCPU(bus *CPUBus) *CPU {
 	c := &CPU{
 		p: &status{
-			b: true,
 			r: true,
 		},
 		bus: bus,
@@ -392,8 +396,12 @@ func (c *CPU) Reset() error {
 		return fmt.Errorf("Failed to reset CPU: %w", err)
 	}
 	c.pc = data
-	c.s = 0xFD
-	c.p.decodeFrom(0x24)
+	// Reset doesn't clear registers but decrements S by 3 without writes and sets I, S is $00 at power-on.
+	// https://www.nesdev.org/wiki/CPU_power_up_state
+	c.s -= 3
+	c.p.i = true
+	c.nmiTriggered = false
+	c.stall = 0
 	return nil
 }
 
@@ -417,8 +425,28 @@ func (c *CPU) write(address uint16, data byte) error {
 		c.stall += 514
 		return nil
 	} else {
-		return c.bus.write(address, data)
+		err := c.bus.write(address, data)
+		c.bus.consecutiveWrite = false
+		return err
+	}
+}
+
+// Read-modify-write instructions, these take fixed cycles regardless of the page crossing.
+// https://www.nesdev.org/wiki/CPU_addressing_modes
+var readModifyWrite = map[string]bool{
+	"ASL": true, "LSR": true, "ROL": true, "ROR": true, "INC": true, "DEC": true,
+	"SLO": true, "SRE": true, "RLA": true, "RRA": true, "ISC": true, "DCP": true,
+}
+
+// dummyWrite writes the unmodified value back, read-modify-write instructions do this before writing the result.
+// This is visible on memory mapped registers, e.g. PPUDATA and the MMC1 serial port.
+func (c *CPU) dummyWrite(address uint16, data byte) error {
+	if err := c.write(address, data); err != nil {
+		return err
 	}
+	// The result is written on the next cycle.
+	c.bus.consecutiveWrite = true
+	return nil
 }
 
 // TODO(jyane): implement read to keep symmetry?
@@ -511,6 +539,9 @@ func (c *CPU) asl(mode addressingMode, operand uint16) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if err := c.dummyWrite(operand, x); err != nil {
+			return 0, err
+		}
 		c.p.c = (x>>7)&1 == 1
 		x <<= 1
 		if err := c.write(operand, x); err != nil {
@@ -526,10 +557,10 @@ func (c *CPU) asl(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bcc(mode addressingMode, operand uint16) (int, error) {
 	if !c.p.c {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -539,10 +570,10 @@ func (c *CPU) bcc(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bcs(mode addressingMode, operand uint16) (int, error) {
 	if c.p.c {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -552,10 +583,10 @@ func (c *CPU) bcs(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) beq(mode addressingMode, operand uint16) (int, error) {
 	if c.p.z {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -577,10 +608,10 @@ func (c *CPU) bit(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bmi(mode addressingMode, operand uint16) (int, error) {
 	if c.p.n {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -590,10 +621,10 @@ func (c *CPU) bmi(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bne(mode addressingMode, operand uint16) (int, error) {
 	if !c.p.z {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -603,10 +634,10 @@ func (c *CPU) bne(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bpl(mode addressingMode, operand uint16) (int, error) {
 	if !c.p.n {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -620,7 +651,8 @@ func (c *CPU) brk(mode addressingMode, operand uint16) (int, error) {
 	if err := c.push(byte(c.pc & 0xFF)); err != nil {
 		return 0, err
 	}
-	if err := c.push(c.p.encode()); err != nil {
+	// B flag is pushed as 1.
+	if err := c.push(c.p.encode() | 0x10); err != nil {
 		return 0, err
 	}
 	c.p.i = true
@@ -636,10 +668,10 @@ func (c *CPU) brk(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bvc(mode addressingMode, operand uint16) (int, error) {
 	if !c.p.v {
 		cycles := 1
-		c.pc = operand
 		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -649,10 +681,10 @@ func (c *CPU) bvc(mode addressingMode, operand uint16) (int, error) {
 func (c *CPU) bvs(mode addressingMode, operand uint16) (int, error) {
 	if c.p.v {
 		cycles := 1
-		c.pc = operand
-		if c.pageCrossed(c.pc-1, operand) {
+		if c.pageCrossed(c.pc, operand) {
 			cycles++
 		}
+		c.pc = operand
 		return cycles, nil
 	}
 	return 0, nil
@@ -727,6 +759,9 @@ func (c *CPU) dec(mode addressingMode, operand uint16) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if err := c.dummyWrite(operand, data); err != nil {
+		return 0, err
+	}
 	x := data - 1 // this won't go negative.
 	if err := c.write(operand, x); err != nil {
 		return 0, err
@@ -770,6 +805,9 @@ func (c *CPU) inc(mode addressingMode, operand uint16) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if err := c.dummyWrite(operand, x); err != nil {
+		return 0, err
+	}
 	x++
 	if err := c.write(operand, x); err != nil {
 		return 0, err
@@ -862,6 +900,9 @@ func (c *CPU) lsr(mode addressingMode, operand uint16) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if err := c.dummyWrite(operand, x); err != nil {
+			return 0, err
+		}
 		c.p.c = x&1 == 1
 		x >>= 1
 		if err := c.write(operand, x); err != nil {
@@ -948,6 +989,9 @@ func (c *CPU) rol(mode addressingMode, operand uint16) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if err := c.dummyWrite(operand, x); err != nil {
+			return 0, err
+		}
 		c.p.c = (x>>7)&1 == 1
 		x = (x << 1) | carry
 		if err := c.write(operand, x); err != nil {
@@ -975,6 +1019,9 @@ func (c *CPU) ror(mode addressingMode, operand uint16) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if err := c.dummyWrite(operand, x); err != nil {
+			return 0, err
+		}
 		c.p.c = x&1 == 1
 		x = (x >> 1) | (carry << 7)
 		if err := c.write(operand, x); err != nil {
@@ -1157,6 +1204,52 @@ func (c *CPU) nmi() error {
 	return nil
 }
 
+// IRQ is maskable interrupt, this will be triggered by APU and mappers.
+func (c *CPU) irq() error {
+	if err := c.push(byte(c.pc>>8) & 0xFF); err != nil {
+		return err
+	}
+	if err := c.push(byte(c.pc & 0xFF)); err != nil {
+		return err
+	}
+	// B flag is pushed as 0.
+	if err := c.push(c.p.encode()&0xEF | 0x20); err != nil {
+		return err
+	}
+	data, err := c.bus.read16(0xFFFE)
+	if err != nil {
+		return err
+	}
+	c.pc = data
+	c.p.i = true
+	return nil
+}
+
+// hijackByNMI makes the last BRK or IRQ sequence use the NMI vector, this is for an NMI detected before the sequence
+// fetches the vector. P has been pushed as the BRK or IRQ, and the NMI is consumed.
+// https://www.nesdev.org/wiki/CPU_interrupts#Interrupt_hijacking
+func (c *CPU) hijackByNMI() error {
+	data, err := c.bus.read16(0xFFFA)
+	if err != nil {
+		return err
+	}
+	c.pc = data
+	c.nmiTriggered = false
+	c.hijackable = false
+	c.lastExecution = c.lastExecution + " -> hijacked by NMI"
+	return nil
+}
+
+// unimplementedOpcodeError is returned when the CPU fetches an opcode which is not implemented, e.g. STP.
+type unimplementedOpcodeError struct {
+	opcode byte
+	pc     uint16
+}
+
+func (e *unimplementedOpcodeError) Error() string {
+	return fmt.Sprintf("Tried to execute unimplemented instruction: opcode=0x%02x", e.opcode)
+}
+
 // Step performs the instruction cycle - fetch, decode, execute, and returns the number of consumed cycles.
 func (c *CPU) Step() (int, error) {
 	// Running stall cycles.
@@ -1168,12 +1261,24 @@ func (c *CPU) Step() (int, error) {
 		return 1, nil
 	}
 	// Non-maskable interrupt.
-	didNMI := false
+	// NMI has priority over IRQ, a pending IRQ will be handled after the NMI handler (RTI clears I) if still asserted.
+	// An interrupt sequence is a step on its own, so that an NMI during the sequence can hijack it before the handler runs.
+	c.hijackable = false
 	if c.nmiTriggered {
-		c.nmi()
+		if err := c.nmi(); err != nil {
+			return 0, fmt.Errorf("Failed to handle NMI: %w", err)
+		}
 		c.nmiTriggered = false
-		didNMI = true
 		c.lastExecution = fmt.Sprintf("NMI, PC=0x%04x, A=0x%02x, X=0x%02x, Y=0x%02x, S=0x%02x", c.pc, c.a, c.x, c.y, c.s)
+		return 7, nil
+	} else if c.irqLine && !c.p.i {
+		// IRQ is level-triggered and masked by I.
+		if err := c.irq(); err != nil {
+			return 0, fmt.Errorf("Failed to handle IRQ: %w", err)
+		}
+		c.hijackable = true
+		c.lastExecution = fmt.Sprintf("IRQ, PC=0x%04x, A=0x%02x, X=0x%02x, Y=0x%02x, S=0x%02x", c.pc, c.a, c.x, c.y, c.s)
+		return 7, nil
 	}
 	opcode, err := c.bus.read(c.pc)
 	if err != nil {
@@ -1234,6 +1339,10 @@ func (c *CPU) Step() (int, error) {
 		}
 		operand = data + uint16(c.x)
 		additionalCycle = c.pageCrossed(operand-uint16(c.x), operand)
+		if readModifyWrite[instruction.mnemonic] {
+			// The CPU reads the address before fixing the high byte, the result is discarded.
+			c.bus.read(data&0xFF00 | operand&0x00FF)
+		}
 	case absoluteY:
 		data, err := c.bus.read16(c.pc + 1)
 		if err != nil {
@@ -1275,16 +1384,14 @@ func (c *CPU) Step() (int, error) {
 	}
 	mnemonic := instruction.mnemonic
 	if mnemonic == "" {
-		return 0, fmt.Errorf("Tried to execute unimplemented instruction: opcode=0x%02x", opcode)
+		return 0, &unimplementedOpcodeError{opcode: opcode, pc: c.pc}
+	}
+	if c.strict && unofficial(opcode, mnemonic) {
+		return 0, fmt.Errorf("Tried to execute an illegal opcode in strict mode: opcode=0x%02x, mnemonic=%s, PC=0x%04x", opcode, mnemonic, c.pc)
 	}
 	// Save debug string.
-	lastExecution := fmt.Sprintf("PC=0x%04x, A=0x%02x, X=0x%02x, Y=0x%02x, S=0x%02x, P=0x%02x, opcode=0x%02x, mnemonic=%s, operand: 0x%04x",
+	c.lastExecution = fmt.Sprintf("PC=0x%04x, A=0x%02x, X=0x%02x, Y=0x%02x, S=0x%02x, P=0x%02x, opcode=0x%02x, mnemonic=%s, operand: 0x%04x",
 		c.pc, c.a, c.x, c.y, c.s, c.p.encode(), opcode, mnemonic, operand)
-	if didNMI {
-		c.lastExecution = c.lastExecution + " -> " + lastExecution
-	} else {
-		c.lastExecution = lastExecution
-	}
 	c.pc += instruction.size
 	branchCycles, err := instruction.execute(instruction.mode, operand)
 	if err != nil {
@@ -1293,11 +1400,9 @@ func (c *CPU) Step() (int, error) {
 	// Adding some cycles if needed.
 	cycles := instruction.cycles
 	cycles += branchCycles
-	if didNMI {
-		cycles += 7
-	}
-	// STA shouldn't be affected the page crossing.
-	if additionalCycle && mnemonic != "STA" {
+	c.hijackable = mnemonic == "BRK"
+	// STA and read-modify-write instructions shouldn't be affected the page crossing.
+	if additionalCycle && mnemonic != "STA" && !readModifyWrite[mnemonic] {
 		cycles += 1
 	}
 	return cycles, nil
@@ -1306,6 +1411,19 @@ func (c *CPU) Step() (int, error) {
 // Unofficial opcodes - only a few games depend these opcodes.
 // Note: These implementations depend on existing opcode implementations.
 
+// unofficial returns true if the opcode is not an official one.
+func unofficial(opcode byte, mnemonic string) bool {
+	switch mnemonic {
+	case "LAX", "SAX", "DCP", "ISC", "SLO", "RLA", "SRE", "RRA":
+		return true
+	case "NOP":
+		return opcode != 0xEA
+	case "SBC":
+		return opcode == 0xEB
+	}
+	return false
+}
+
 // LAX - ?
 func (c *CPU) lax(mode addressingMode, operand uint16) (int, error) {
 	glog.Infof("Unofficial opcode execution: LAX, operand: 0x%04x\n", operand)
@@ -1377,3 +1495,13 @@ func (c *CPU) rra(mode addressingMode, operand uint16) (int, error) {
 	c.adc(mode, operand)
 	return 0, nil
 }
+
+func (c *CPU) saveState(w *stateWriter) {
+	w.write(c.a, c.x, c.y, c.pc, c.s, c.p.encode(), c.stall, c.nmiTriggered, c.irqLine)
+}
+
+func (c *CPU) loadState(r *stateReader) {
+	var p byte
+	r.read(&c.a, &c.x, &c.y, &c.pc, &c.s, &p, &c.stall, &c.nmiTriggered, &c.irqLine)
+	c.p.decodeFrom(p)
+}
@@ -2,10 +2,12 @@ package nes
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -28,7 +30,7 @@ func newTestCPU() *CPU {
 	ppuBus := NewPPUBus(NewRAM(), cartridge)
 	ppu := NewPPU(ppuBus)
 	apu := NewAPU()
-	cpuBus := NewCPUBus(NewRAM(), ppu, apu, cartridge, controller)
+	cpuBus := NewCPUBus(NewRAM(), ppu, apu, cartridge, controller, NewController())
 	cpu := NewCPU(cpuBus)
 	cpu.pc = 0xC000
 	cpu.s = 0xFD
@@ -83,3 +85,477 @@ func TestCPU(t *testing.T) {
 		before = line
 	}
 }
+
+// newTestCPUWithProgram creates a CPU which starts executing the program from $8000.
+func newTestCPUWithProgram(program []byte) *CPU {
+	prgROM := make([]byte, prgROMSizeUnit)
+	copy(prgROM, program)
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	ppu := NewPPU(NewPPUBus(NewRAM(), cartridge))
+	cpuBus := NewCPUBus(NewRAM(), ppu, NewAPU(), cartridge, NewController(), NewController())
+	cpu := NewCPU(cpuBus)
+	cpu.pc = 0x8000
+	cpu.s = 0xFD
+	cpu.p.decodeFrom(0x24)
+	return cpu
+}
+
+func TestBranchCycles(t *testing.T) {
+	branches := []struct {
+		name   string
+		opcode byte
+		// set sets the flag so that the branch is taken if taken is true.
+		set func(p *status, taken bool)
+	}{
+		{"BPL", 0x10, func(p *status, taken bool) { p.n = !taken }},
+		{"BMI", 0x30, func(p *status, taken bool) { p.n = taken }},
+		{"BVC", 0x50, func(p *status, taken bool) { p.v = !taken }},
+		{"BVS", 0x70, func(p *status, taken bool) { p.v = taken }},
+		{"BCC", 0x90, func(p *status, taken bool) { p.c = !taken }},
+		{"BCS", 0xB0, func(p *status, taken bool) { p.c = taken }},
+		{"BNE", 0xD0, func(p *status, taken bool) { p.z = !taken }},
+		{"BEQ", 0xF0, func(p *status, taken bool) { p.z = taken }},
+	}
+	tests := []struct {
+		name   string
+		pc     uint16 // address of the branch instruction
+		offset byte
+		taken  bool
+		wantPC uint16
+		want   int
+	}{
+		{"not taken", 0x8000, 0x10, false, 0x8002, 2},
+		{"taken", 0x8000, 0x10, true, 0x8012, 3},
+		{"taken backward", 0x8010, 0xF0, true, 0x8002, 3},
+		// The page crossing is decided from the address after the operand.
+		{"taken crossing page", 0x80F0, 0x10, true, 0x8102, 4},
+		{"taken crossing page backward", 0x8100, 0xF0, true, 0x80F2, 4},
+		{"not taken at page end", 0x80FE, 0x10, false, 0x8100, 2},
+	}
+	for _, b := range branches {
+		for _, tt := range tests {
+			program := make([]byte, 0x200)
+			program[tt.pc-0x8000] = b.opcode
+			program[tt.pc-0x8000+1] = tt.offset
+			c := newTestCPUWithProgram(program)
+			c.pc = tt.pc
+			b.set(c.p, tt.taken)
+			cycles, err := c.Step()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cycles != tt.want || c.pc != tt.wantPC {
+				t.Errorf("%s %s: got=%d cycles (PC=0x%04x), want=%d cycles (PC=0x%04x)", b.name, tt.name, cycles, c.pc, tt.want, tt.wantPC)
+			}
+		}
+	}
+}
+
+func TestRMWAbsoluteX(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		x       byte
+	}{
+		{"no page crossing", []byte{0xFE, 0x00, 0x20}, 0x07}, // INC $2000,X
+		{"page crossing", []byte{0xFE, 0xFF, 0x20}, 0x08},    // INC $20FF,X
+	}
+	for _, tt := range tests {
+		cpu := newTestCPUWithProgram(tt.program)
+		cpu.x = tt.x
+		ppu := cpu.bus.ppu
+		ppu.v = 0x2000
+		ppu.bus.write(0x2000, 0x41)
+		ppu.bus.write(0x2001, 0x10)
+		cycles, err := cpu.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cycles != 7 {
+			t.Errorf("%s: INC absolute,X cycles: got=%d, want=7", tt.name, cycles)
+		}
+		// PPUDATA ($2007) is accessed 4 times: dummy read, read, dummy write and write.
+		if ppu.v != 0x2004 {
+			t.Errorf("%s: PPU address after INC $2007: got=0x%04x, want=0x2004", tt.name, ppu.v)
+		}
+		// The read value is the buffered $2000.
+		for address, want := range map[uint16]byte{0x2002: 0x41, 0x2003: 0x42} {
+			got, _ := ppu.bus.read(address)
+			if got != want {
+				t.Errorf("%s: VRAM 0x%04x: got=0x%02x, want=0x%02x", tt.name, address, got, want)
+			}
+		}
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	program := []byte{
+		0xEA,       // NOP
+		0xA7, 0x10, // LAX $10 (unofficial)
+	}
+	cpu := newTestCPUWithProgram(program)
+	if _, err := cpu.Step(); err != nil {
+		t.Fatalf("NOP returned an error: %v", err)
+	}
+	if _, err := cpu.Step(); err != nil {
+		t.Fatalf("LAX returned an error without strict mode: %v", err)
+	}
+	cpu = newTestCPUWithProgram(program)
+	cpu.strict = true
+	if _, err := cpu.Step(); err != nil {
+		t.Fatalf("NOP returned an error in strict mode: %v", err)
+	}
+	if _, err := cpu.Step(); err == nil {
+		t.Errorf("LAX returned no error in strict mode")
+	}
+}
+
+func TestNMIPriorityOverIRQ(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	for i := range prgROM {
+		prgROM[i] = 0xEA // NOP
+	}
+	prgROM[0x1000] = 0x40 // $9000: RTI (NMI handler)
+	prgROM[0x2000] = 0x40 // $A000: RTI (IRQ handler)
+	// NMI vector: $9000, IRQ vector: $A000
+	prgROM[0x3FFA], prgROM[0x3FFB] = 0x00, 0x90
+	prgROM[0x3FFE], prgROM[0x3FFF] = 0x00, 0xA0
+	cpu := newTestCPUWithProgram(prgROM)
+	cpu.p.i = false
+	cpu.nmiTriggered = true
+	cpu.irqLine = true
+	// NMI first, the interrupt sequence is a step on its own.
+	cycles, err := cpu.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycles != 7 {
+		t.Errorf("NMI cycles: got=%d, want=7", cycles)
+	}
+	if cpu.pc != 0x9000 {
+		t.Fatalf("PC after NMI: got=0x%04x, want=0x9000", cpu.pc)
+	}
+	// RTI
+	if _, err := cpu.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.pc != 0x8000 {
+		t.Fatalf("PC after NMI handler: got=0x%04x, want=0x8000", cpu.pc)
+	}
+	// IRQ is still asserted.
+	if _, err := cpu.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(cpu.lastExecution, "IRQ") {
+		t.Errorf("The third step was not IRQ: %s", cpu.lastExecution)
+	}
+	if cpu.pc != 0xA000 {
+		t.Fatalf("PC after IRQ: got=0x%04x, want=0xa000", cpu.pc)
+	}
+	// RTI
+	if _, err := cpu.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.pc != 0x8000 {
+		t.Fatalf("PC after IRQ handler: got=0x%04x, want=0x8000", cpu.pc)
+	}
+	// IRQ is masked by I.
+	cpu.p.i = true
+	if _, err := cpu.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.pc != 0x8001 {
+		t.Errorf("PC after NOP with masked IRQ: got=0x%04x, want=0x8001", cpu.pc)
+	}
+}
+
+func TestZeroPageWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		x, y    byte
+		want    byte
+	}{
+		// Pointer at $FF: low from $FF, high from $00 -> $0234
+		{"indirect,Y pointer at $FF", []byte{0xB1, 0xFF}, 0x00, 0x01, 0x42}, // LDA ($FF),Y
+		{"indirect,X pointer at $FF", []byte{0xA1, 0xFE}, 0x01, 0x00, 0x41}, // LDA ($FE,X)
+		{"indirect,X index wraps", []byte{0xA1, 0x80}, 0x7F, 0x00, 0x41},    // LDA ($80,X)
+		{"zeropage,X wraps", []byte{0xB5, 0xF0}, 0x20, 0x00, 0x43},          // LDA $F0,X
+		{"zeropage,Y wraps", []byte{0xB6, 0xF0}, 0x00, 0x20, 0x43},          // LDX $F0,Y
+		{"indirect,Y crosses a page", []byte{0xB1, 0x20}, 0x00, 0x10, 0x44}, // LDA ($20),Y
+	}
+	for _, tt := range tests {
+		cpu := newTestCPUWithProgram(tt.program)
+		cpu.x = tt.x
+		cpu.y = tt.y
+		wram := cpu.bus.wram
+		wram.write(0x00FF, 0x34)
+		wram.write(0x0000, 0x02)
+		wram.write(0x0100, 0x05) // Read if the pointer fetch doesn't wrap.
+		wram.write(0x0234, 0x41)
+		wram.write(0x0235, 0x42)
+		wram.write(0x0534, 0xEE)
+		wram.write(0x0535, 0xEE)
+		wram.write(0x0010, 0x43)
+		wram.write(0x0020, 0xF8)
+		wram.write(0x0021, 0x02)
+		wram.write(0x0308, 0x44)
+		if _, err := cpu.Step(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := cpu.a
+		if tt.program[0] == 0xB6 {
+			got = cpu.x
+		}
+		if got != tt.want {
+			t.Errorf("%s: got=0x%02x, want=0x%02x", tt.name, got, tt.want)
+		}
+	}
+}
+
+// aluProgram exercises ADC/SBC overflow and carry corners, addressing modes and branches taken and not taken
+// across page boundaries, which nestest doesn't cover exhaustively. The execution is compared with testdata/alu.log,
+// which has been verified by hand. The log can be regenerated by `go test ./nes -run TestALUTrace -update`.
+var aluProgram = []struct {
+	address uint16
+	code    []byte
+}{
+	{0x8000, []byte{
+		0xD8,       // 8000 CLD
+		0x18,       // 8001 CLC
+		0xA9, 0x50, // 8002 LDA #$50
+		0x69, 0x50, // 8004 ADC #$50  ; $50+$50=$A0: N V
+		0x18,       // 8006 CLC
+		0xA9, 0xD0, // 8007 LDA #$D0
+		0x69, 0x90, // 8009 ADC #$90  ; $D0+$90=$160: V C
+		0x38,       // 800B SEC
+		0xA9, 0xFF, // 800C LDA #$FF
+		0x69, 0x00, // 800E ADC #$00  ; $FF+$00+1=$100: Z C
+		0x18,       // 8010 CLC
+		0xA9, 0x7F, // 8011 LDA #$7F
+		0x69, 0x01, // 8013 ADC #$01  ; $7F+$01=$80: N V
+		0x38,       // 8015 SEC
+		0xA9, 0x80, // 8016 LDA #$80
+		0x69, 0xFF, // 8018 ADC #$FF  ; $80+$FF+1=$180: N C
+		0x38,       // 801A SEC
+		0xA9, 0x50, // 801B LDA #$50
+		0xE9, 0xB0, // 801D SBC #$B0  ; $50-$B0=$A0: N V, borrow
+		0x18,       // 801F CLC
+		0xA9, 0x00, // 8020 LDA #$00
+		0xE9, 0x00, // 8022 SBC #$00  ; $00-$00-1=$FF: N, borrow
+		0x38,       // 8024 SEC
+		0xA9, 0xD0, // 8025 LDA #$D0
+		0xE9, 0x70, // 8027 SBC #$70  ; $D0-$70=$60: V C
+		0x38,       // 8029 SEC
+		0xA9, 0x80, // 802A LDA #$80
+		0xE9, 0x01, // 802C SBC #$01  ; $80-$01=$7F: V C
+		0x38,       // 802E SEC
+		0xA9, 0x42, // 802F LDA #$42
+		0xE9, 0x42, // 8031 SBC #$42  ; $42-$42=$00: Z C
+		0xF8,       // 8033 SED  ; the decimal mode is ignored on the NES
+		0x18,       // 8034 CLC
+		0xA9, 0x09, // 8035 LDA #$09
+		0x69, 0x01, // 8037 ADC #$01  ; $0A, not $10
+		0xD8,       // 8039 CLD
+		0xB8,       // 803A CLV
+		0xA9, 0x40, // 803B LDA #$40
+		0xC9, 0x41, // 803D CMP #$41  ; N
+		0xA2, 0x40, // 803F LDX #$40
+		0xE0, 0x40, // 8041 CPX #$40  ; Z C
+		0xA0, 0x40, // 8043 LDY #$40
+		0xC0, 0x3F, // 8045 CPY #$3F  ; C
+		0xA9, 0x11, // 8047 LDA #$11
+		0x85, 0x10, // 8049 STA $10  ; $10=$11
+		0xA2, 0x02, // 804B LDX #$02
+		0xA9, 0x22, // 804D LDA #$22
+		0x9D, 0x00, 0x03, // 804F STA $0300,X  ; $0302=$22
+		0xA0, 0xFF, // 8052 LDY #$FF
+		0xA9, 0x33, // 8054 LDA #$33
+		0x99, 0x01, 0x03, // 8056 STA $0301,Y  ; $0400=$33, page crossed
+		0xA9, 0x44, // 8059 LDA #$44
+		0x8D, 0x00, 0x03, // 805B STA $0300  ; $0300=$44
+		0xA9, 0x00, // 805E LDA #$00
+		0x85, 0x20, // 8060 STA $20
+		0xA9, 0x03, // 8062 LDA #$03
+		0x85, 0x21, // 8064 STA $21  ; ($20)=$0300
+		0xA9, 0xFF, // 8066 LDA #$FF
+		0x85, 0x22, // 8068 STA $22
+		0xA9, 0x02, // 806A LDA #$02
+		0x85, 0x23, // 806C STA $23  ; ($22)=$02FF
+		0xA5, 0x10, // 806E LDA $10  ; zero page
+		0xA2, 0x02, // 8070 LDX #$02
+		0xB5, 0x0E, // 8072 LDA $0E,X  ; zero page,X
+		0xA0, 0x02, // 8074 LDY #$02
+		0xB6, 0x0E, // 8076 LDX $0E,Y  ; zero page,Y
+		0xAD, 0x02, 0x03, // 8078 LDA $0302  ; absolute
+		0xA2, 0x02, // 807B LDX #$02
+		0xBD, 0x00, 0x03, // 807D LDA $0300,X  ; absolute,X
+		0xA0, 0xFF, // 8080 LDY #$FF
+		0xB9, 0x01, 0x03, // 8082 LDA $0301,Y  ; absolute,Y, page crossed
+		0xA2, 0x1E, // 8085 LDX #$1E
+		0xA1, 0x02, // 8087 LDA ($02,X)  ; (indirect,X)
+		0xA0, 0x02, // 8089 LDY #$02
+		0xB1, 0x20, // 808B LDA ($20),Y  ; (indirect),Y
+		0xA0, 0x01, // 808D LDY #$01
+		0xB1, 0x22, // 808F LDA ($22),Y  ; (indirect),Y, page crossed
+		0xA2, 0xFF, // 8091 LDX #$FF
+		0xB5, 0x11, // 8093 LDA $11,X  ; zero page,X wraps to $10
+		0xA9, 0x81, // 8095 LDA #$81
+		0x0A,       // 8097 ASL A  ; $02 C
+		0x6A,       // 8098 ROR A  ; $81, carry in
+		0xA2, 0x02, // 8099 LDX #$02
+		0xF6, 0x0E, // 809B INC $0E,X  ; $10=$12
+		0xCE, 0x02, 0x03, // 809D DEC $0302  ; $0302=$21
+		0xA9, 0xC0, // 80A0 LDA #$C0
+		0x85, 0x30, // 80A2 STA $30
+		0xA9, 0x01, // 80A4 LDA #$01
+		0x24, 0x30, // 80A6 BIT $30  ; N V Z from $C0
+		0x18,       // 80A8 CLC
+		0xA9, 0x01, // 80A9 LDA #$01
+		0x65, 0x10, // 80AB ADC $10  ; $01+$12=$13
+		0x38,             // 80AD SEC
+		0xFD, 0x00, 0x03, // 80AE SBC $0300,X  ; $13-$21=$F2
+		0xA9, 0xF0, // 80B1 LDA #$F0
+		0x8D, 0xFF, 0x02, // 80B3 STA $02FF
+		0xA9, 0x81, // 80B6 LDA #$81
+		0x8D, 0x00, 0x02, // 80B8 STA $0200
+		0xA9, 0x00, // 80BB LDA #$00
+		0x8D, 0x00, 0x03, // 80BD STA $0300
+		0x6C, 0xFF, 0x02, // 80C0 JMP ($02FF)  ; $81F0, the page boundary bug
+	}},
+	{0x81F0, []byte{
+		0xA9, 0x00, // 81F0 LDA #$00  ; Z
+		0xD0, 0x0B, // 81F2 BNE $81FF  ; not taken
+		0x30, 0x09, // 81F4 BMI $81FF  ; not taken
+		0x10, 0x00, // 81F6 BPL $81F8  ; taken
+		0x38,       // 81F8 SEC
+		0x90, 0x04, // 81F9 BCC $81FF  ; not taken
+		0x70, 0x02, // 81FB BVS $81FF  ; not taken
+		0xF0, 0x04, // 81FD BEQ $8203  ; taken, page crossed
+		0x4C, 0xFF, 0x81, // 81FF JMP $81FF
+		0xEA,       // 8202 NOP
+		0x18,       // 8203 CLC
+		0xB0, 0xF9, // 8204 BCS $81FF  ; not taken
+		0x90, 0x00, // 8206 BCC $8208  ; taken
+		0xA9, 0x7F, // 8208 LDA #$7F
+		0x69, 0x01, // 820A ADC #$01  ; N V
+		0x50, 0xF1, // 820C BVC $81FF  ; not taken
+		0x10, 0xEF, // 820E BPL $81FF  ; not taken
+		0x70, 0x00, // 8210 BVS $8212  ; taken
+		0xB8,       // 8212 CLV
+		0x50, 0x00, // 8213 BVC $8215  ; taken
+		0x30, 0x00, // 8215 BMI $8217  ; taken
+		0xA9, 0x01, // 8217 LDA #$01
+		0xF0, 0xE4, // 8219 BEQ $81FF  ; not taken
+		0xD0, 0xC3, // 821B BNE $81E0  ; taken, page crossed backward
+		0x4C, 0x1D, 0x82, // 821D JMP $821D
+	}},
+	{0x81E0, []byte{
+		0x38,       // 81E0 SEC
+		0xB0, 0x3A, // 81E1 BCS $821D  ; taken, page crossed forward
+	}},
+}
+
+// aluTraceLength is the number of instructions to reach the last JMP of aluProgram.
+const aluTraceLength = 126
+
+func TestALUTrace(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	for _, block := range aluProgram {
+		copy(prgROM[block.address-0x8000:], block.code)
+	}
+	// Reset vector: $8000
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	c, err := newNesConsole(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Trace(&buf, aluTraceLength); err != nil {
+		t.Fatal(err)
+	}
+	const log = "testdata/alu.log"
+	if *update {
+		if err := ioutil.WriteFile(log, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotLines := strings.Split(buf.String(), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := range wantLines {
+		if len(gotLines) <= i {
+			t.Fatalf("Trace ended at line %d, want: %s", i+1, wantLines[i])
+		}
+		if gotLines[i] != wantLines[i] {
+			t.Fatalf("Trace diverged at line %d\ngot:  %s\nwant: %s", i+1, gotLines[i], wantLines[i])
+		}
+	}
+}
+
+func TestNMIHijack(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	for i := range prgROM {
+		prgROM[i] = 0xEA // NOP
+	}
+	prgROM[0x0000] = 0x00 // $8000: BRK
+	// NMI vector: $9000, reset vector: $8000, IRQ/BRK vector: $A000
+	prgROM[0x3FFA], prgROM[0x3FFB] = 0x00, 0x90
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	prgROM[0x3FFE], prgROM[0x3FFF] = 0x00, 0xA0
+	tests := []struct {
+		name string
+		irq  bool
+		// ppuCycle is the PPU cycle on the scanline 240 to start, the NMI rises (341 - ppuCycle + 1) PPU cycles later.
+		ppuCycle int
+		wantPC   uint16
+		wantB    bool
+	}{
+		{"BRK, NMI at the first cycle", false, 340, 0x9000, true},
+		{"BRK, NMI at the 4th cycle", false, 330, 0x9000, true},
+		{"BRK, NMI after the 4th cycle", false, 329, 0xA000, true},
+		{"IRQ, NMI at the first cycle", true, 340, 0x9000, false},
+		{"IRQ, NMI after the 4th cycle", true, 329, 0xA000, false},
+	}
+	for _, tt := range tests {
+		c, err := newNesConsole(newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		c.ppu.writePPUCTRL(0x80)
+		c.ppu.scanline = 240
+		c.ppu.cycle = tt.ppuCycle
+		if tt.irq {
+			c.cpu.p.i = false
+			c.cpu.irqLine = true
+		}
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if c.cpu.pc != tt.wantPC {
+			t.Errorf("%s: PC: got=0x%04x, want=0x%04x", tt.name, c.cpu.pc, tt.wantPC)
+		}
+		// The NMI is consumed by the hijack, otherwise it's handled by the next step.
+		if got, want := c.cpu.nmiTriggered, tt.wantPC != 0x9000; got != want {
+			t.Errorf("%s: NMI pending: got=%v, want=%v", tt.name, got, want)
+		}
+		p, err := c.cpu.bus.read(0x0100 | uint16(c.cpu.s+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p&0x10 != 0; got != tt.wantB {
+			t.Errorf("%s: pushed B: got=%v, want=%v", tt.name, got, tt.wantB)
+		}
+	}
+}
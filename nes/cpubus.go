@@ -2,16 +2,27 @@ package nes
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/golang/glog"
 )
 
 type CPUBus struct {
-	wram       *RAM
-	ppu        *PPU
-	apu        *APU
-	cartridge  *Cartridge
-	controller *Controller
+	wram        *RAM
+	ppu         *PPU
+	apu         *APU
+	cartridge   *Cartridge
+	controller  *Controller // 1P
+	controller2 *Controller // 2P, strobe is shared with 1P ($4016).
+	cheats      []cheat
+	// ppuLog logs PPU register accesses if set.
+	ppuLog io.Writer
+	// controllerRead is set when $4016 or $4017 is read, the console clears it on each frame.
+	controllerRead bool
+	// consecutiveWrite is set by CPU if the next write is on the cycle right after the previous write.
+	consecutiveWrite bool
+	// logUnimplemented logs writes to unhandled I/O registers, nil discards them.
+	logUnimplemented func(format string, args ...interface{})
 }
 
 // NewCPUBus creates a new Bus for CPU.
@@ -28,8 +39,8 @@ type CPUBus struct {
 // $4018-$401F    $0008  APU and I/O functionality that is normally disabled. See CPU Test Mode.
 // $4020-$FFFF    $BFE0  Cartridge space: PRG ROM, PRG RAM, and mapper registers (See Note)
 
-func NewCPUBus(wram *RAM, ppu *PPU, apu *APU, cartridge *Cartridge, controller *Controller) *CPUBus {
-	return &CPUBus{wram, ppu, apu, cartridge, controller}
+func NewCPUBus(wram *RAM, ppu *PPU, apu *APU, cartridge *Cartridge, controller *Controller, controller2 *Controller) *CPUBus {
+	return &CPUBus{wram: wram, ppu: ppu, apu: apu, cartridge: cartridge, controller: controller, controller2: controller2, logUnimplemented: glog.Warningf}
 }
 
 // writeOAMDMA writes OAMDATA to PPU, this will be called by CPU.
@@ -37,17 +48,38 @@ func (b *CPUBus) writeOAMDMA(data [256]byte) {
 	b.ppu.primaryOAM = data
 }
 
+// logPPURegister logs a PPU register access, r is "R" or "W".
+func (b *CPUBus) logPPURegister(r string, address uint16, data byte) {
+	fmt.Fprintf(b.ppuLog, "%s $%04x = 0x%02x (scanline=%d, cycle=%d)\n", r, address, data, b.ppu.scanline, b.ppu.cycle)
+}
+
 func (b *CPUBus) readPPURegister(address uint16) (byte, error) {
+	data, err := b.readPPURegisterData(address)
+	if err == nil && b.ppuLog != nil {
+		b.logPPURegister("R", 0x2000|address%8, data)
+	}
+	return data, err
+}
+
+func (b *CPUBus) readPPURegisterData(address uint16) (byte, error) {
 	addr := 0x2000 | address%8
 	switch addr {
 	case 0x2002:
 		return b.ppu.readPPUSTATUS(), nil
 	case 0x2004:
-		return b.ppu.readOAMDATA(), nil
+		data := b.ppu.readOAMDATA()
+		b.ppu.register = data
+		return data, nil
 	case 0x2007:
-		return b.ppu.readPPUDATA()
+		data, err := b.ppu.readPPUDATA()
+		if err != nil {
+			return 0, err
+		}
+		b.ppu.register = data
+		return data, nil
 	default:
-		return 0, fmt.Errorf("PPU register $%04x (0x%04x) is not readable.", address, addr)
+		// Write-only registers return the open bus.
+		return b.ppu.register, nil
 	}
 }
 
@@ -62,18 +94,28 @@ func (b *CPUBus) read(address uint16) (byte, error) {
 			return 0, err
 		}
 		return data, nil
+	case address == 0x4015:
+		return b.apu.readStatus(), nil
 	case address == 0x4016: // 1P
+		b.controllerRead = true
 		return b.controller.read(), nil
 	case address == 0x4017: // 2P
-		// TODO(jyane): implement 2P controller
-		return 0, nil
+		b.controllerRead = true
+		return b.controller2.read(), nil
 	case address < 0x4018:
 		glog.V(1).Infof("Unimplemented CPU bus read: address=0x%04x\n", address)
 		return 0, nil
 	case address < 0x4020:
 		return 0, fmt.Errorf("Reading unused bus address: 0x%04x\n", address)
 	case 0x4020 <= address:
-		return b.cartridge.ReadFromCPU(address)
+		data, err := b.cartridge.ReadFromCPU(address)
+		if err != nil {
+			return 0, err
+		}
+		for i := range b.cheats {
+			data = b.cheats[i].apply(address, data)
+		}
+		return data, nil
 	default:
 		return 0, fmt.Errorf("Unknown CPU bus read: 0x%04x", address)
 	}
@@ -125,23 +167,55 @@ func (b *CPUBus) writeToAPURegisters(address uint16, data byte) {
 		b.apu.pulse2.writeTimerLow(data)
 	case 0x4007:
 		b.apu.pulse2.writeTimerHigh(data)
+	case 0x4008:
+		b.apu.triangle.writeControl(data)
+	case 0x4009, 0x400D:
+		// Unused, some games write them, e.g. clearing all APU registers in a loop.
+	case 0x400A:
+		b.apu.triangle.writeTimerLow(data)
+	case 0x400B:
+		b.apu.triangle.writeTimerHigh(data)
+	case 0x400C:
+		b.apu.noise.writeControl(data)
+	case 0x400E:
+		b.apu.noise.writePeriod(data)
+	case 0x400F:
+		b.apu.noise.writeLength(data)
+	case 0x4010:
+		b.apu.dmc.writeControl(data)
+	case 0x4011:
+		b.apu.dmc.writeLevel(data)
+	case 0x4012:
+		b.apu.dmc.writeAddress(data)
+	case 0x4013:
+		b.apu.dmc.writeLength(data)
 	case 0x4015:
 		b.apu.writeControl(data)
+	case 0x4017:
+		// $4017 write is not for 2P controller but APU frame counter.
+		b.apu.writeFrameCounter(data)
 	default:
-		glog.Warningf("Unimplemented APU register write, address=0x%04x, data=0x%02x\n", address, data)
+		if b.logUnimplemented != nil {
+			b.logUnimplemented("Unimplemented APU register write, address=0x%04x, data=0x%02x\n", address, data)
+		}
 	}
 }
 
 // writeToPPURegisters writes data to PPU registers.
 func (b *CPUBus) writeToPPURegisters(address uint16, data byte) error {
 	addr := 0x2000 | address%8
+	if b.ppuLog != nil {
+		b.logPPURegister("W", addr, data)
+	}
+	// Any write fills the open bus, also to the read-only $2002.
+	b.ppu.register = data
 	switch addr {
 	case 0x2000:
 		b.ppu.writePPUCTRL(data)
 	case 0x2001:
 		b.ppu.writePPUMASK(data)
 	case 0x2003:
-		b.ppu.writePPUADDR(data)
+		b.ppu.writeOAMADDR(data)
 	case 0x2004:
 		b.ppu.writeOAMDATA(data)
 	case 0x2005:
@@ -150,8 +224,6 @@ func (b *CPUBus) writeToPPURegisters(address uint16, data byte) error {
 		b.ppu.writePPUADDR(data)
 	case 0x2007:
 		return b.ppu.writePPUDATA(data)
-	default:
-		return fmt.Errorf("PPU register $%04x (0x%04x) is not writable.", address, addr)
 	}
 	return nil
 }
@@ -168,18 +240,30 @@ func (b *CPUBus) write(address uint16, data byte) error {
 	case address == 0x4014:
 		// Implemented on CPU
 		return fmt.Errorf("CPU bus write was probably illegally called. (OAMDMA $4014)")
-	case address == 0x4016: // 1P
+	case address == 0x4016: // Strobes both 1P and 2P.
 		b.controller.write(data)
-	case address == 0x4017: // 2P
-		// TODO(jyane): implement 2P controller.
+		b.controller2.write(data)
 	case address < 0x4018:
 		b.writeToAPURegisters(address, data)
 	case address < 0x4020:
 		return fmt.Errorf("Writing data to unused bus address: 0x%04x\n", address)
 	case 0x4020 <= address:
+		if m, ok := b.cartridge.Mapper.(consecutiveWriter); ok && b.consecutiveWrite {
+			return m.writeConsecutive(address, data)
+		}
 		return b.cartridge.WriteFromCPU(address, data)
 	default:
 		return fmt.Errorf("Unknown CPU bus write: address=0x%04x, data=0x%02x", address, data)
 	}
 	return nil
 }
+
+func (b *CPUBus) saveState(w *stateWriter) {
+	b.wram.saveState(w)
+	w.write(b.controllerRead, b.consecutiveWrite)
+}
+
+func (b *CPUBus) loadState(r *stateReader) {
+	b.wram.loadState(r)
+	r.read(&b.controllerRead, &b.consecutiveWrite)
+}
@@ -0,0 +1,125 @@
+package nes
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLogPPURegisters(t *testing.T) {
+	var buf bytes.Buffer
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, err := NewConsole(cartridge, false /* debug */, LogPPURegisters(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := console.(*NesConsole)
+	c.ppu.scanline = 241
+	c.ppu.cycle = 10
+	c.cpu.bus.write(0x2000, 0x80)
+	c.cpu.bus.write(0x2005, 0x12)
+	c.cpu.bus.write(0x3FFD, 0x34) // mirror of $2005
+	c.cpu.bus.read(0x2002)
+	// The low 5 bits of $2002 are the open bus, which is the last written value.
+	want := "W $2000 = 0x80 (scanline=241, cycle=10)\n" +
+		"W $2005 = 0x12 (scanline=241, cycle=10)\n" +
+		"W $2005 = 0x34 (scanline=241, cycle=10)\n" +
+		"R $2002 = 0x14 (scanline=241, cycle=10)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("PPU register log:\ngot:\n%swant:\n%s", got, want)
+	}
+}
+
+func TestStrobeBothControllers(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, false /* debug */)
+	c := console.(*NesConsole)
+	c.SetButtons([8]bool{ButtonA: true})
+	c.SetButtons2([8]bool{ButtonB: true})
+	b := c.cpu.bus
+	// Advances both controllers.
+	for i := 0; i < 3; i++ {
+		b.read(0x4016)
+		b.read(0x4017)
+	}
+	b.write(0x4016, 1)
+	b.write(0x4016, 0)
+	if c.controller.index != 0 || c.controller2.index != 0 {
+		t.Fatalf("Read index after strobe: 1P=%d, 2P=%d, want 0", c.controller.index, c.controller2.index)
+	}
+	for i, want := range []byte{1, 0} {
+		if got, _ := b.read(0x4016); got != want {
+			t.Errorf("1P button %d: got=%d, want=%d", i, got, want)
+		}
+	}
+	for i, want := range []byte{0, 1} {
+		if got, _ := b.read(0x4017); got != want {
+			t.Errorf("2P button %d: got=%d, want=%d", i, got, want)
+		}
+	}
+}
+
+func TestAPURegisterWritesNotLoggedAsUnimplemented(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, false /* debug */)
+	c := console.(*NesConsole)
+	var logs []string
+	c.cpu.bus.logUnimplemented = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	// All of $4000-$4017 including the unused $4009 and $400D, the CPU handles OAM DMA ($4014) before the bus.
+	for address := uint16(0x4000); address <= 0x4017; address++ {
+		if address == 0x4014 {
+			continue
+		}
+		if err := c.cpu.bus.write(address, 0x00); err != nil {
+			t.Errorf("Writing $%04x: %v", address, err)
+		}
+	}
+	if len(logs) != 0 {
+		t.Errorf("Unimplemented logs: got=%q, want none", logs)
+	}
+}
+
+func TestSilenceUnimplementedIO(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, false /* debug */, SilenceUnimplementedIO())
+	c := console.(*NesConsole)
+	if c.cpu.bus.logUnimplemented != nil {
+		t.Errorf("Unimplemented I/O logger should be discarded")
+	}
+}
+
+func TestPPUOpenBus(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	ppu := NewPPU(NewPPUBus(NewRAM(), cartridge))
+	b := NewCPUBus(NewRAM(), ppu, NewAPU(), cartridge, NewController(), NewController())
+	// $2003 is write-only and doesn't affect the other registers.
+	if err := b.write(0x2003, 0xA5); err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []uint16{0x2000, 0x2001, 0x2003, 0x2005, 0x2006, 0x3FF8} {
+		got, err := b.read(address)
+		if err != nil {
+			t.Fatalf("Reading $%04x: %v", address, err)
+		}
+		if got != 0xA5 {
+			t.Errorf("Reading $%04x: got=0x%02x, want=0xa5", address, got)
+		}
+	}
+	// $2002 merges the status bits and the low 5 bits of the open bus, the status bits refresh the open bus.
+	ppu.oldNMI = true
+	if got, _ := b.read(0x2002); got != 0x85 {
+		t.Errorf("Reading $2002: got=0x%02x, want=0x85", got)
+	}
+	if got, _ := b.read(0x2000); got != 0x85 {
+		t.Errorf("Reading $2000 after $2002: got=0x%02x, want=0x85", got)
+	}
+	// Writing the read-only $2002 also fills the open bus.
+	if err := b.write(0x2002, 0x3C); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := b.read(0x2006); got != 0x3C {
+		t.Errorf("Reading $2006 after writing $2002: got=0x%02x, want=0x3c", got)
+	}
+}
@@ -2,8 +2,10 @@ package nes
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"image"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
@@ -15,73 +17,104 @@ import (
 //   s:
 //     execute step(s).
 //   p:
-//     print.
+//     print, "p pr" prints decoded PPU registers.
 //   br:
 //     set a break point.
+//   se:
+//     search WRAM for a value, "se changed" and "se same" narrow the results.
 //   q:
 //     quit.
 //   r:
 //     reset.
+//   bu:
+//     break on unimplemented opcodes, "bu off" returns them as errors instead. This is on by default.
 type DebugConsole struct {
 	*NesConsole
+	in          *bufio.Reader
+	out         io.Writer
 	cycles      uint64
 	breakpoints []uint16
+	// candidates are WRAM addresses found by the search command, lastWRAM is WRAM at the last search.
+	candidates []uint16
+	lastWRAM   [2048]byte
+	// breakOnUnimplemented drops to the prompt on unimplemented opcodes, broken is set until checkBreak sees it.
+	breakOnUnimplemented bool
+	broken               bool
 }
 
-func (c *DebugConsole) Reset() error {
-	c.lastFrame = 0
-	c.currentFrame = 0
-	if err := c.cpu.Reset(); err != nil {
-		return err
+// NewDebugConsole creates a debug console which reads commands from in and writes outputs to out.
+// Commands can be scripted by giving a reader of commands, e.g. strings.NewReader("br 0xc000\ns 1s\n").
+func NewDebugConsole(cartridge *Cartridge, in io.Reader, out io.Writer, breakpoints []uint16, options ...Option) (*DebugConsole, error) {
+	console, err := newNesConsole(cartridge, options...)
+	if err != nil {
+		return nil, err
 	}
-	c.ppu.Reset()
-	return nil
+	return &DebugConsole{
+		NesConsole:           console,
+		in:                   bufio.NewReader(in),
+		out:                  out,
+		breakpoints:          append([]uint16{}, breakpoints...),
+		breakOnUnimplemented: true,
+	}, nil
 }
 
 func (c *DebugConsole) step() (int, error) {
 	cycles, err := c.cpu.Step()
 	c.cycles += uint64(cycles)
+	var unimplemented *unimplementedOpcodeError
+	if errors.As(err, &unimplemented) && c.breakOnUnimplemented {
+		next, _ := Disassemble(func(address uint16) byte {
+			data, _ := c.cpu.bus.read(address)
+			return data
+		}, unimplemented.pc)
+		fmt.Fprintf(c.out, "Break on unimplemented opcode 0x%02x at: 0x%04x  %s\n", unimplemented.opcode, unimplemented.pc, next)
+		c.broken = true
+		return cycles, nil
+	}
 	if err != nil {
 		return cycles, err
 	}
-	for i := 0; i < cycles*3; i++ {
-		nmi, err := c.ppu.Step()
-		if err != nil {
-			return cycles, err
-		}
-		if nmi {
-			c.cpu.nmiTriggered = true
-		}
-		ok, f := c.ppu.Frame()
-		if ok {
-			c.currentFrame++
-			c.buffer = f
-		}
-	}
-	return cycles, nil
+	return cycles, c.catchUp(cycles)
+}
+
+// SpriteZeroHit returns the sprite 0 hit flag of PPUSTATUS for tooling, unlike reading $2002 this has no side effects.
+func (c *DebugConsole) SpriteZeroHit() bool {
+	return c.ppu.spriteZeroHit
+}
+
+// SpriteOverflow returns the sprite overflow flag of PPUSTATUS for tooling, unlike reading $2002 this has no side effects.
+func (c *DebugConsole) SpriteOverflow() bool {
+	return c.ppu.spriteOverflow
 }
 
 func (c *DebugConsole) printstack() {
 	for i := 0; i < 256; i++ {
 		idx := uint16(0x100 | i)
 		data, _ := c.cpu.bus.read(idx)
-		fmt.Printf("0x%04x: 0x%02x, ", idx, data)
+		fmt.Fprintf(c.out, "0x%04x: 0x%02x, ", idx, data)
 		if i%16 == 0 {
-			fmt.Println()
+			fmt.Fprintln(c.out)
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(c.out)
 }
 
 func (c *DebugConsole) basePrint() {
-	fmt.Println("--------------------------------------------------")
-	fmt.Printf("Executed cycles: %d\n", c.cycles)
-	fmt.Printf("Rendered frame: %d\n", c.currentFrame)
-	fmt.Println("Last: " + c.cpu.lastExecution)
-	fmt.Printf("CPU:  PC=0x%04x, A=0x%02x, X=0x%02x, Y=0x%02x, S=0x%02x, P=0x%02x\n",
+	fmt.Fprintln(c.out, "--------------------------------------------------")
+	fmt.Fprintf(c.out, "Executed cycles: %d\n", c.cycles)
+	fmt.Fprintf(c.out, "Rendered frame: %d\n", c.currentFrame)
+	fmt.Fprintf(c.out, "Lag frames: %d\n", c.lagFrames)
+	fmt.Fprintln(c.out, "Last: "+c.cpu.lastExecution)
+	next, _ := Disassemble(func(address uint16) byte {
+		data, _ := c.cpu.bus.read(address)
+		return data
+	}, c.cpu.pc)
+	fmt.Fprintf(c.out, "Next: %04X  %s\n", c.cpu.pc, next)
+	fmt.Fprintf(c.out, "CPU:  PC=0x%04x, A=0x%02x, X=0x%02x, Y=0x%02x, S=0x%02x, P=0x%02x\n",
 		c.cpu.pc, c.cpu.a, c.cpu.x, c.cpu.y, c.cpu.s, c.cpu.p.encode())
-	fmt.Printf("PPU: cycle=%d, scanline=%d, p.v=0x%04x, fineX(ppu.x)=%d, fineY=%d, coarseX=%d, coarseY=%d\n",
+	fmt.Fprintf(c.out, "PPU: cycle=%d, scanline=%d, p.v=0x%04x, fineX(ppu.x)=%d, fineY=%d, coarseX=%d, coarseY=%d\n",
 		c.ppu.cycle, c.ppu.scanline, c.ppu.v, c.ppu.x, (c.ppu.v>>12)&7, c.ppu.v&31, (c.ppu.v>>5)&31)
+	fmt.Fprintf(c.out, "Mapper: %s, IRQ=%t\n", c.cartridge.Mapper.Name(), c.cartridge.Mapper.IRQPending())
 }
 
 func (c *DebugConsole) printCommand(args []string) {
@@ -90,25 +123,31 @@ func (c *DebugConsole) printCommand(args []string) {
 	} else {
 		switch args[1] {
 		case "c", "cpu":
-			fmt.Printf("%+v\n", *c.cpu)
+			fmt.Fprintf(c.out, "%+v\n", *c.cpu)
 		case "p", "ppu":
-			fmt.Printf("%+v\n", *c.ppu)
+			fmt.Fprintf(c.out, "%+v\n", *c.ppu)
+		case "pr", "ppuregisters":
+			fmt.Fprint(c.out, c.ppu.registersString())
 		case "ca", "cartridge":
-			fmt.Printf("%+v\n", *c.cpu.bus.cartridge)
+			fmt.Fprintf(c.out, "%+v\n", *c.cpu.bus.cartridge)
 		case "ct", "controller":
-			fmt.Printf("%+v\n", *c.controller)
+			fmt.Fprintf(c.out, "%+v\n", *c.controller)
 		case "wr", "wram":
-			fmt.Printf("%+v\n", *c.cpu.bus.wram)
+			fmt.Fprintf(c.out, "%+v\n", *c.cpu.bus.wram)
 		case "vr", "vram":
-			fmt.Printf("%+v\n", *c.ppu.bus.vram)
+			fmt.Fprintf(c.out, "%+v\n", *c.ppu.bus.vram)
 		}
 	}
 }
 
 func (c *DebugConsole) checkBreak() bool {
+	if c.broken {
+		c.broken = false
+		return true
+	}
 	for i := 0; i < len(c.breakpoints); i++ {
 		if c.breakpoints[i] == c.cpu.pc {
-			fmt.Printf("Break at: 0x%04x\n", c.breakpoints[i])
+			fmt.Fprintf(c.out, "Break at: 0x%04x\n", c.breakpoints[i])
 			return true
 		}
 	}
@@ -177,15 +216,63 @@ func (c *DebugConsole) breakPointCommand(args []string) error {
 	return nil
 }
 
+// search narrows the candidates with the condition, the first search scans all of WRAM.
+func (c *DebugConsole) search(match func(address uint16) bool) {
+	if c.candidates == nil {
+		for i := range c.lastWRAM {
+			c.candidates = append(c.candidates, uint16(i))
+		}
+	}
+	candidates := []uint16{}
+	for _, address := range c.candidates {
+		if match(address) {
+			candidates = append(candidates, address)
+		}
+	}
+	c.candidates = candidates
+	c.lastWRAM = c.cpu.bus.wram.data
+}
+
+func (c *DebugConsole) searchCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("search requires a value, \"changed\", \"same\" or \"reset\"")
+	}
+	wram := &c.cpu.bus.wram.data
+	switch args[1] {
+	case "changed":
+		c.search(func(address uint16) bool { return wram[address] != c.lastWRAM[address] })
+	case "same":
+		c.search(func(address uint16) bool { return wram[address] == c.lastWRAM[address] })
+	case "reset":
+		c.candidates = nil
+		return nil
+	default:
+		value, err := strconv.ParseUint(args[1], 0, 8)
+		if err != nil {
+			return fmt.Errorf("Invalid search value %s: %w", args[1], err)
+		}
+		c.search(func(address uint16) bool { return wram[address] == byte(value) })
+	}
+	fmt.Fprintf(c.out, "Found %d addresses\n", len(c.candidates))
+	for i, address := range c.candidates {
+		if i == 64 {
+			fmt.Fprintln(c.out, "...")
+			break
+		}
+		fmt.Fprintf(c.out, "0x%04x: 0x%02x\n", address, wram[address])
+	}
+	return nil
+}
+
 func (c *DebugConsole) quitCommand() {
-	fmt.Println("Quitting.")
+	fmt.Fprintln(c.out, "Quitting.")
 	os.Exit(0)
 }
 
-func (c *DebugConsole) Step() (int, error) {
-	fmt.Printf("Debugger mode, 'q' to quit \n>> ")
-	in := bufio.NewReader(os.Stdin)
-	line, err := in.ReadString('\n')
+func (c *DebugConsole) Step() (cycles int, err error) {
+	defer c.recoverStep(&err)
+	fmt.Fprintf(c.out, "Debugger mode, 'q' to quit \n>> ")
+	line, err := c.in.ReadString('\n')
 	if err != nil {
 		return 0, err
 	}
@@ -200,12 +287,19 @@ func (c *DebugConsole) Step() (int, error) {
 		if err != nil {
 			return cycles, err
 		}
-		fmt.Printf("Executed %d CPU cycles, %d PPU cycles.\n", cycles, 3*cycles)
+		fmt.Fprintf(c.out, "Executed %d CPU cycles, %d PPU cycles.\n", cycles, 3*cycles)
 		return cycles, nil
 	case "br", "breakpoint":
 		if err := c.breakPointCommand(args); err != nil {
 			return 0, err
 		}
+	case "se", "search":
+		if err := c.searchCommand(args); err != nil {
+			fmt.Fprintln(c.out, err)
+		}
+	case "bu":
+		c.breakOnUnimplemented = len(args) < 2 || args[1] != "off"
+		fmt.Fprintf(c.out, "Break on unimplemented opcodes: %t\n", c.breakOnUnimplemented)
 	case "r", "reset":
 		c.Reset()
 	case "q", "quit":
@@ -0,0 +1,209 @@
+package nes
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestDebugConsole() *DebugConsole {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, true /* debug */)
+	return console.(*DebugConsole)
+}
+
+func TestDebugConsoleSearch(t *testing.T) {
+	c := newTestDebugConsole()
+	wram := c.cpu.bus.wram
+	wram.write(0x0010, 5)
+	wram.write(0x0020, 5)
+	wram.write(0x0300, 5)
+	if err := c.searchCommand([]string{"search", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0x0010, 0x0020, 0x0300}; !reflect.DeepEqual(c.candidates, want) {
+		t.Fatalf("search 5: got=%v, want=%v", c.candidates, want)
+	}
+	wram.write(0x0010, 4)
+	wram.write(0x0300, 4)
+	if err := c.searchCommand([]string{"search", "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0x0010, 0x0300}; !reflect.DeepEqual(c.candidates, want) {
+		t.Fatalf("search changed: got=%v, want=%v", c.candidates, want)
+	}
+	wram.write(0x0010, 3)
+	if err := c.searchCommand([]string{"search", "same"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0x0300}; !reflect.DeepEqual(c.candidates, want) {
+		t.Fatalf("search same: got=%v, want=%v", c.candidates, want)
+	}
+	if err := c.searchCommand([]string{"search", "0x04"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0x0300}; !reflect.DeepEqual(c.candidates, want) {
+		t.Fatalf("search 0x04: got=%v, want=%v", c.candidates, want)
+	}
+}
+
+func TestScriptedDebugSession(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	for i := range prgROM {
+		prgROM[i] = 0xEA // NOP
+	}
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	script := "s 100\nbr 0x8020\ns 100\n"
+	var out bytes.Buffer
+	c, err := NewDebugConsole(cartridge, strings.NewReader(script), &out, []uint16{0x8010})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// Stops at the preset breakpoint.
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if c.cpu.pc != 0x8010 {
+		t.Errorf("PC after the first step command: got=0x%04x, want=0x8010", c.cpu.pc)
+	}
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	// Stops at the breakpoint from the script.
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if c.cpu.pc != 0x8020 {
+		t.Errorf("PC after the second step command: got=0x%04x, want=0x8020", c.cpu.pc)
+	}
+	for _, want := range []string{"Break at: 0x8010", "Break at: 0x8020"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("The output doesn't contain %q:\n%s", want, out.String())
+		}
+	}
+	// The script has ended.
+	if _, err := c.Step(); err != io.EOF {
+		t.Errorf("Step after the script: got=%v, want=%v", err, io.EOF)
+	}
+}
+
+func TestBreakOnUnimplementedOpcode(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	// NOP; NOP; STP
+	copy(prgROM, []byte{0xEA, 0xEA, 0x02})
+	prgROM[0x3FFC], prgROM[0x3FFD] = 0x00, 0x80
+	cartridge := newTestCartridge(0, prgROM, make([]byte, chrROMSizeUnit))
+	script := "s 100\nbu off\ns 1\n"
+	var out bytes.Buffer
+	c, err := NewDebugConsole(cartridge, strings.NewReader(script), &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// Breaks to the prompt instead of returning the error.
+	if _, err := c.Step(); err != nil {
+		t.Fatalf("Step with an unimplemented opcode: got=%v, want no error", err)
+	}
+	if c.cpu.pc != 0x8002 {
+		t.Errorf("PC at the break: got=0x%04x, want=0x8002", c.cpu.pc)
+	}
+	if want := "Break on unimplemented opcode 0x02 at: 0x8002  .DB $02"; !strings.Contains(out.String(), want) {
+		t.Errorf("The output doesn't contain %q:\n%s", want, out.String())
+	}
+	// "bu off" returns the error.
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Step(); err == nil || !strings.Contains(err.Error(), "unimplemented instruction") {
+		t.Errorf("Step with bu off: got=%v, want the unimplemented instruction error", err)
+	}
+}
+
+func TestDebugConsoleReset(t *testing.T) {
+	c := newTestDebugConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	c.apu.writeControl(0x01)
+	c.apu.pulse1.writeTimerHigh(0x08)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if c.apu.pulse1.enabled || c.apu.pulse1.lengthCounter != 0 {
+		t.Errorf("pulse 1 after Reset: enabled=%v, length=%d, want silenced", c.apu.pulse1.enabled, c.apu.pulse1.lengthCounter)
+	}
+}
+
+func TestDebugConsoleStepTakesRewindStates(t *testing.T) {
+	c := newTestDebugConsole()
+	EnableRewind(1)(c.NesConsole)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for c.currentFrame < rewindInterval {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.rewind.n != 1 {
+		t.Errorf("states: got=%d, want=1", c.rewind.n)
+	}
+}
+
+func TestDebugConsoleSpriteFlags(t *testing.T) {
+	c := newTestDebugConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// Tile 1 is opaque, the name table is filled with tile 1.
+	for i := 0x10; i < 0x18; i++ {
+		c.cartridge.chrROM[i] = 0xFF
+	}
+	for address := uint16(0x2000); address < 0x23C0; address++ {
+		if err := c.ppu.bus.write(address, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sprite 0 at (10, 10) with tile 1.
+	copy(c.ppu.primaryOAM[:], []byte{10, 1, 0, 10})
+	c.ppu.writePPUMASK(0x1E)
+	for c.ppu.scanline != 20 {
+		if _, err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.SpriteZeroHit() {
+		t.Errorf("SpriteZeroHit: got=false, want=true")
+	}
+	if c.SpriteOverflow() {
+		t.Errorf("SpriteOverflow: got=true, want=false")
+	}
+	// The accessor has no side effects unlike $2002.
+	if !c.SpriteZeroHit() || c.ppu.readPPUSTATUS()>>6&1 != 1 {
+		t.Errorf("The sprite 0 hit flag was cleared by the accessor")
+	}
+}
+
+func TestDebugConsoleRecoverPanics(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	var out bytes.Buffer
+	c, err := NewDebugConsole(cartridge, strings.NewReader("s 1\n"), &out, nil, RecoverPanics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	cartridge.Mapper = &panicMapper{cartridge.Mapper}
+	if _, err := c.Step(); err == nil || !strings.Contains(err.Error(), "induced panic") {
+		t.Errorf("Step with a panic: got=%v, want the recovered panic", err)
+	}
+}
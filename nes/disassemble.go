@@ -0,0 +1,76 @@
+package nes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// disassemblyTable is the instruction table for disassembling, only mnemonics, modes and sizes are used.
+var disassemblyTable = (&CPU{}).createInstructions()
+
+// Disassemble decodes an instruction at pc with read, independently of a running console.
+// This returns its assembly in nestest.log format e.g. "JMP $C5F5" and the size of the instruction.
+func Disassemble(read func(uint16) byte, pc uint16) (string, uint16) {
+	opcode := read(pc)
+	instruction := disassemblyTable[opcode]
+	if instruction.mnemonic == "" {
+		return fmt.Sprintf(".DB $%02X", opcode), 1
+	}
+	raw := []byte{opcode}
+	for i := uint16(1); i < instruction.size; i++ {
+		raw = append(raw, read(pc+i))
+	}
+	return assembly(instruction, raw, pc), instruction.size
+}
+
+// disassemble disassembles an instruction at the address with Disassemble reading the CPU bus.
+// This returns raw bytes of the instruction and its assembly in nestest.log format e.g. "JMP $C5F5".
+func (c *CPU) disassemble(address uint16) ([]byte, string, error) {
+	var raw []byte
+	var err error
+	assembly, _ := Disassemble(func(address uint16) byte {
+		data, e := c.bus.read(address)
+		if err == nil {
+			err = e
+		}
+		raw = append(raw, data)
+		return data
+	}, address)
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, assembly, nil
+}
+
+// assembly formats the raw bytes of the instruction at the address.
+func assembly(instruction instruction, raw []byte, address uint16) string {
+	var operand string
+	switch instruction.mode {
+	case accumulator:
+		operand = "A"
+	case immediate:
+		operand = fmt.Sprintf("#$%02X", raw[1])
+	case zeropage:
+		operand = fmt.Sprintf("$%02X", raw[1])
+	case zeropageX:
+		operand = fmt.Sprintf("$%02X,X", raw[1])
+	case zeropageY:
+		operand = fmt.Sprintf("$%02X,Y", raw[1])
+	case relative:
+		// Relative shows the destination.
+		operand = fmt.Sprintf("$%04X", address+2+uint16(int8(raw[1])))
+	case absolute:
+		operand = fmt.Sprintf("$%04X", uint16(raw[2])<<8|uint16(raw[1]))
+	case absoluteX:
+		operand = fmt.Sprintf("$%04X,X", uint16(raw[2])<<8|uint16(raw[1]))
+	case absoluteY:
+		operand = fmt.Sprintf("$%04X,Y", uint16(raw[2])<<8|uint16(raw[1]))
+	case indirect:
+		operand = fmt.Sprintf("($%04X)", uint16(raw[2])<<8|uint16(raw[1]))
+	case indirectX:
+		operand = fmt.Sprintf("($%02X,X)", raw[1])
+	case indirectY:
+		operand = fmt.Sprintf("($%02X),Y", raw[1])
+	}
+	return strings.TrimSpace(instruction.mnemonic + " " + operand)
+}
@@ -0,0 +1,39 @@
+package nes
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	memory := map[uint16]byte{
+		0xC000: 0x4C, 0xC001: 0xF5, 0xC002: 0xC5, // JMP $C5F5
+		0xC003: 0xA9, 0xC004: 0x10, // LDA #$10
+		0xC005: 0xB1, 0xC006: 0xFF, // LDA ($FF),Y
+		0xC007: 0x0A,               // ASL A
+		0xC008: 0xD0, 0xC009: 0xFB, // BNE $C005
+		0xC00A: 0x6C, 0xC00B: 0x00, 0xC00C: 0x02, // JMP ($0200)
+		0xC00D: 0x02, // not an instruction
+		0xC00E: 0xEA, // NOP
+	}
+	read := func(address uint16) byte {
+		return memory[address]
+	}
+	tests := []struct {
+		pc       uint16
+		want     string
+		wantSize uint16
+	}{
+		{0xC000, "JMP $C5F5", 3},
+		{0xC003, "LDA #$10", 2},
+		{0xC005, "LDA ($FF),Y", 2},
+		{0xC007, "ASL A", 1},
+		{0xC008, "BNE $C005", 2},
+		{0xC00A, "JMP ($0200)", 3},
+		{0xC00D, ".DB $02", 1},
+		{0xC00E, "NOP", 1},
+	}
+	for _, tt := range tests {
+		got, size := Disassemble(read, tt.pc)
+		if got != tt.want || size != tt.wantSize {
+			t.Errorf("Disassemble(0x%04x): got=(%q, %d), want=(%q, %d)", tt.pc, got, size, tt.want, tt.wantSize)
+		}
+	}
+}
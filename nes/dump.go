@@ -0,0 +1,44 @@
+package nes
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// DumpFrames executes n frames and writes each frame to dir as frame_00000.png, frame_00001.png, ...
+// The frame rate is written to dir/info.txt so that the frames can be assembled into a video, e.g.
+// ffmpeg -framerate 60.0988 -i frame_%05d.png out.mp4 (50.007 for PAL)
+// This is supposed to be called after Reset.
+func (c *NesConsole) DumpFrames(dir string, n int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create the directory: %w", err)
+	}
+	info := fmt.Sprintf("frames=%d\nframerate=%g\n", n, c.region.FrameRate())
+	if err := os.WriteFile(filepath.Join(dir, "info.txt"), []byte(info), 0644); err != nil {
+		return fmt.Errorf("Failed to write the frame info: %w", err)
+	}
+	for i := 0; i < n; {
+		if _, err := c.Step(); err != nil {
+			return err
+		}
+		frame, ok := c.Frame()
+		if !ok {
+			continue
+		}
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("frame_%05d.png", i)))
+		if err != nil {
+			return fmt.Errorf("Failed to create a frame file: %w", err)
+		}
+		if err := png.Encode(f, frame); err != nil {
+			f.Close()
+			return fmt.Errorf("Failed to encode frame %d: %w", i, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
@@ -1,18 +1,142 @@
 package nes
 
+import (
+	"fmt"
+	"io"
+)
+
 type Mapper interface {
 	ReadFromCPU(uint16) (byte, error)
 	WriteFromCPU(uint16, byte) error
 	ReadFromPPU(uint16) (byte, error)
 	WriteFromPPU(uint16, byte) error
+	// Name returns the board name of the mapper, e.g. "NROM".
+	Name() string
+	// IRQPending returns true while the mapper asserts the IRQ line, e.g. the MMC3 scanline counter.
+	IRQPending() bool
+}
+
+// noIRQ is embedded in mappers which don't have IRQ sources.
+type noIRQ struct{}
+
+func (noIRQ) IRQPending() bool {
+	return false
+}
+
+// bankLogger is implemented by mappers which can log their bank switches.
+type bankLogger interface {
+	setBankLog(w io.Writer)
+}
+
+// bankLog is embedded in mappers to log bank switches to w if set.
+type bankLog struct {
+	w io.Writer
+}
+
+func (l *bankLog) setBankLog(w io.Writer) {
+	l.w = w
+}
+
+// logBankSwitch logs a change of a bank or the mirroring with the write that caused it, unchanged values are not logged.
+func (l *bankLog) logBankSwitch(name string, old int, new int, address uint16, data byte) {
+	if l.w == nil || old == new {
+		return
+	}
+	fmt.Fprintf(l.w, "%s %d -> %d ($%04x = 0x%02x)\n", name, old, new, address, data)
+}
+
+// consecutiveWriter is implemented by mappers which handle a write on the cycle right after another write differently.
+type consecutiveWriter interface {
+	writeConsecutive(address uint16, data byte) error
+}
+
+// ppuAddressWatcher is implemented by mappers which watch the pattern table addresses fetched by the PPU.
+type ppuAddressWatcher interface {
+	watchPPUAddress(address uint16)
+}
+
+// chrOwner is implemented by mappers which allocate their own CHR RAM and ignore the CHR of the cartridge.
+type chrOwner interface {
+	ownsCHR()
+}
+
+// prgRAMController is implemented by mappers which handle $6000-$7FFF themselves, e.g. to protect PRG RAM.
+type prgRAMController interface {
+	controlsPRGRAM()
+}
+
+// stateSaver is implemented by mappers which have state to save, e.g. bank registers.
+type stateSaver interface {
+	saveState(w *stateWriter)
+	loadState(r *stateReader)
+}
+
+// supportedMappers is the mapper numbers which NewMapper can create, keep this in sync with NewMapper.
+var supportedMappers = []uint16{0, 1, 2, 3, 4, 7, 30}
+
+// SupportedMappers returns the supported mapper numbers in ascending order, e.g. for frontends to warn unsupported ROMs.
+func SupportedMappers() []uint16 {
+	return append([]uint16(nil), supportedMappers...)
+}
+
+// board is the hardware of the cartridge around the mapper chip, which the header describes.
+type board struct {
+	// prgRAM is the PRG RAM of the cartridge, mappers which control $6000-$7FFF use it.
+	prgRAM []byte
+	// chrRAM is true if the board has CHR RAM, which is writable, instead of CHR ROM.
+	chrRAM bool
+	// flags6 is the header byte 6, some mappers read the mirroring and the battery bits differently.
+	flags6 byte
 }
 
-func NewMapper(number byte, prgROM []byte, chrROM []byte) Mapper {
+// NewMapper creates a mapper, this returns an error if the ROM sizes don't fit the mapper.
+func NewMapper(number uint16, prgROM []byte, chrROM []byte, b board) (Mapper, error) {
 	switch number {
 	case 0:
-		return &mapper0{prgROM, chrROM}
+		// NROM has 16KB or 32KB PRG ROM and 8KB CHR.
+		if len(prgROM) != prgROMSizeUnit && len(prgROM) != prgROMSizeUnit*2 {
+			return nil, fmt.Errorf("NROM supports 16KB or 32KB PRG ROM, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		if len(chrROM) != chrROMSizeUnit {
+			return nil, fmt.Errorf("NROM supports 8KB CHR, got=%dKB, the mapper may be wrong", len(chrROM)/1024)
+		}
+		return &mapper0{prgROM: prgROM, chrROM: chrROM, chrRAM: b.chrRAM}, nil
+	case 1:
+		if len(prgROM) == 0 || len(prgROM)%prgROMSizeUnit != 0 {
+			return nil, fmt.Errorf("MMC1 requires 16KB PRG ROM banks, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		return newMapper1(prgROM, chrROM, b), nil
 	case 2:
-		return NewMapper2(prgROM)
+		if len(prgROM) == 0 || len(prgROM)%prgROMSizeUnit != 0 {
+			return nil, fmt.Errorf("UxROM requires 16KB PRG ROM banks, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		return NewMapper2(prgROM), nil
+	case 3:
+		if len(prgROM) != prgROMSizeUnit && len(prgROM) != prgROMSizeUnit*2 {
+			return nil, fmt.Errorf("CNROM supports 16KB or 32KB PRG ROM, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		if len(chrROM) == 0 || len(chrROM)%chrROMSizeUnit != 0 {
+			return nil, fmt.Errorf("CNROM requires 8KB CHR ROM banks, got=%dKB, the mapper may be wrong", len(chrROM)/1024)
+		}
+		return newMapper3(prgROM, chrROM), nil
+	case 4:
+		if len(prgROM) < 0x4000 || len(prgROM)%0x2000 != 0 {
+			return nil, fmt.Errorf("MMC3 requires at least 16KB PRG ROM in 8KB banks, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		return newMapper4(prgROM, chrROM, b), nil
+	case 7:
+		if len(prgROM) == 0 || len(prgROM)%0x8000 != 0 {
+			return nil, fmt.Errorf("AxROM requires 32KB PRG ROM banks, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		if len(chrROM) != chrROMSizeUnit {
+			return nil, fmt.Errorf("AxROM supports 8KB CHR, got=%dKB, the mapper may be wrong", len(chrROM)/1024)
+		}
+		return newMapper7(prgROM, chrROM, b), nil
+	case 30:
+		if len(prgROM) == 0 || len(prgROM)%prgROMSizeUnit != 0 {
+			return nil, fmt.Errorf("UNROM 512 requires 16KB PRG ROM banks, got=%dKB, the mapper may be wrong", len(prgROM)/1024)
+		}
+		return newMapper30(prgROM, b), nil
 	}
-	return nil
+	return nil, fmt.Errorf("Mapper%d is not implemented.", number)
 }
@@ -3,29 +3,36 @@ package nes
 import "fmt"
 
 type mapper0 struct {
+	noIRQ
 	prgROM []byte
 	chrROM []byte
+	// chrRAM is true if the board has CHR RAM, which is writable.
+	chrRAM bool
 }
 
 // Mapper0: https://www.nesdev.org/wiki/NROM
 
+func (m *mapper0) Name() string {
+	return "NROM"
+}
+
 // currently only supports mapper0.
 func (m *mapper0) ReadFromCPU(address uint16) (byte, error) {
 	if 0x8000 <= address {
 		// CPU $C000-$FFFF: Last 16 KB of ROM (NROM-256) or mirror of $8000-$BFFF (NROM-128).
-		mod := uint16(len(m.prgROM))
-		return m.prgROM[(address-0x8000)%mod], nil
+		// Computing with int, since len(m.prgROM) may not fit in uint16.
+		return m.prgROM[int(address-0x8000)%len(m.prgROM)], nil
 	}
-	// CPU $6000-$7FFF: Family Basic only: PRG RAM, mirrored as necessary to fill entire 8 KiB window, write protectable with an external switch
-	return 0, fmt.Errorf("Reading PRGRAM not implemented. address: 0x%04x", address)
+	// CPU $6000-$7FFF: PRG RAM is served by the cartridge.
+	return 0, fmt.Errorf("Reading cartridge address 0x%04x is not allowed", address)
 }
 
 func (m *mapper0) WriteFromCPU(address uint16, data byte) error {
 	if 0x8000 <= address {
 		return fmt.Errorf("Writing data to PrgROM not allowed: address=0x%04x, data=0x%02x", address, data)
 	}
-	// CPU $6000-$7FFF: Family Basic only: PRG RAM, mirrored as necessary to fill entire 8 KiB window, write protectable with an external switch
-	return fmt.Errorf("Writing data to PRGRAM not implemented. address: 0x%04x, data: 0x%02x", address, data)
+	// CPU $6000-$7FFF: PRG RAM is served by the cartridge.
+	return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
 }
 
 func (m *mapper0) ReadFromPPU(address uint16) (byte, error) {
@@ -33,5 +40,9 @@ func (m *mapper0) ReadFromPPU(address uint16) (byte, error) {
 }
 
 func (m *mapper0) WriteFromPPU(address uint16, data byte) error {
+	if m.chrRAM {
+		m.chrROM[address] = data
+		return nil
+	}
 	return fmt.Errorf("Writing data to pattern tables not allowed, address=0x%04x, data=0x%02x", address, data)
 }
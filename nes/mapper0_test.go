@@ -0,0 +1,60 @@
+package nes
+
+import "testing"
+
+func newTestPRGROM(size int) []byte {
+	prgROM := make([]byte, size)
+	for i := range prgROM {
+		prgROM[i] = byte(i / prgROMSizeUnit)
+	}
+	// Marks both edges of each 16KB bank.
+	for i := 0; i < size; i += prgROMSizeUnit {
+		prgROM[i] = byte(0xA0 + i/prgROMSizeUnit)
+		prgROM[i+prgROMSizeUnit-1] = byte(0xB0 + i/prgROMSizeUnit)
+	}
+	return prgROM
+}
+
+func TestMapper0NROM128(t *testing.T) {
+	m := &mapper0{prgROM: newTestPRGROM(0x4000), chrROM: make([]byte, chrROMSizeUnit)}
+	tests := []struct {
+		address uint16
+		want    byte
+	}{
+		{0x8000, 0xA0},
+		{0xBFFF, 0xB0},
+		{0xC000, 0xA0}, // mirror of $8000
+		{0xFFFF, 0xB0}, // mirror of $BFFF
+	}
+	for _, tt := range tests {
+		got, err := m.ReadFromCPU(tt.address)
+		if err != nil {
+			t.Fatalf("ReadFromCPU(0x%04x) returned an error: %v", tt.address, err)
+		}
+		if got != tt.want {
+			t.Errorf("ReadFromCPU(0x%04x): got=0x%02x, want=0x%02x", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestMapper0NROM256(t *testing.T) {
+	m := &mapper0{prgROM: newTestPRGROM(0x8000), chrROM: make([]byte, chrROMSizeUnit)}
+	tests := []struct {
+		address uint16
+		want    byte
+	}{
+		{0x8000, 0xA0},
+		{0xBFFF, 0xB0},
+		{0xC000, 0xA1}, // the second bank, not a mirror.
+		{0xFFFF, 0xB1},
+	}
+	for _, tt := range tests {
+		got, err := m.ReadFromCPU(tt.address)
+		if err != nil {
+			t.Fatalf("ReadFromCPU(0x%04x) returned an error: %v", tt.address, err)
+		}
+		if got != tt.want {
+			t.Errorf("ReadFromCPU(0x%04x): got=0x%02x, want=0x%02x", tt.address, got, tt.want)
+		}
+	}
+}
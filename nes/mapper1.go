@@ -0,0 +1,181 @@
+package nes
+
+import "fmt"
+
+// Mapper1: https://www.nesdev.org/wiki/MMC1
+// MMC1 has 4 internal registers written serially through a 5-bit shift register.
+type mapper1 struct {
+	bankLog
+	noIRQ
+	prgROM []byte
+	chrROM []byte
+	prgRAM []byte
+	// chrRAM is true if the board has CHR RAM, which is writable.
+	chrRAM bool
+
+	// shiftRegister is loaded from the LSB of 5 writes, shiftCount counts the writes.
+	shiftRegister byte
+	shiftCount    int
+
+	// Control ($8000-$9FFF), CPPMM
+	// C: CHR ROM bank mode (0: 8KB, 1: two 4KB banks)
+	// P: PRG ROM bank mode (0, 1: 32KB at $8000, 2: fix the first bank at $8000, 3: fix the last bank at $C000)
+	// M: mirroring (0: one-screen lower, 1: one-screen upper, 2: vertical, 3: horizontal)
+	control  byte
+	chrBank0 byte // CHR bank 0 ($A000-$BFFF)
+	chrBank1 byte // CHR bank 1 ($C000-$DFFF)
+	prgBank  byte // PRG bank ($E000-$FFFF), RPPPP, R: PRG RAM disabled
+}
+
+func newMapper1(prgROM []byte, chrROM []byte, b board) *mapper1 {
+	return &mapper1{
+		prgROM: prgROM,
+		chrROM: chrROM,
+		prgRAM: b.prgRAM,
+		chrRAM: b.chrRAM,
+		// The last bank is fixed at $C000 on power-on, so that the reset vector is found.
+		control: 0x0C,
+	}
+}
+
+func (m *mapper1) controlsPRGRAM() {}
+
+func (m *mapper1) Name() string {
+	return "MMC1"
+}
+
+func (m *mapper1) ReadFromCPU(address uint16) (byte, error) {
+	switch {
+	case 0x8000 <= address:
+		return m.prgROM[m.prgAddress(address)], nil
+	case 0x6000 <= address:
+		return m.prgRAM[address-0x6000], nil
+	}
+	return 0, fmt.Errorf("Reading cartridge address 0x%04x is not allowed", address)
+}
+
+// prgAddress converts a CPU address ($8000-$FFFF) to an address of the PRG ROM.
+func (m *mapper1) prgAddress(address uint16) int {
+	banks := len(m.prgROM) / prgROMSizeUnit
+	bank := int(m.prgBank & 0x0F)
+	var i int
+	switch m.control >> 2 & 3 {
+	case 0, 1:
+		// 32KB mode ignores the low bit of the bank number.
+		i = (bank&^1)*prgROMSizeUnit + int(address-0x8000)
+	case 2:
+		if address < 0xC000 {
+			i = int(address - 0x8000)
+		} else {
+			i = bank*prgROMSizeUnit + int(address-0xC000)
+		}
+	case 3:
+		if address < 0xC000 {
+			i = bank*prgROMSizeUnit + int(address-0x8000)
+		} else {
+			i = (banks-1)*prgROMSizeUnit + int(address-0xC000)
+		}
+	}
+	return i % len(m.prgROM)
+}
+
+func (m *mapper1) WriteFromCPU(address uint16, data byte) error {
+	switch {
+	case 0x8000 <= address:
+		m.writeShiftRegister(address, data)
+		return nil
+	case 0x6000 <= address:
+		m.prgRAM[address-0x6000] = data
+		return nil
+	}
+	return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
+}
+
+// writeConsecutive is called for a write on the cycle right after another write, e.g. the second write of
+// read-modify-write instructions. MMC1 ignores it on the serial port.
+func (m *mapper1) writeConsecutive(address uint16, data byte) error {
+	if 0x8000 <= address {
+		return nil
+	}
+	return m.WriteFromCPU(address, data)
+}
+
+// writeShiftRegister loads a bit to the shift register, the 5th write copies it to the register selected by the address.
+func (m *mapper1) writeShiftRegister(address uint16, data byte) {
+	// Writing a value with bit 7 set resets the shift register and fixes the last bank at $C000.
+	if data&0x80 == 0x80 {
+		m.shiftRegister = 0
+		m.shiftCount = 0
+		m.control |= 0x0C
+		return
+	}
+	m.shiftRegister |= (data & 1) << m.shiftCount
+	m.shiftCount++
+	if m.shiftCount < 5 {
+		return
+	}
+	value := m.shiftRegister
+	m.shiftRegister = 0
+	m.shiftCount = 0
+	switch address & 0xE000 {
+	case 0x8000:
+		m.logBankSwitch("Mirroring", int(m.control&3), int(value&3), address, data)
+		m.control = value
+	case 0xA000:
+		m.logBankSwitch("CHR bank 0", int(m.chrBank0), int(value), address, data)
+		m.chrBank0 = value
+	case 0xC000:
+		m.logBankSwitch("CHR bank 1", int(m.chrBank1), int(value), address, data)
+		m.chrBank1 = value
+	case 0xE000:
+		m.logBankSwitch("PRG bank", int(m.prgBank&0x0F), int(value&0x0F), address, data)
+		m.prgBank = value
+	}
+}
+
+// chrAddress converts a PPU address ($0000-$1FFF) to an address of the CHR ROM.
+func (m *mapper1) chrAddress(address uint16) int {
+	var i int
+	if m.control>>4&1 == 0 {
+		// 8KB mode ignores the low bit of the bank number.
+		i = int(m.chrBank0&^1)*0x1000 + int(address)
+	} else if address < 0x1000 {
+		i = int(m.chrBank0)*0x1000 + int(address)
+	} else {
+		i = int(m.chrBank1)*0x1000 + int(address-0x1000)
+	}
+	return i % len(m.chrROM)
+}
+
+func (m *mapper1) ReadFromPPU(address uint16) (byte, error) {
+	return m.chrROM[m.chrAddress(address)], nil
+}
+
+func (m *mapper1) WriteFromPPU(address uint16, data byte) error {
+	if !m.chrRAM {
+		return fmt.Errorf("Writing data to CHR ROM not allowed, address=0x%04x, data=0x%02x", address, data)
+	}
+	m.chrROM[m.chrAddress(address)] = data
+	return nil
+}
+
+func (m *mapper1) mirror() (MirrorMode, bool) {
+	switch m.control & 3 {
+	case 0:
+		return singleScreenLow, true
+	case 1:
+		return singleScreenHigh, true
+	case 2:
+		return vertical, true
+	default:
+		return horizontal, true
+	}
+}
+
+func (m *mapper1) saveState(w *stateWriter) {
+	w.write(m.shiftRegister, m.shiftCount, m.control, m.chrBank0, m.chrBank1, m.prgBank)
+}
+
+func (m *mapper1) loadState(r *stateReader) {
+	r.read(&m.shiftRegister, &m.shiftCount, &m.control, &m.chrBank0, &m.chrBank1, &m.prgBank)
+}
@@ -0,0 +1,154 @@
+package nes
+
+import "testing"
+
+// writeMMC1 writes the 5-bit value to the register through the serial port.
+func writeMMC1(t *testing.T, m *mapper1, address uint16, value byte) {
+	t.Helper()
+	for i := 0; i < 5; i++ {
+		if err := m.WriteFromCPU(address, value>>i&1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestMMC1 creates MMC1 with 8 PRG banks and 4 CHR 4KB banks, each filled with its bank number.
+func newTestMMC1() *mapper1 {
+	prgROM := make([]byte, prgROMSizeUnit*8)
+	for i := range prgROM {
+		prgROM[i] = byte(i / prgROMSizeUnit)
+	}
+	chrROM := make([]byte, 0x1000*4)
+	for i := range chrROM {
+		chrROM[i] = byte(i / 0x1000)
+	}
+	return newMapper1(prgROM, chrROM, board{prgRAM: make([]byte, prgRAMSize)})
+}
+
+func TestMapper1PRGBanks(t *testing.T) {
+	tests := []struct {
+		name    string
+		control byte
+		prgBank byte
+		want8   byte // bank at $8000
+		wantC   byte // bank at $C000
+	}{
+		{"32KB", 0x00, 0x05, 4, 5},
+		{"fix first bank", 0x08, 0x05, 0, 5},
+		{"fix last bank", 0x0C, 0x05, 5, 7},
+	}
+	for _, tt := range tests {
+		m := newTestMMC1()
+		writeMMC1(t, m, 0x8000, tt.control)
+		writeMMC1(t, m, 0xE000, tt.prgBank)
+		for address, want := range map[uint16]byte{0x8000: tt.want8, 0xC000: tt.wantC} {
+			got, err := m.ReadFromCPU(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s: bank at 0x%04x: got=%d, want=%d", tt.name, address, got, want)
+			}
+		}
+	}
+}
+
+func TestMapper1CHRBanks(t *testing.T) {
+	tests := []struct {
+		name    string
+		control byte
+		want0   byte // bank at $0000
+		want1   byte // bank at $1000
+	}{
+		{"8KB", 0x0C, 2, 3},
+		{"4KB", 0x1C, 3, 1},
+	}
+	for _, tt := range tests {
+		m := newTestMMC1()
+		writeMMC1(t, m, 0x8000, tt.control)
+		writeMMC1(t, m, 0xA000, 3)
+		writeMMC1(t, m, 0xC000, 1)
+		for address, want := range map[uint16]byte{0x0000: tt.want0, 0x1000: tt.want1} {
+			got, err := m.ReadFromPPU(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s: bank at 0x%04x: got=%d, want=%d", tt.name, address, got, want)
+			}
+		}
+	}
+}
+
+func TestMapper1Mirroring(t *testing.T) {
+	cartridge := newTestCartridge(1, make([]byte, prgROMSizeUnit*2), make([]byte, chrROMSizeUnit))
+	m := cartridge.Mapper.(*mapper1)
+	tests := []struct {
+		control byte
+		want    MirrorMode
+	}{
+		{0x0C, singleScreenLow},
+		{0x0D, singleScreenHigh},
+		{0x0E, vertical},
+		{0x0F, horizontal},
+	}
+	for _, tt := range tests {
+		writeMMC1(t, m, 0x8000, tt.control)
+		if got := cartridge.Mirror(); got != tt.want {
+			t.Errorf("Mirror with control 0x%02x: got=%v, want=%v", tt.control, got, tt.want)
+		}
+	}
+}
+
+func TestMapper1Reset(t *testing.T) {
+	m := newTestMMC1()
+	writeMMC1(t, m, 0x8000, 0x00)
+	// A partial write is discarded by the reset.
+	m.WriteFromCPU(0xE000, 1)
+	m.WriteFromCPU(0xE000, 1)
+	m.WriteFromCPU(0x8000, 0x80)
+	if m.shiftCount != 0 || m.shiftRegister != 0 {
+		t.Errorf("Shift register after reset: got=%d bits (0x%02x), want=0", m.shiftCount, m.shiftRegister)
+	}
+	if got, want := m.control, byte(0x0C); got != want {
+		t.Errorf("Control after reset: got=0x%02x, want=0x%02x", got, want)
+	}
+	writeMMC1(t, m, 0xE000, 0x02)
+	if got, _ := m.ReadFromCPU(0x8000); got != 2 {
+		t.Errorf("Bank at 0x8000: got=%d, want=2", got)
+	}
+}
+
+func TestMapper1IgnoresConsecutiveWrite(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit*2)
+	// INC $8000 at $C000, $8000 is 0x00, so the dummy write loads 0 and the result 1 is ignored.
+	copy(prgROM[prgROMSizeUnit:], []byte{0xEE, 0x00, 0x80})
+	cartridge := newTestCartridge(1, prgROM, make([]byte, chrROMSizeUnit))
+	ppu := NewPPU(NewPPUBus(NewRAM(), cartridge))
+	cpu := NewCPU(NewCPUBus(NewRAM(), ppu, NewAPU(), cartridge, NewController(), NewController()))
+	cpu.pc = 0xC000
+	if _, err := cpu.Step(); err != nil {
+		t.Fatal(err)
+	}
+	m := cartridge.Mapper.(*mapper1)
+	if m.shiftCount != 1 || m.shiftRegister != 0 {
+		t.Errorf("Shift register after INC $8000: got=%d bits (0x%02x), want=1 bit (0x00)", m.shiftCount, m.shiftRegister)
+	}
+	// The next write is not consecutive.
+	if err := cpu.write(0x8000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if m.shiftCount != 2 || m.shiftRegister != 0x02 {
+		t.Errorf("Shift register after STA: got=%d bits (0x%02x), want=2 bits (0x02)", m.shiftCount, m.shiftRegister)
+	}
+}
+
+func TestMapper1PRGRAM(t *testing.T) {
+	m := newTestMMC1()
+	if err := m.WriteFromCPU(0x6123, 0x42); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.ReadFromCPU(0x6123); got != 0x42 {
+		t.Errorf("PRG RAM 0x6123: got=0x%02x, want=0x42", got)
+	}
+}
@@ -3,6 +3,8 @@ package nes
 import "fmt"
 
 type mapper2 struct {
+	bankLog
+	noIRQ
 	banks       int
 	currentBank int
 	prgROM      []byte
@@ -17,6 +19,12 @@ func NewMapper2(prgROM []byte) *mapper2 {
 	return m
 }
 
+func (m *mapper2) ownsCHR() {}
+
+func (m *mapper2) Name() string {
+	return "UxROM"
+}
+
 func (m *mapper2) ReadFromCPU(address uint16) (byte, error) {
 	// CPU $8000-$BFFF: 16 KB switchable PRG ROM bank
 	// CPU $C000-$FFFF: 16 KB PRG ROM bank, fixed to the last bank
@@ -34,7 +42,9 @@ func (m *mapper2) WriteFromCPU(address uint16, data byte) error {
 	// CPU $8000-$BFFF: 16 KB switchable PRG ROM bank
 	// CPU $C000-$FFFF: 16 KB PRG ROM bank, fixed to the last bank
 	if 0x8000 <= address {
-		m.currentBank = int(data) % m.banks
+		bank := int(data) % m.banks
+		m.logBankSwitch("PRG bank", m.currentBank, bank, address, data)
+		m.currentBank = bank
 		return nil
 	}
 	return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
@@ -48,3 +58,12 @@ func (m *mapper2) WriteFromPPU(address uint16, data byte) error {
 	m.chrROM[address] = data
 	return nil
 }
+
+func (m *mapper2) saveState(w *stateWriter) {
+	w.write(m.currentBank, m.chrROM)
+}
+
+func (m *mapper2) loadState(r *stateReader) {
+	r.read(&m.currentBank, m.chrROM)
+	r.checkRange("PRG bank", m.currentBank, m.banks)
+}
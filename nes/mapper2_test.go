@@ -0,0 +1,63 @@
+package nes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMapper2BankSwitchLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMapper2(make([]byte, prgROMSizeUnit*4))
+	m.setBankLog(&buf)
+	writes := []struct {
+		address uint16
+		data    byte
+	}{
+		{0x8000, 0x03},
+		{0x8000, 0x03}, // unchanged, not logged.
+		{0xC123, 0x05}, // 5 % 4 = 1
+	}
+	for _, w := range writes {
+		if err := m.WriteFromCPU(w.address, w.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "PRG bank 0 -> 3 ($8000 = 0x03)\n" +
+		"PRG bank 3 -> 1 ($c123 = 0x05)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Bank switch log:\ngot:\n%swant:\n%s", got, want)
+	}
+}
+
+func TestMapper2ResetVectorFromFixedBank(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit*4)
+	for bank := 0; bank < 4; bank++ {
+		// Each bank has a different reset vector: $8000 + bank * $100
+		prgROM[bank*prgROMSizeUnit+0x3FFC] = 0x00
+		prgROM[bank*prgROMSizeUnit+0x3FFD] = 0x80 + byte(bank)
+	}
+	cartridge := newTestCartridge(2, prgROM, nil)
+	c, err := newNesConsole(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.cpu.pc, uint16(0x8300); got != want {
+		t.Errorf("PC after power-on: got=0x%04x, want=0x%04x", got, want)
+	}
+	// The switched bank is mapped at $8000-$BFFF, the vector is still read from the fixed last bank.
+	if err := cartridge.WriteFromCPU(0x8000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.cpu.pc, uint16(0x8300); got != want {
+		t.Errorf("PC after reset with bank 1: got=0x%04x, want=0x%04x", got, want)
+	}
+	if got, _ := cartridge.ReadFromCPU(0xBFFD); got != 0x81 {
+		t.Errorf("$BFFD after reset: got=0x%02x, want=0x81 (bank 1 is kept)", got)
+	}
+}
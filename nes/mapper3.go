@@ -0,0 +1,58 @@
+package nes
+
+import "fmt"
+
+// Mapper3: https://www.nesdev.org/wiki/CNROM
+// PRG ROM is the same as NROM, writes to $8000-$FFFF select an 8KB CHR ROM bank.
+type mapper3 struct {
+	bankLog
+	noIRQ
+	prgROM  []byte
+	chrROM  []byte
+	banks   int
+	chrBank int
+}
+
+func newMapper3(prgROM []byte, chrROM []byte) *mapper3 {
+	return &mapper3{prgROM: prgROM, chrROM: chrROM, banks: len(chrROM) / chrROMSizeUnit}
+}
+
+func (m *mapper3) Name() string {
+	return "CNROM"
+}
+
+func (m *mapper3) ReadFromCPU(address uint16) (byte, error) {
+	if 0x8000 <= address {
+		// 16KB PRG ROM is mirrored at $C000 like NROM-128.
+		return m.prgROM[int(address-0x8000)%len(m.prgROM)], nil
+	}
+	return 0, fmt.Errorf("Reading cartridge address 0x%04x is not allowed", address)
+}
+
+func (m *mapper3) WriteFromCPU(address uint16, data byte) error {
+	if 0x8000 <= address {
+		// Some ROMs write values larger than the number of the banks, the upper bits are not connected.
+		bank := int(data) % m.banks
+		m.logBankSwitch("CHR bank", m.chrBank, bank, address, data)
+		m.chrBank = bank
+		return nil
+	}
+	return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
+}
+
+func (m *mapper3) ReadFromPPU(address uint16) (byte, error) {
+	return m.chrROM[m.chrBank*chrROMSizeUnit+int(address)], nil
+}
+
+func (m *mapper3) WriteFromPPU(address uint16, data byte) error {
+	return fmt.Errorf("Writing data to pattern tables not allowed, address=0x%04x, data=0x%02x", address, data)
+}
+
+func (m *mapper3) saveState(w *stateWriter) {
+	w.write(m.chrBank)
+}
+
+func (m *mapper3) loadState(r *stateReader) {
+	r.read(&m.chrBank)
+	r.checkRange("CHR bank", m.chrBank, m.banks)
+}
@@ -0,0 +1,142 @@
+package nes
+
+import "fmt"
+
+// Mapper30: https://www.nesdev.org/wiki/UNROM_512
+// UNROM 512 is a homebrew board with 32KB CHR RAM and optionally self-flashable PRG ROM for saving.
+type mapper30 struct {
+	bankLog
+	noIRQ
+	banks       int
+	currentBank int
+	chrBank     int
+	prgROM      []byte
+	chrRAM      []byte
+	// oneScreen is true if the header selects the one-screen mirroring, screen selects the page.
+	oneScreen bool
+	screen    int
+	// flashable is true if the header has the battery bit, then $8000-$BFFF writes are flash commands.
+	flashable  bool
+	flashState int
+}
+
+// UNROM 512 uses the header bits differently.
+// flags6 bit 3 and bit 0 = %10: one-screen mirroring, bit 1 (battery): self-flashable
+func newMapper30(prgROM []byte, b board) *mapper30 {
+	return &mapper30{
+		banks:     len(prgROM) / prgROMSizeUnit,
+		prgROM:    prgROM,
+		chrRAM:    make([]byte, chrROMSizeUnit*4),
+		oneScreen: b.flags6&9 == 8,
+		flashable: b.flags6&2 == 2,
+	}
+}
+
+func (m *mapper30) ownsCHR() {}
+
+func (m *mapper30) Name() string {
+	return "UNROM 512"
+}
+
+func (m *mapper30) ReadFromCPU(address uint16) (byte, error) {
+	// CPU $8000-$BFFF: 16 KB switchable PRG ROM bank
+	// CPU $C000-$FFFF: 16 KB PRG ROM bank, fixed to the last bank
+	if address < 0x8000 {
+		return 0, fmt.Errorf("Reading cartridge address 0x%04x is not allowed", address)
+	}
+	return m.prgROM[m.prgAddress(address)], nil
+}
+
+// prgAddress converts a CPU address ($8000-$FFFF) to an address of the PRG ROM.
+func (m *mapper30) prgAddress(address uint16) int {
+	if address < 0xC000 {
+		return m.currentBank*prgROMSizeUnit + int(address-0x8000)
+	}
+	return (m.banks-1)*prgROMSizeUnit + int(address-0xC000)
+}
+
+func (m *mapper30) WriteFromCPU(address uint16, data byte) error {
+	if address < 0x8000 {
+		return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
+	}
+	if m.flashable && address < 0xC000 {
+		m.writeFlash(m.prgAddress(address), data)
+		return nil
+	}
+	// MCCP PPPP
+	// M: one-screen mirroring page, C: 8KB CHR RAM bank, P: 16KB PRG ROM bank
+	bank, chrBank, screen := int(data&0x1F)%m.banks, int(data>>5)&3, int(data>>7)&1
+	m.logBankSwitch("PRG bank", m.currentBank, bank, address, data)
+	m.logBankSwitch("CHR bank", m.chrBank, chrBank, address, data)
+	if m.oneScreen {
+		m.logBankSwitch("One-screen page", m.screen, screen, address, data)
+	}
+	m.currentBank, m.chrBank, m.screen = bank, chrBank, screen
+	return nil
+}
+
+// writeFlash handles software data protection command sequences of the SST39SF040 flash.
+// Byte-program: $AA to $5555, $55 to $2AAA, $A0 to $5555, then the data to the address.
+// Sector-erase: $AA to $5555, $55 to $2AAA, $80 to $5555, $AA to $5555, $55 to $2AAA, $30 to the sector.
+// Reference: https://www.nesdev.org/wiki/UNROM_512#Flash_save
+func (m *mapper30) writeFlash(address int, data byte) {
+	command := address & 0x7FFF
+	state := m.flashState
+	m.flashState = 0
+	switch {
+	case state == 3:
+		// Programming can only clear bits.
+		m.prgROM[address] &= data
+	case state == 6 && data == 0x30:
+		sector := address &^ 0xFFF
+		for i := sector; i < sector+0x1000; i++ {
+			m.prgROM[i] = 0xFF
+		}
+	case (state == 0 || state == 4) && command == 0x5555 && data == 0xAA:
+		m.flashState = state + 1
+	case (state == 1 || state == 5) && command == 0x2AAA && data == 0x55:
+		m.flashState = state + 1
+	case state == 2 && command == 0x5555 && data == 0xA0:
+		m.flashState = 3
+	case state == 2 && command == 0x5555 && data == 0x80:
+		m.flashState = 4
+	}
+}
+
+func (m *mapper30) ReadFromPPU(address uint16) (byte, error) {
+	return m.chrRAM[m.chrBank*chrROMSizeUnit+int(address)], nil
+}
+
+func (m *mapper30) WriteFromPPU(address uint16, data byte) error {
+	m.chrRAM[m.chrBank*chrROMSizeUnit+int(address)] = data
+	return nil
+}
+
+func (m *mapper30) mirror() (MirrorMode, bool) {
+	if !m.oneScreen {
+		return 0, false
+	}
+	if m.screen == 0 {
+		return singleScreenLow, true
+	}
+	return singleScreenHigh, true
+}
+
+// saveState also saves PRG ROM if it's flashable, since games save data by flashing it.
+func (m *mapper30) saveState(w *stateWriter) {
+	w.write(m.currentBank, m.chrBank, m.screen, m.flashState, m.chrRAM)
+	if m.flashable {
+		w.write(m.prgROM)
+	}
+}
+
+func (m *mapper30) loadState(r *stateReader) {
+	r.read(&m.currentBank, &m.chrBank, &m.screen, &m.flashState, m.chrRAM)
+	r.checkRange("PRG bank", m.currentBank, m.banks)
+	r.checkRange("CHR bank", m.chrBank, len(m.chrRAM)/chrROMSizeUnit)
+	r.checkRange("One-screen page", m.screen, 2)
+	r.checkRange("Flash state", m.flashState, 7)
+	if m.flashable {
+		r.read(m.prgROM)
+	}
+}
@@ -0,0 +1,126 @@
+package nes
+
+import "testing"
+
+func newTestMapper30(flags6 byte) *Cartridge {
+	prgROM := make([]byte, prgROMSizeUnit*32)
+	for bank := 0; bank < 32; bank++ {
+		prgROM[bank*prgROMSizeUnit] = byte(bank)
+	}
+	header := []byte{'N', 'E', 'S', msDOSEOF, 32, 0, 0xE0 | flags6, 0x10, 0, 0, 0, 0, 0, 0, 0, 0}
+	cartridge, err := NewCartridge(append(header, prgROM...))
+	if err != nil {
+		panic(err)
+	}
+	return cartridge
+}
+
+func TestMapper30Banks(t *testing.T) {
+	cartridge := newTestMapper30(0)
+	if err := cartridge.WriteFromCPU(0x8000, 0x65); err != nil { // CHR bank 3, PRG bank 5
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromCPU(0x8000); got != 5 {
+		t.Errorf("$8000 with PRG bank 5: got=%d, want=5", got)
+	}
+	if got, _ := cartridge.ReadFromCPU(0xC000); got != 31 {
+		t.Errorf("$C000 (fixed to the last bank): got=%d, want=31", got)
+	}
+	if err := cartridge.WriteFromPPU(0x0010, 0xAB); err != nil {
+		t.Fatal(err)
+	}
+	if err := cartridge.WriteFromCPU(0x8000, 0x05); err != nil { // CHR bank 0
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromPPU(0x0010); got != 0x00 {
+		t.Errorf("CHR bank 0: got=0x%02x, want=0x00", got)
+	}
+	if err := cartridge.WriteFromCPU(0xFFFF, 0x65); err != nil { // CHR bank 3
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromPPU(0x0010); got != 0xAB {
+		t.Errorf("CHR bank 3: got=0x%02x, want=0xab", got)
+	}
+}
+
+func TestMapper30Mirroring(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags6 byte
+		data   byte
+		want   MirrorMode
+	}{
+		{"horizontal", 0x00, 0x80, horizontal},
+		{"vertical", 0x01, 0x80, vertical},
+		{"one-screen low", 0x08, 0x00, singleScreenLow},
+		{"one-screen high", 0x08, 0x80, singleScreenHigh},
+	}
+	for _, tt := range tests {
+		cartridge := newTestMapper30(tt.flags6)
+		if err := cartridge.WriteFromCPU(0xC000, tt.data); err != nil {
+			t.Fatal(err)
+		}
+		if got := cartridge.Mirror(); got != tt.want {
+			t.Errorf("%s: got=%d, want=%d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMapper30Flash(t *testing.T) {
+	cartridge := newTestMapper30(0x02)
+	write := func(bank byte, address uint16, data byte) {
+		if err := cartridge.WriteFromCPU(0xC000, bank); err != nil {
+			t.Fatal(err)
+		}
+		if err := cartridge.WriteFromCPU(address, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sector-erase $8000-$8FFF of bank 2.
+	write(1, 0x9555, 0xAA)
+	write(0, 0xAAAA, 0x55)
+	write(1, 0x9555, 0x80)
+	write(1, 0x9555, 0xAA)
+	write(0, 0xAAAA, 0x55)
+	write(2, 0x8000, 0x30)
+	if got, _ := cartridge.ReadFromCPU(0x8000); got != 0xFF {
+		t.Errorf("Erased $8000 of bank 2: got=0x%02x, want=0xff", got)
+	}
+	// Byte-program $8001 of bank 2.
+	write(1, 0x9555, 0xAA)
+	write(0, 0xAAAA, 0x55)
+	write(1, 0x9555, 0xA0)
+	write(2, 0x8001, 0x42)
+	if got, _ := cartridge.ReadFromCPU(0x8001); got != 0x42 {
+		t.Errorf("Programmed $8001 of bank 2: got=0x%02x, want=0x42", got)
+	}
+	// A write without the command sequence is ignored.
+	write(2, 0x8002, 0x00)
+	if got, _ := cartridge.ReadFromCPU(0x8002); got != 0xFF {
+		t.Errorf("$8002 written without a command: got=0x%02x, want=0xff", got)
+	}
+}
+
+func TestMapper30FlashKeepsROMData(t *testing.T) {
+	header := []byte{'N', 'E', 'S', msDOSEOF, 2, 0, 0xE2, 0x10, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(header, make([]byte, prgROMSizeUnit*2)...)
+	cartridge, err := NewCartridge(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sector-erase $8000-$8FFF of bank 0, each write is {bank, address, data}.
+	for _, w := range [][3]uint16{{1, 0x9555, 0xAA}, {0, 0xAAAA, 0x55}, {1, 0x9555, 0x80}, {1, 0x9555, 0xAA}, {0, 0xAAAA, 0x55}, {0, 0x8000, 0x30}} {
+		if err := cartridge.WriteFromCPU(0xC000, byte(w[0])); err != nil {
+			t.Fatal(err)
+		}
+		if err := cartridge.WriteFromCPU(w[1], byte(w[2])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := cartridge.ReadFromCPU(0x8000); got != 0xFF {
+		t.Fatalf("Erased $8000: got=0x%02x, want=0xff", got)
+	}
+	if got := data[len(header)]; got != 0 {
+		t.Errorf("Flashing changed the ROM data given to NewCartridge: got=0x%02x, want=0x00", got)
+	}
+}
@@ -0,0 +1,42 @@
+package nes
+
+import "testing"
+
+func TestMapper3CHRBanks(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit*4)
+	for bank := 0; bank < 4; bank++ {
+		chrROM[bank*chrROMSizeUnit+0x10] = byte(bank)
+	}
+	cartridge := newTestCartridge(3, make([]byte, prgROMSizeUnit), chrROM)
+	tests := []struct {
+		address uint16
+		data    byte
+		want    byte
+	}{
+		{0x8000, 0x02, 2},
+		{0xFFFF, 0x03, 3},
+		{0x8000, 0x05, 1}, // larger than the number of banks, 5 % 4 = 1
+	}
+	for _, tt := range tests {
+		if err := cartridge.WriteFromCPU(tt.address, tt.data); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := cartridge.ReadFromPPU(0x0010); got != tt.want {
+			t.Errorf("CHR bank after $%04x = 0x%02x: got=%d, want=%d", tt.address, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMapper3PRGROM(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit)
+	prgROM[0x0123] = 0x42
+	cartridge := newTestCartridge(3, prgROM, make([]byte, chrROMSizeUnit*2))
+	for _, address := range []uint16{0x8123, 0xC123} {
+		if got, _ := cartridge.ReadFromCPU(address); got != 0x42 {
+			t.Errorf("0x%04x: got=0x%02x, want=0x42", address, got)
+		}
+	}
+	if err := cartridge.WriteFromPPU(0x0000, 0x01); err == nil {
+		t.Error("Writing CHR ROM returned no error")
+	}
+}
@@ -0,0 +1,232 @@
+package nes
+
+import "fmt"
+
+// Mapper4: https://www.nesdev.org/wiki/MMC3
+// MMC3 has 8KB PRG banks, 1KB/2KB CHR banks and a scanline counter clocked by PPU A12 rising edges.
+type mapper4 struct {
+	bankLog
+	prgROM []byte
+	chrROM []byte
+	prgRAM []byte
+	// chrRAM is true if the board has CHR RAM, which is writable.
+	chrRAM bool
+	// fourScreen is true if the board has the extra VRAM, the mirroring register is ignored.
+	fourScreen bool
+
+	// Bank select ($8000-$9FFE, even), CPxxxRRR
+	// C: CHR A12 inversion (0: two 2KB banks at $0000, 1: two 2KB banks at $1000)
+	// P: PRG ROM bank mode (0: $8000 swappable and $C000 fixed to the second-last bank, 1: swapped)
+	// R: the bank register to update on the next write to $8001
+	bankSelect byte
+	// R0-R7, R0 and R1 select 2KB CHR banks, R2-R5 select 1KB CHR banks, R6 and R7 select 8KB PRG banks.
+	registers [8]byte
+	// Mirroring ($A000-$BFFE, even), 0: vertical, 1: horizontal
+	horizontal bool
+	// PRG RAM protect ($A001-$BFFF, odd), RAM is readable and writable after power-on.
+	prgRAMEnabled  bool
+	prgRAMWritable bool
+
+	irqLatch   byte
+	irqCounter byte
+	// irqReload is set by $C001, the counter is reloaded from the latch on the next clock.
+	irqReload  bool
+	irqEnabled bool
+	irqPending bool
+	// a12 is the last A12 seen on the PPU address bus.
+	a12 bool
+}
+
+func newMapper4(prgROM []byte, chrROM []byte, b board) *mapper4 {
+	return &mapper4{
+		prgROM:         prgROM,
+		chrROM:         chrROM,
+		prgRAM:         b.prgRAM,
+		chrRAM:         b.chrRAM,
+		fourScreen:     b.flags6&8 == 8,
+		prgRAMEnabled:  true,
+		prgRAMWritable: true,
+	}
+}
+
+func (m *mapper4) controlsPRGRAM() {}
+
+func (m *mapper4) Name() string {
+	return "MMC3"
+}
+
+func (m *mapper4) IRQPending() bool {
+	return m.irqPending
+}
+
+func (m *mapper4) ReadFromCPU(address uint16) (byte, error) {
+	switch {
+	case 0x8000 <= address:
+		return m.prgROM[m.prgAddress(address)], nil
+	case 0x6000 <= address:
+		if !m.prgRAMEnabled {
+			// Open bus, returns 0 here.
+			return 0, nil
+		}
+		return m.prgRAM[address-0x6000], nil
+	}
+	return 0, fmt.Errorf("Reading cartridge address 0x%04x is not allowed", address)
+}
+
+// prgAddress converts a CPU address ($8000-$FFFF) to an address of the PRG ROM.
+func (m *mapper4) prgAddress(address uint16) int {
+	banks := len(m.prgROM) / 0x2000
+	slot := (address - 0x8000) / 0x2000
+	if m.bankSelect&0x40 == 0x40 && slot%2 == 0 {
+		// PRG ROM bank mode 1 swaps $8000 and $C000.
+		slot ^= 2
+	}
+	var bank int
+	switch slot {
+	case 0:
+		bank = int(m.registers[6])
+	case 1:
+		bank = int(m.registers[7])
+	case 2:
+		bank = banks - 2
+	default:
+		bank = banks - 1
+	}
+	return (bank%banks)*0x2000 + int(address%0x2000)
+}
+
+func (m *mapper4) WriteFromCPU(address uint16, data byte) error {
+	switch {
+	case 0x8000 <= address:
+		m.writeRegister(address, data)
+		return nil
+	case 0x6000 <= address:
+		if m.prgRAMEnabled && m.prgRAMWritable {
+			m.prgRAM[address-0x6000] = data
+		}
+		return nil
+	}
+	return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
+}
+
+// mapper4RegisterNames is the names of R0-R7 for the bank switch log, fixed so that $8001 writes don't allocate.
+var mapper4RegisterNames = [8]string{
+	"CHR bank R0", "CHR bank R1", "CHR bank R2", "CHR bank R3", "CHR bank R4", "CHR bank R5", "PRG bank R6", "PRG bank R7",
+}
+
+// writeRegister writes a register selected by the address range and whether the address is even or odd.
+func (m *mapper4) writeRegister(address uint16, data byte) {
+	even := address%2 == 0
+	switch address & 0xE000 {
+	case 0x8000:
+		if even {
+			m.bankSelect = data
+			return
+		}
+		r := m.bankSelect & 7
+		m.logBankSwitch(mapper4RegisterNames[r], int(m.registers[r]), int(data), address, data)
+		m.registers[r] = data
+	case 0xA000:
+		if even {
+			horizontal := data&1 == 1
+			m.logBankSwitch("Mirroring", boolToInt(m.horizontal), boolToInt(horizontal), address, data)
+			m.horizontal = horizontal
+		} else {
+			m.prgRAMEnabled = data&0x80 == 0x80
+			m.prgRAMWritable = data&0x40 == 0
+		}
+	case 0xC000:
+		if even {
+			m.irqLatch = data
+		} else {
+			m.irqCounter = 0
+			m.irqReload = true
+		}
+	case 0xE000:
+		if even {
+			// Disabling also acknowledges the pending IRQ.
+			m.irqEnabled = false
+			m.irqPending = false
+		} else {
+			m.irqEnabled = true
+		}
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// chrAddress converts a PPU address ($0000-$1FFF) to an address of the CHR ROM.
+func (m *mapper4) chrAddress(address uint16) int {
+	if m.bankSelect&0x80 == 0x80 {
+		// CHR A12 inversion swaps $0000-$0FFF and $1000-$1FFF.
+		address ^= 0x1000
+	}
+	var bank int
+	if address < 0x1000 {
+		// R0 and R1 select 2KB banks, the low bit is ignored.
+		bank = int(m.registers[address/0x800]&^1) + int(address/0x400%2)
+	} else {
+		bank = int(m.registers[2+(address-0x1000)/0x400])
+	}
+	return (bank*0x400 + int(address%0x400)) % len(m.chrROM)
+}
+
+func (m *mapper4) ReadFromPPU(address uint16) (byte, error) {
+	return m.chrROM[m.chrAddress(address)], nil
+}
+
+func (m *mapper4) WriteFromPPU(address uint16, data byte) error {
+	if !m.chrRAM {
+		return fmt.Errorf("Writing data to CHR ROM not allowed, address=0x%04x, data=0x%02x", address, data)
+	}
+	m.chrROM[m.chrAddress(address)] = data
+	return nil
+}
+
+// watchPPUAddress clocks the scanline counter on rising edges of PPU A12.
+// The PPU fetches the background and the sprite patterns from different tables, so this happens once per scanline.
+// https://www.nesdev.org/wiki/MMC3#IRQ_Specifics
+func (m *mapper4) watchPPUAddress(address uint16) {
+	a12 := address&0x1000 == 0x1000
+	if a12 && !m.a12 {
+		m.clockIRQCounter()
+	}
+	m.a12 = a12
+}
+
+func (m *mapper4) clockIRQCounter() {
+	if m.irqCounter == 0 || m.irqReload {
+		m.irqCounter = m.irqLatch
+		m.irqReload = false
+	} else {
+		m.irqCounter--
+	}
+	if m.irqCounter == 0 && m.irqEnabled {
+		m.irqPending = true
+	}
+}
+
+func (m *mapper4) mirror() (MirrorMode, bool) {
+	if m.fourScreen {
+		return 0, false
+	}
+	if m.horizontal {
+		return horizontal, true
+	}
+	return vertical, true
+}
+
+func (m *mapper4) saveState(w *stateWriter) {
+	w.write(m.bankSelect, m.registers, m.horizontal, m.prgRAMEnabled, m.prgRAMWritable,
+		m.irqLatch, m.irqCounter, m.irqReload, m.irqEnabled, m.irqPending, m.a12)
+}
+
+func (m *mapper4) loadState(r *stateReader) {
+	r.read(&m.bankSelect, &m.registers, &m.horizontal, &m.prgRAMEnabled, &m.prgRAMWritable,
+		&m.irqLatch, &m.irqCounter, &m.irqReload, &m.irqEnabled, &m.irqPending, &m.a12)
+}
@@ -0,0 +1,194 @@
+package nes
+
+import "testing"
+
+// newTestMMC3 creates MMC3 with 8 PRG 8KB banks and 16 CHR 1KB banks, each filled with its bank number.
+func newTestMMC3() *mapper4 {
+	prgROM := make([]byte, 0x2000*8)
+	for i := range prgROM {
+		prgROM[i] = byte(i / 0x2000)
+	}
+	chrROM := make([]byte, 0x400*16)
+	for i := range chrROM {
+		chrROM[i] = byte(i / 0x400)
+	}
+	return newMapper4(prgROM, chrROM, board{prgRAM: make([]byte, prgRAMSize)})
+}
+
+func writeMMC3(t *testing.T, m *mapper4, writes [][2]uint16) {
+	t.Helper()
+	for _, w := range writes {
+		if err := m.WriteFromCPU(w[0], byte(w[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMapper4PRGBanks(t *testing.T) {
+	tests := []struct {
+		name       string
+		bankSelect uint16
+		want       [4]byte // banks at $8000, $A000, $C000 and $E000
+	}{
+		{"mode 0", 0x00, [4]byte{3, 5, 6, 7}},
+		{"mode 1", 0x40, [4]byte{6, 5, 3, 7}},
+	}
+	for _, tt := range tests {
+		m := newTestMMC3()
+		writeMMC3(t, m, [][2]uint16{{0x8000, 6}, {0x8001, 3}, {0x8000, 7}, {0x8001, 13}, {0x8000, tt.bankSelect}})
+		for i, want := range tt.want {
+			address := 0x8000 + uint16(i)*0x2000
+			if got, _ := m.ReadFromCPU(address); got != want {
+				t.Errorf("%s: bank at 0x%04x: got=%d, want=%d", tt.name, address, got, want)
+			}
+		}
+	}
+}
+
+func TestMapper4CHRBanks(t *testing.T) {
+	tests := []struct {
+		name       string
+		bankSelect uint16
+		want       [8]byte // banks at $0000, $0400, ..., $1C00
+	}{
+		{"no inversion", 0x00, [8]byte{4, 5, 10, 11, 12, 13, 14, 15}},
+		{"inversion", 0x80, [8]byte{12, 13, 14, 15, 4, 5, 10, 11}},
+	}
+	for _, tt := range tests {
+		m := newTestMMC3()
+		// R0 = 5 selects 4 and 5 since the low bit is ignored.
+		writes := [][2]uint16{{0x8000, 0}, {0x8001, 5}, {0x8000, 1}, {0x8001, 10}}
+		for r := uint16(2); r < 6; r++ {
+			writes = append(writes, [2]uint16{0x8000, r}, [2]uint16{0x8001, 10 + r})
+		}
+		writes = append(writes, [2]uint16{0x8000, tt.bankSelect})
+		writeMMC3(t, m, writes)
+		for i, want := range tt.want {
+			address := uint16(i) * 0x400
+			if got, _ := m.ReadFromPPU(address); got != want {
+				t.Errorf("%s: bank at 0x%04x: got=%d, want=%d", tt.name, address, got, want)
+			}
+		}
+	}
+}
+
+func TestMapper4Mirroring(t *testing.T) {
+	cartridge := newTestCartridge(4, make([]byte, prgROMSizeUnit*2), make([]byte, chrROMSizeUnit))
+	for _, tt := range []struct {
+		data byte
+		want MirrorMode
+	}{
+		{0, vertical},
+		{1, horizontal},
+	} {
+		if err := cartridge.WriteFromCPU(0xA000, tt.data); err != nil {
+			t.Fatal(err)
+		}
+		if got := cartridge.Mirror(); got != tt.want {
+			t.Errorf("Mirror with $A000 = %d: got=%v, want=%v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMapper4PRGRAMProtect(t *testing.T) {
+	m := newTestMMC3()
+	writeMMC3(t, m, [][2]uint16{{0x6000, 0x11}, {0xA001, 0xC0}, {0x6000, 0x22}})
+	if got, _ := m.ReadFromCPU(0x6000); got != 0x11 {
+		t.Errorf("Write protected PRG RAM: got=0x%02x, want=0x11", got)
+	}
+	writeMMC3(t, m, [][2]uint16{{0xA001, 0x00}})
+	if got, _ := m.ReadFromCPU(0x6000); got != 0 {
+		t.Errorf("Disabled PRG RAM: got=0x%02x, want=0x00", got)
+	}
+}
+
+func TestMapper4IRQCounter(t *testing.T) {
+	m := newTestMMC3()
+	// clock makes a rising edge of A12.
+	clock := func() {
+		m.watchPPUAddress(0x0000)
+		m.watchPPUAddress(0x1000)
+	}
+	writeMMC3(t, m, [][2]uint16{{0xC000, 2}, {0xC001, 0}, {0xE001, 0}})
+	// The first clock reloads the latch, 2 -> 1 -> 0.
+	for i, want := range []bool{false, false, true} {
+		clock()
+		if got := m.IRQPending(); got != want {
+			t.Errorf("IRQ after clock %d: got=%t, want=%t", i+1, got, want)
+		}
+	}
+	// Staying high doesn't clock the counter.
+	m.watchPPUAddress(0x1000)
+	if m.irqCounter != 0 {
+		t.Errorf("Counter without a rising edge: got=%d, want=0", m.irqCounter)
+	}
+	// Disabling acknowledges the IRQ, the counter keeps reloading and counting.
+	writeMMC3(t, m, [][2]uint16{{0xE000, 0}})
+	if m.IRQPending() {
+		t.Error("IRQ is still pending after $E000")
+	}
+	clock()
+	clock()
+	if m.IRQPending() {
+		t.Error("IRQ is pending while disabled")
+	}
+	// A new latch is used on the next reload, the counter is 1 here so this counts down to 0.
+	writeMMC3(t, m, [][2]uint16{{0xC000, 5}, {0xE001, 0}})
+	clock()
+	if got, want := m.irqCounter, byte(0); got != want || !m.IRQPending() {
+		t.Errorf("Counter without reload: got=%d (IRQ=%t), want=%d (IRQ=true)", got, m.IRQPending(), want)
+	}
+	// $C001 forces the reload.
+	writeMMC3(t, m, [][2]uint16{{0xC001, 0}})
+	clock()
+	if got, want := m.irqCounter, byte(5); got != want {
+		t.Errorf("Counter after $C001: got=%d, want=%d", got, want)
+	}
+	// Latch 0 fires the IRQ on every clock.
+	writeMMC3(t, m, [][2]uint16{{0xC000, 0}, {0xC001, 0}})
+	clock()
+	if !m.IRQPending() {
+		t.Error("IRQ with latch 0: got=false, want=true")
+	}
+}
+
+func TestMapper4ScanlineIRQ(t *testing.T) {
+	tests := []struct {
+		name string
+		ctrl byte
+	}{
+		// Background at $0000 and sprites at $1000 make a rising edge once per scanline.
+		{"8x8 sprites at $1000", 0x08},
+		// 8x16 sprites ignore the sprite table bit, the unused slots fetch tile $FF from $1000.
+		{"8x16 sprites", 0x20},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(4, make([]byte, prgROMSizeUnit*2), make([]byte, chrROMSizeUnit))
+		p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+		p.writePPUCTRL(tt.ctrl)
+		p.writePPUMASK(0x18)
+		if err := cartridge.WriteFromCPU(0xC000, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := cartridge.WriteFromCPU(0xC001, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := cartridge.WriteFromCPU(0xE001, 0); err != nil {
+			t.Fatal(err)
+		}
+		p.scanline = p.preRenderLine
+		p.cycle = 0
+		for !cartridge.Mapper.IRQPending() {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if p.scanline == 100 {
+				t.Fatalf("%s: IRQ didn't happen", tt.name)
+			}
+		}
+		// The pre-render line reloads 10, scanlines 0-9 count it down.
+		if p.scanline != 9 || p.cycle != 261 {
+			t.Errorf("%s: IRQ at: scanline=%d, cycle=%d, want scanline=9, cycle=261", tt.name, p.scanline, p.cycle)
+		}
+	}
+}
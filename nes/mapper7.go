@@ -0,0 +1,76 @@
+package nes
+
+import "fmt"
+
+// Mapper7: https://www.nesdev.org/wiki/AxROM
+// AxROM switches a 32KB PRG ROM bank and the one-screen mirroring page, CHR is usually 8KB RAM.
+type mapper7 struct {
+	bankLog
+	noIRQ
+	banks       int
+	currentBank int
+	prgROM      []byte
+	chrROM      []byte
+	// chrRAM is true if the board has CHR RAM, which is writable.
+	chrRAM bool
+	// screen selects the one-screen mirroring page, the PPU queries this on every name table access,
+	// so switching it mid-frame affects the following scanlines.
+	screen int
+}
+
+func newMapper7(prgROM []byte, chrROM []byte, b board) *mapper7 {
+	return &mapper7{banks: len(prgROM) / 0x8000, prgROM: prgROM, chrROM: chrROM, chrRAM: b.chrRAM}
+}
+
+func (m *mapper7) Name() string {
+	return "AxROM"
+}
+
+func (m *mapper7) ReadFromCPU(address uint16) (byte, error) {
+	if address < 0x8000 {
+		return 0, fmt.Errorf("Reading cartridge address 0x%04x is not allowed", address)
+	}
+	return m.prgROM[m.currentBank*0x8000+int(address-0x8000)], nil
+}
+
+func (m *mapper7) WriteFromCPU(address uint16, data byte) error {
+	if address < 0x8000 {
+		return fmt.Errorf("Writing cartridge address 0x%04x = 0x%02x is not allowed", address, data)
+	}
+	// xxxM xPPP
+	// M: one-screen mirroring page, P: 32KB PRG ROM bank
+	bank, screen := int(data&7)%m.banks, int(data>>4)&1
+	m.logBankSwitch("PRG bank", m.currentBank, bank, address, data)
+	m.logBankSwitch("One-screen page", m.screen, screen, address, data)
+	m.currentBank, m.screen = bank, screen
+	return nil
+}
+
+func (m *mapper7) ReadFromPPU(address uint16) (byte, error) {
+	return m.chrROM[address], nil
+}
+
+func (m *mapper7) WriteFromPPU(address uint16, data byte) error {
+	if !m.chrRAM {
+		return fmt.Errorf("Writing data to CHR ROM not allowed, address=0x%04x, data=0x%02x", address, data)
+	}
+	m.chrROM[address] = data
+	return nil
+}
+
+func (m *mapper7) mirror() (MirrorMode, bool) {
+	if m.screen == 0 {
+		return singleScreenLow, true
+	}
+	return singleScreenHigh, true
+}
+
+func (m *mapper7) saveState(w *stateWriter) {
+	w.write(m.currentBank, m.screen)
+}
+
+func (m *mapper7) loadState(r *stateReader) {
+	r.read(&m.currentBank, &m.screen)
+	r.checkRange("PRG bank", m.currentBank, m.banks)
+	r.checkRange("One-screen page", m.screen, 2)
+}
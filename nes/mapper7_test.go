@@ -0,0 +1,70 @@
+package nes
+
+import "testing"
+
+func TestMapper7PRGBanks(t *testing.T) {
+	prgROM := make([]byte, 0x8000*4)
+	for bank := 0; bank < 4; bank++ {
+		prgROM[bank*0x8000] = byte(bank)
+		prgROM[bank*0x8000+0x7FFF] = byte(bank)
+	}
+	cartridge := newTestCartridge(7, prgROM, nil)
+	for _, tt := range []struct {
+		data byte
+		want byte
+	}{
+		{0x02, 2},
+		{0x13, 3}, // bit 4 is the mirroring page.
+		{0x05, 1}, // 5 % 4 = 1
+	} {
+		if err := cartridge.WriteFromCPU(0x8000, tt.data); err != nil {
+			t.Fatal(err)
+		}
+		for _, address := range []uint16{0x8000, 0xFFFF} {
+			if got, _ := cartridge.ReadFromCPU(address); got != tt.want {
+				t.Errorf("0x%04x after writing 0x%02x: got=%d, want=%d", address, tt.data, got, tt.want)
+			}
+		}
+	}
+	// AxROM boards have CHR RAM.
+	if err := cartridge.WriteFromPPU(0x0010, 0xAB); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapper7MirroringSwitch(t *testing.T) {
+	cartridge := newTestCartridge(7, make([]byte, 0x8000), nil)
+	b := NewPPUBus(NewRAM(), cartridge)
+	// All name tables are the same page, the page switches immediately like mid-frame writes.
+	steps := []struct {
+		page    byte
+		address uint16
+		write   bool
+		data    byte
+	}{
+		{0x00, 0x2000, true, 0x11},
+		{0x00, 0x2C00, false, 0x11},
+		{0x10, 0x2400, false, 0x00},
+		{0x10, 0x2800, true, 0x22},
+		{0x00, 0x2400, false, 0x11},
+		{0x10, 0x2000, false, 0x22},
+	}
+	for i, s := range steps {
+		if err := cartridge.WriteFromCPU(0x8000, s.page); err != nil {
+			t.Fatal(err)
+		}
+		if s.write {
+			if err := b.write(s.address, s.data); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		got, err := b.read(s.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != s.data {
+			t.Errorf("Step %d: 0x%04x with page 0x%02x: got=0x%02x, want=0x%02x", i, s.address, s.page, got, s.data)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package nes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMapperName(t *testing.T) {
+	tests := []struct {
+		number byte
+		want   string
+	}{
+		{0, "NROM"},
+		{1, "MMC1"},
+		{2, "UxROM"},
+		{3, "CNROM"},
+		{4, "MMC3"},
+		{7, "AxROM"},
+		{30, "UNROM 512"},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(tt.number, make([]byte, prgROMSizeUnit*2), make([]byte, chrROMSizeUnit))
+		console, err := NewConsole(cartridge, false /* debug */)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := console.Mapper().Name(); got != tt.want {
+			t.Errorf("Mapper%d name: got=%s, want=%s", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestNROMSizeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		prgROM int
+		chrROM int
+		want   string
+	}{
+		{"oversized PRG ROM", prgROMSizeUnit * 4, chrROMSizeUnit, "NROM supports 16KB or 32KB PRG ROM, got=64KB"},
+		{"oversized CHR ROM", prgROMSizeUnit * 2, chrROMSizeUnit * 2, "NROM supports 8KB CHR, got=16KB"},
+	}
+	for _, tt := range tests {
+		header := []byte{'N', 'E', 'S', msDOSEOF, byte(tt.prgROM / prgROMSizeUnit), byte(tt.chrROM / chrROMSizeUnit), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		data := append(append(header, make([]byte, tt.prgROM)...), make([]byte, tt.chrROM)...)
+		_, err := NewCartridge(data)
+		if err == nil {
+			t.Fatalf("%s: NewCartridge returned no error", tt.name)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got=%q, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMapperPRGROMSizeError(t *testing.T) {
+	tests := []struct {
+		number uint16
+		prgROM int
+		want   string
+	}{
+		{1, 0, "MMC1 requires 16KB PRG ROM banks, got=0KB"},
+		{1, 0x2000, "MMC1 requires 16KB PRG ROM banks, got=8KB"},
+		{1, prgROMSizeUnit + 0x2000, "MMC1 requires 16KB PRG ROM banks, got=24KB"},
+		{2, 0, "UxROM requires 16KB PRG ROM banks, got=0KB"},
+		{2, 0x2000, "UxROM requires 16KB PRG ROM banks, got=8KB"},
+		{4, 0x2000, "MMC3 requires at least 16KB PRG ROM in 8KB banks, got=8KB"},
+		{4, 0x5000, "MMC3 requires at least 16KB PRG ROM in 8KB banks, got=20KB"},
+		{30, 0, "UNROM 512 requires 16KB PRG ROM banks, got=0KB"},
+		{30, 0x2000, "UNROM 512 requires 16KB PRG ROM banks, got=8KB"},
+	}
+	for _, tt := range tests {
+		_, err := NewMapper(tt.number, make([]byte, tt.prgROM), make([]byte, chrROMSizeUnit), board{})
+		if err == nil {
+			t.Errorf("Mapper%d with %d bytes PRG ROM: NewMapper returned no error", tt.number, tt.prgROM)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Mapper%d: got=%q, want an error containing %q", tt.number, err, tt.want)
+		}
+	}
+}
+
+func TestUnsupportedMapper(t *testing.T) {
+	header := []byte{'N', 'E', 'S', msDOSEOF, 2, 1, 0x50, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(append(header, make([]byte, prgROMSizeUnit*2)...), make([]byte, chrROMSizeUnit)...)
+	_, err := NewCartridge(data)
+	if err == nil {
+		t.Fatal("NewCartridge returned no error for mapper 5")
+	}
+	if want := "Mapper5 is not implemented"; !strings.Contains(err.Error(), want) {
+		t.Errorf("got=%q, want an error containing %q", err, want)
+	}
+}
+
+func TestSupportedMappers(t *testing.T) {
+	supported := map[uint16]bool{}
+	for _, n := range SupportedMappers() {
+		supported[n] = true
+	}
+	for _, n := range []uint16{0, 1, 2, 3, 4, 7, 30} {
+		if !supported[n] {
+			t.Errorf("Mapper%d is not in SupportedMappers", n)
+		}
+	}
+	// The list matches what NewMapper can create.
+	for n := uint16(0); n < 0x1000; n++ {
+		_, err := NewMapper(n, make([]byte, prgROMSizeUnit*2), make([]byte, chrROMSizeUnit), board{})
+		if got := err == nil; got != supported[n] {
+			t.Errorf("NewMapper(%d) succeeded=%t, but SupportedMappers contains it=%t", n, got, supported[n])
+		}
+	}
+	// The returned slice is a copy.
+	SupportedMappers()[0] = 0xFFF
+	if SupportedMappers()[0] != 0 {
+		t.Error("Modifying the returned slice changed SupportedMappers")
+	}
+}
+
+func TestCartridgeDelegatesToMapper(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit*4)
+	for bank := 0; bank < 4; bank++ {
+		prgROM[bank*prgROMSizeUnit] = byte(bank)
+	}
+	cartridge := newTestCartridge(2, prgROM, nil)
+	if _, ok := cartridge.Mapper.(*mapper2); !ok {
+		t.Fatalf("Mapper: got=%T, want=*mapper2", cartridge.Mapper)
+	}
+	if err := cartridge.WriteFromCPU(0x8000, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromCPU(0x8000); got != 2 {
+		t.Errorf("$8000 after switching to bank 2: got=%d, want=2", got)
+	}
+	if err := cartridge.WriteFromPPU(0x0010, 0xAB); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cartridge.ReadFromPPU(0x0010); got != 0xAB {
+		t.Errorf("CHR RAM: got=0x%02x, want=0xab", got)
+	}
+}
+
+func TestMapperLoadStateOutOfRange(t *testing.T) {
+	prgROM := make([]byte, prgROMSizeUnit*2)
+	chrROM := make([]byte, chrROMSizeUnit*2)
+	tests := []struct {
+		name    string
+		corrupt stateSaver
+		mapper  stateSaver
+	}{
+		{"UxROM PRG bank", &mapper2{banks: 2, currentBank: 2, chrROM: make([]byte, 0x4000)}, NewMapper2(prgROM)},
+		{"CNROM CHR bank", &mapper3{banks: 2, chrBank: 5}, newMapper3(prgROM, chrROM)},
+		{"AxROM PRG bank", &mapper7{banks: 1, currentBank: 1}, newMapper7(make([]byte, 0x8000), chrROM, board{})},
+		{"AxROM page", &mapper7{banks: 1, screen: 2}, newMapper7(make([]byte, 0x8000), chrROM, board{})},
+		{"UNROM 512 PRG bank", &mapper30{banks: 2, currentBank: -1, chrRAM: make([]byte, chrROMSizeUnit*4)}, newMapper30(prgROM, board{})},
+		{"UNROM 512 CHR bank", &mapper30{banks: 2, chrBank: 4, chrRAM: make([]byte, chrROMSizeUnit*4)}, newMapper30(prgROM, board{})},
+		{"UNROM 512 flash state", &mapper30{banks: 2, flashState: 7, chrRAM: make([]byte, chrROMSizeUnit*4)}, newMapper30(prgROM, board{})},
+	}
+	for _, tt := range tests {
+		w := &stateWriter{}
+		tt.corrupt.saveState(w)
+		data, err := w.bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := newStateReader(data)
+		tt.mapper.loadState(r)
+		if err := r.error(); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%s: got=%v, want an out of range error", tt.name, err)
+		}
+	}
+}
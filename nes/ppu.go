@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"strings"
 )
 
 // NES PPU generates 256x240 pixels.
@@ -116,7 +117,7 @@ func (r *paletteRAM) write(address uint16, data byte) {
 
 // PPU stands for Picture Processing Unit, renders 256px x 240px image for a screen.
 // PPU is 3x faster than CPU and rendering 1 frame requires 341x262=89342 cycles (Each cycles writes a dot).
-// This implementation emulates NTSC not PAL or other ways.
+// This implementation emulates NTSC, PAL only has the longer vblank (341x312 cycles).
 //
 // This PPU implementation includes PPU regsters as well.
 // References:
@@ -125,7 +126,9 @@ func (r *paletteRAM) write(address uint16, data byte) {
 type PPU struct {
 	bus *PPUBus
 
+	// picture is the back buffer which is being rendered, front keeps the last completed frame.
 	picture *image.RGBA
+	front   *image.RGBA
 
 	// Registers and temp data for PPU.
 	// Reference:
@@ -172,16 +175,19 @@ type PPU struct {
 	masterSlaveSelectFlag byte // 0: read backdrop from EXT pins; 1: output color on EXT pins
 
 	// $2001
-	grayScale          bool // unused.
+	grayScale          bool
 	showLeftBackground bool
 	showLeftSprite     bool
 	showBackground     bool
 	showSprite         bool
-	emphasizeRed       bool // I have no idea about these, probably for PAL not NTSC.
-	emphasizeGreen     bool // Same above.
-	emphasizeBlue      bool // Same above.
-
-	// $2002
+	emphasizeRed       bool // Emphasis darkens the other color channels.
+	emphasizeGreen     bool
+	emphasizeBlue      bool
+
+	// register is the open bus latch between the CPU and the PPU, it keeps the last value written to any PPU register
+	// or read from $2004/$2007, reads of write-only registers and the low 5 bits of $2002 return it.
+	// TODO(jyane): The latch decays to 0 after about 600ms without being refreshed.
+	// https://www.nesdev.org/wiki/Open_bus_behavior#PPU_open_bus
 	register byte
 
 	// PPU has an internal RAM for palette data.
@@ -192,19 +198,42 @@ type PPU struct {
 	attributeTableByte byte
 	lowTileByte        byte
 	highTileByte       byte
-	// PPU fetches data for rendering before 2 "fetch cycles".
-	tileDataBuffer [6]byte
+	// Background shift registers, the high 8 bits are the current tile and the low 8 bits are the next tile.
+	// They shift every pixel and fine X selects a bit, the attribute is expanded to 8 bits to shift in parallel.
+	// https://www.nesdev.org/wiki/PPU_rendering#Preface
+	patternShiftLow    uint16
+	patternShiftHigh   uint16
+	attributeShiftLow  uint16
+	attributeShiftHigh uint16
 
 	// cycle, scanline indicates which pixel is processing.
 	cycle    int
 	scanline int
+	// preRenderLine is the last scanline of a frame, 261 for NTSC and 311 for PAL.
+	// https://www.nesdev.org/wiki/Cycle_reference_chart
+	preRenderLine int
+
+	// scanlineCallback is called when each visible scanline has been rendered if set.
+	scanlineCallback func(scanline int)
+
+	// Layers hidden from the output image for debugging, see SetLayerMask.
+	hideBackground bool
+	hideSprites    bool
 }
 
 // NewPPU creates a PPU.
 func NewPPU(bus *PPUBus) *PPU {
 	p := &PPU{
-		bus:     bus,
-		picture: image.NewRGBA(image.Rect(0, 0, width, height)),
+		bus:           bus,
+		picture:       image.NewRGBA(image.Rect(0, 0, width, height)),
+		front:         image.NewRGBA(image.Rect(0, 0, width, height)),
+		preRenderLine: 261,
+	}
+	// OAM has unspecified data on power-on, here fills it with $FF (all sprites are below the screen)
+	// instead of zeros which put 64 sprites at the top-left corner.
+	// https://www.nesdev.org/wiki/PPU_power_up_state
+	for i := range p.primaryOAM {
+		p.primaryOAM[i] = 0xFF
 	}
 	return p
 }
@@ -214,11 +243,22 @@ func (p *PPU) Reset() {
 	// Here just starts from vblank.
 	p.cycle = 0
 	p.scanline = 240
+	// PPUCTRL, PPUMASK, PPUSCROLL, the write latch and the read buffer are cleared on reset, so NMI is not generated
+	// until the game enables it. OAM, palette and v (PPUADDR) are kept.
+	// https://www.nesdev.org/wiki/PPU_power_up_state
+	p.writePPUCTRL(0)
+	p.writePPUMASK(0)
+	p.updateNMI(false)
+	p.t = 0
+	p.x = 0
+	p.w = false
+	p.buffer = 0
 }
 
+// Frame returns the completed frame when the PPU has just finished rendering the visible scanlines.
 func (p *PPU) Frame() (bool, *image.RGBA) {
 	if p.cycle == 257 && p.scanline == 239 {
-		return true, p.picture
+		return true, p.front
 	} else {
 		return false, nil
 	}
@@ -265,7 +305,11 @@ func (p *PPU) readPPUSTATUS() byte {
 		res |= 1 << 7
 	}
 	p.updateNMI(false)
+	// w:                  <- 0
+	// Games read PPUSTATUS to resynchronize the latch, so the next $2005/$2006 write is the first write.
 	p.w = false
+	// Only the status bits refresh the open bus.
+	p.register = p.register&0x1F | res&0xE0
 	return res
 }
 
@@ -281,6 +325,13 @@ func (p *PPU) readOAMDATA() byte {
 
 // writeOAMDATA writes OAMDATA ($2004).
 func (p *PPU) writeOAMDATA(data byte) {
+	// "Writes to OAMDATA during rendering do not modify values in OAM,
+	// but do perform a glitchy increment of OAMADDR, bumping only the high 6 bits"
+	// https://www.nesdev.org/wiki/PPU_registers#OAMDATA
+	if p.renderingEnabled() && (p.scanline < 240 || p.scanline == p.preRenderLine) {
+		p.oamAddress += 4
+		return
+	}
 	p.primaryOAM[p.oamAddress] = data
 	p.oamAddress++
 }
@@ -315,7 +366,7 @@ func (p *PPU) writePPUADDR(data byte) {
 		//    <unused>     <- d: AB......
 		// t: Z...... ........ <- 0 (bit Z is cleared)
 		// w:                  <- 1
-		p.t = (p.t & 0x80FF) | (uint16(data) << 8)
+		p.t = (p.t & 0x00FF) | (uint16(data&0x3F) << 8)
 		p.w = true
 	} else {
 		// t: ....... ABCDEFGH <- d: ABCDEFGH
@@ -329,46 +380,86 @@ func (p *PPU) writePPUADDR(data byte) {
 
 // writePPUDATA writes PPUDATA ($2007).
 func (p *PPU) writePPUDATA(data byte) error {
+	// PPU address bus is 14 bits.
+	address := p.v & 0x3FFF
 	// writing to paletteRAM
-	if 0x3F00 <= p.v {
-		if 0x3FFF < p.v {
-			return fmt.Errorf("Writing PPU address=0x%04x is not allowd, data=0x%02x\n", p.v, data)
-		}
-		p.paletteRAM.write(p.v, data)
+	if 0x3F00 <= address {
+		p.paletteRAM.write(address, data)
 	} else {
-		if err := p.bus.write(p.v, data); err != nil {
+		if err := p.bus.write(address, data); err != nil {
 			return fmt.Errorf("Failed to write PPUDATA: %w", err)
 		}
 	}
-	if p.vramIncrementFlag == 0 {
-		p.v++
-	} else {
-		p.v += 32
-	}
+	p.incrementAddress()
 	return nil
 }
 
 // readPPUDATA reads PPUDATA ($2007).
 func (p *PPU) readPPUDATA() (byte, error) {
-	data, err := p.bus.read(p.v)
-	if err != nil {
-		return 0, fmt.Errorf("Failed to read PPUDATA: %w", err)
-	}
+	// PPU address bus is 14 bits.
+	address := p.v & 0x3FFF
+	var data byte
 	// Here buffers data if the address is not paletteRAM, because paletteRAM access is faster than bus access.
-	if p.v < 0x3F00 {
-		buffered := p.buffer
-		p.buffer = data
-		data = buffered
+	if address < 0x3F00 {
+		d, err := p.bus.read(address)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to read PPUDATA: %w", err)
+		}
+		data = p.buffer
+		p.buffer = d
 	} else {
-		buf := p.paletteRAM.read(p.v)
-		p.buffer = buf
+		// Palette data is returned immediately, and the buffer gets the name table data "underneath" the palette.
+		d, err := p.bus.read(address - 0x1000)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to read PPUDATA: %w", err)
+		}
+		data = p.paletteRAM.read(address)
+		p.buffer = d
 	}
-	if p.vramIncrementFlag == 0 {
+	p.incrementAddress()
+	return data, nil
+}
+
+// registersString returns decoded PPUCTRL, PPUMASK and PPUSTATUS for debugging.
+func (p *PPU) registersString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PPUCTRL:   nametable=$%04x, increment=%d, sprite table=$%04x, background table=$%04x, sprite size=8x%d, master/slave=%d, NMI=%t\n",
+		0x2000+0x400*uint16(p.nameTableFlag), []int{1, 32}[p.vramIncrementFlag],
+		0x1000*uint16(p.spriteTableFlag), 0x1000*uint16(p.backgroundTableFlag),
+		8<<p.spriteSizeFlag, p.masterSlaveSelectFlag, p.nmiOutput)
+	fmt.Fprintf(&b, "PPUMASK:   grayscale=%t, left background=%t, left sprite=%t, background=%t, sprite=%t, emphasis(RGB)=%t,%t,%t\n",
+		p.grayScale, p.showLeftBackground, p.showLeftSprite, p.showBackground, p.showSprite,
+		p.emphasizeRed, p.emphasizeGreen, p.emphasizeBlue)
+	fmt.Fprintf(&b, "PPUSTATUS: sprite overflow=%t, sprite 0 hit=%t, vblank=%t\n",
+		p.spriteOverflow, p.spriteZeroHit, p.nmiOccurred)
+	fmt.Fprintf(&b, "Internal:  v=0x%04x, t=0x%04x, x=%d, w=%t, OAMADDR=0x%02x\n", p.v, p.t, p.x, p.w, p.oamAddress)
+	return b.String()
+}
+
+// incrementAddress increments v after accessing PPUDATA ($2007).
+// During rendering, it increments coarse X and Y at the same time instead of adding 1 or 32.
+// https://www.nesdev.org/wiki/PPU_scrolling#$2007_reads_and_writes
+func (p *PPU) incrementAddress() {
+	if p.renderingEnabled() && (p.scanline < 240 || p.scanline == p.preRenderLine) {
+		p.incrementCoarseX()
+		p.incrementY()
+	} else if p.vramIncrementFlag == 0 {
 		p.v++
 	} else {
 		p.v += 32
 	}
-	return data, nil
+}
+
+// renderingEnabled returns true if either background or sprite rendering is enabled.
+func (p *PPU) renderingEnabled() bool {
+	return p.showBackground || p.showSprite
+}
+
+// SetLayerMask shows or hides the background and the sprite layer on the output image regardless of PPUMASK.
+// This is for debugging, what the game sees such as sprite 0 hit is not changed.
+func (p *PPU) SetLayerMask(bg, sprites bool) {
+	p.hideBackground = !bg
+	p.hideSprites = !sprites
 }
 
 func (p *PPU) updateNMI(flag bool) {
@@ -416,9 +507,30 @@ func (p *PPU) incrementY() {
 	}
 }
 
+// watchAddress notifies the mapper of a pattern table fetch, e.g. MMC3 watches A12 to count scanlines.
+func (p *PPU) watchAddress(address uint16) {
+	if m, ok := p.bus.cartridge.Mapper.(ppuAddressWatcher); ok {
+		m.watchPPUAddress(address)
+	}
+}
+
+// fetchSpritePattern notifies the mapper of the sprite pattern fetch at cycles 257-320, 8 cycles for each of 8 slots.
+// Sprite pixels are read in renderSpritePixel, so this doesn't read the data.
+// Unused slots fetch tile $FF.
+// https://www.nesdev.org/wiki/PPU_rendering#Cycles_257-320
+func (p *PPU) fetchSpritePattern() {
+	slot := (p.cycle - 257) / 8
+	s := sprite{tile: 0xFF}
+	if slot < p.secondaryNum {
+		s = p.secondaryOAM[slot]
+	}
+	p.watchAddress(p.spritePatternAddress(s, 0))
+}
+
 func (p *PPU) fetchLowTileByte() error {
 	fineY := (p.v >> 12) & 0b111
 	address := 0x1000*uint16(p.backgroundTableFlag) + uint16(p.nameTableByte)*16 + fineY
+	p.watchAddress(address)
 	data, err := p.bus.read(address)
 	if err != nil {
 		return err
@@ -430,6 +542,7 @@ func (p *PPU) fetchLowTileByte() error {
 func (p *PPU) fetchHighTileByte() error {
 	fineY := (p.v >> 12) & 0b111
 	address := 0x1000*uint16(p.backgroundTableFlag) + uint16(p.nameTableByte)*16 + fineY + 8
+	p.watchAddress(address)
 	data, err := p.bus.read(address)
 	if err != nil {
 		return err
@@ -467,33 +580,64 @@ func (p *PPU) fetchNameTableByte() error {
 //   https://www.nesdev.org/wiki/PPU_OAM
 //   https://www.nesdev.org/wiki/PPU_sprite_evaluation
 func (p *PPU) evaluateSprite() {
-	// TODO(jyane): implement sprite size changing.
+	height := 8 << p.spriteSizeFlag
+	inRange := func(y int) bool {
+		// evaluating for the next scanline, Y is the top of the sprite - 1, so it's compared with the current scanline.
+		return y <= p.scanline && p.scanline < y+height
+	}
 	spriteCount := 0
-	for i := 0; i < 64; i++ {
-		y := int(p.primaryOAM[i*4])
-		tile := p.primaryOAM[i*4+1]
-		attribute := p.primaryOAM[i*4+2]
-		x := int(p.primaryOAM[i*4+3])
-		// evaluating for the next scanline.
-		if y <= p.scanline+1 && p.scanline+1 < y+8 {
-			if spriteCount < 8 {
-				p.secondaryOAM[spriteCount] = sprite{
-					index:     i,
-					y:         y,
-					tile:      tile,
-					attribute: attribute,
-					x:         x,
-				}
+	n := 0
+	for ; n < 64 && spriteCount < 8; n++ {
+		y := int(p.primaryOAM[n*4])
+		if inRange(y) {
+			p.secondaryOAM[spriteCount] = sprite{
+				index:     n,
+				y:         y,
+				tile:      p.primaryOAM[n*4+1],
+				attribute: p.primaryOAM[n*4+2],
+				x:         int(p.primaryOAM[n*4+3]),
 			}
 			spriteCount++
 		}
 	}
-	// NES allows only 8 sprites per line.
-	if 8 < spriteCount {
-		spriteCount = 8
-		p.spriteOverflow = true // I'm not sure whether this is correct.
-	}
 	p.secondaryNum = spriteCount
+	if !p.renderingEnabled() {
+		return
+	}
+	// NES allows only 8 sprites per line, the overflow check after 8 sprites has a hardware bug.
+	// When a sprite is not in range, the PPU increments both the sprite index n and the byte index m,
+	// so it reads the tile, attribute or X of the next sprites as Y diagonally.
+	// https://www.nesdev.org/wiki/PPU_sprite_evaluation#Sprite_overflow_bug
+	for m := 0; n < 64; n++ {
+		if inRange(int(p.primaryOAM[n*4+m])) {
+			p.spriteOverflow = true
+			return
+		}
+		m = (m + 1) % 4
+	}
+}
+
+// spritePatternAddress returns the address of the low pattern byte for the row (0-7, 0-15 for 8x16) from the top of the sprite.
+// 8x16 sprites take the table from bit 0 of the tile index and use the tile pair, vertical flip swaps the 2 tiles
+// in addition to flipping the rows of each tile.
+// https://www.nesdev.org/wiki/PPU_OAM#Byte_1
+func (p *PPU) spritePatternAddress(s sprite, row int) uint16 {
+	if p.spriteSizeFlag == 0 {
+		if s.verticalFlip() {
+			row = 7 - row
+		}
+		return 0x1000*uint16(p.spriteTableFlag) + uint16(s.tile)*16 + uint16(row)
+	}
+	if s.verticalFlip() {
+		row = 15 - row
+	}
+	tile := s.tileByte()
+	if 8 <= row {
+		// bottom tile.
+		tile++
+		row -= 8
+	}
+	return s.bank() + uint16(tile)*16 + uint16(row)
 }
 
 // TODO(jyane): refactor? returning 3 results is odd.
@@ -508,11 +652,8 @@ func (p *PPU) renderSpritePixel() (int, byte, error) {
 		sprite := p.secondaryOAM[i]
 		// if this sprite should be rendered on current x.
 		if sprite.x <= x && x < sprite.x+8 {
-			h := y - sprite.y
-			if sprite.verticalFlip() {
-				h = 7 - h
-			}
-			address := 0x1000*uint16(p.spriteTableFlag) + uint16(sprite.tile)*16 + uint16(h)
+			// Sprites are drawn from the scanline after Y.
+			address := p.spritePatternAddress(sprite, y-sprite.y-1)
 			lowTileByte, err := p.bus.read(address)
 			if err != nil {
 				return 0, 0, err
@@ -527,6 +668,10 @@ func (p *PPU) renderSpritePixel() (int, byte, error) {
 			}
 			lv := (lowTileByte >> shift) & 1
 			hv := (highTileByte >> shift) & 1
+			if hv<<1|lv == 0 {
+				// A transparent pixel shows the next sprite, the sprite 0 hit is also decided by opaque pixels only.
+				continue
+			}
 			return i, hv<<1 | lv, nil
 		}
 	}
@@ -537,27 +682,68 @@ func (p *PPU) renderBackgroundPixel() uint16 {
 	if !p.showBackground {
 		return 0
 	}
-	x := p.cycle - 1
-	// concatenete 2 tiles.
-	// https://www.jyane.dev/2022/06/04/nes-dev-common-mistakes.html (In Japanese)
-	lowTileByte := uint16(p.tileDataBuffer[4])<<8 | uint16(p.tileDataBuffer[1])
-	highTileByte := uint16(p.tileDataBuffer[5])<<8 | uint16(p.tileDataBuffer[2])
-	// considering fineX (p.x) to make the scroll smooth.
-	shift := (15 - (byte(x) % 8)) - p.x
-	lv := lowTileByte >> shift & 1
-	hv := highTileByte >> shift & 1
+	// fine X selects the bit from the top, so that the scroll is smooth.
+	shift := 15 - p.x
+	lv := p.patternShiftLow >> shift & 1
+	hv := p.patternShiftHigh >> shift & 1
 	value := byte(hv<<1 | lv)
-	// attribute
-	palette := byte(0)
-	// If the shift is over the 8x8 tile, attribute value (16x16) may also be over.
-	if 8 <= shift {
-		palette = p.tileDataBuffer[3]
-	} else {
-		palette = p.tileDataBuffer[0]
-	}
+	al := p.attributeShiftLow >> shift & 1
+	ah := p.attributeShiftHigh >> shift & 1
+	palette := byte(ah<<1 | al)
 	return 0x3F00 | uint16((palette<<2)|value)
 }
 
+// shiftBackground shifts the background shift registers by a pixel.
+func (p *PPU) shiftBackground() {
+	p.patternShiftLow <<= 1
+	p.patternShiftHigh <<= 1
+	p.attributeShiftLow <<= 1
+	p.attributeShiftHigh <<= 1
+}
+
+// loadBackground loads the fetched tile to the low 8 bits of the shift registers.
+func (p *PPU) loadBackground() {
+	p.patternShiftLow = p.patternShiftLow&0xFF00 | uint16(p.lowTileByte)
+	p.patternShiftHigh = p.patternShiftHigh&0xFF00 | uint16(p.highTileByte)
+	p.attributeShiftLow = p.attributeShiftLow&0xFF00 | 0xFF*uint16(p.attributeTableByte&1)
+	p.attributeShiftHigh = p.attributeShiftHigh&0xFF00 | 0xFF*uint16(p.attributeTableByte>>1&1)
+}
+
+// outputColor converts a palette value to the output color applying PPUMASK.
+// Grayscale is applied to the palette value, then emphasis darkens the channels of the resulting color.
+// https://www.nesdev.org/wiki/PPU_registers#Color_effects
+func (p *PPU) outputColor(value byte) color.RGBA {
+	if p.grayScale {
+		// Grayscale selects the gray column $x0 of the same row.
+		value &= 0x30
+	}
+	c := colors[value&0x3F]
+	// TODO(jyane): PAL swaps the red and green emphasis bits.
+	if p.emphasizeRed && p.emphasizeGreen && p.emphasizeBlue {
+		// All bits darken the whole picture.
+		c.R = emphasisAttenuate(c.R)
+		c.G = emphasisAttenuate(c.G)
+		c.B = emphasisAttenuate(c.B)
+	} else if p.emphasizeRed || p.emphasizeGreen || p.emphasizeBlue {
+		if !p.emphasizeRed {
+			c.R = emphasisAttenuate(c.R)
+		}
+		if !p.emphasizeGreen {
+			c.G = emphasisAttenuate(c.G)
+		}
+		if !p.emphasizeBlue {
+			c.B = emphasisAttenuate(c.B)
+		}
+	}
+	return c
+}
+
+// emphasisAttenuate darkens a channel which is not emphasized, the attenuation is about 0.816x on NTSC.
+// https://www.nesdev.org/wiki/NTSC_video#Color_Tint_Bits
+func emphasisAttenuate(x uint8) uint8 {
+	return uint8(int(x) * 816 / 1000)
+}
+
 func (p *PPU) renderPixel() error {
 	x := p.cycle - 1 // cycle 0 won't be rendered
 	y := p.scanline
@@ -579,33 +765,34 @@ func (p *PPU) renderPixel() error {
 	// 1-3      | 0            | X        | BG
 	// 1-3      | 1-3          | 0        | Sprite
 	// 1-3      | 1-3          | 1        | BG
-	bgOpaque := bg != 0
-	spOpaque := sp != 0
 	sprite := p.secondaryOAM[i]
-	color := &color.RGBA{}
+	// "when an opaque pixel of sprite 0 overlaps an opaque pixel of the background, this is a sprite zero hit"
+	if bg != 0 && sp != 0 && sprite.index == 0 && x < 255 {
+		p.spriteZeroHit = true
+	}
+	// The layer mask only affects the output image.
+	bgOpaque := bg != 0 && !p.hideBackground
+	spOpaque := sp != 0 && !p.hideSprites
+	var address uint16
 	if !spOpaque && !bgOpaque {
 		// both pixels are transparent, fallback to 0x3F00 color.
-		color = &colors[p.paletteRAM.read(0x3F00)]
+		address = 0x3F00
 	} else if spOpaque && !bgOpaque {
-		color = &colors[p.paletteRAM.read(sprite.paletteAddress(sp))]
+		address = sprite.paletteAddress(sp)
 	} else if !spOpaque && bgOpaque {
-		color = &colors[p.paletteRAM.read(paletteAddress)]
+		address = paletteAddress
 	} else {
 		// both pixles are opaque.
 		// checking the priority.
 		if sprite.priority() == 1 {
 			// behind background.
-			color = &colors[p.paletteRAM.read(paletteAddress)]
+			address = paletteAddress
 		} else {
 			// in front of background.
-			color = &colors[p.paletteRAM.read(sprite.paletteAddress(sp))]
-		}
-		// "when an opaque pixel of sprite 0 overlaps an opaque pixel of the background, this is a sprite zero hit"
-		if sprite.index == 0 && x < 255 {
-			p.spriteZeroHit = true
+			address = sprite.paletteAddress(sp)
 		}
 	}
-	p.picture.SetRGBA(x, y, *color)
+	p.picture.SetRGBA(x, y, p.outputColor(p.paletteRAM.read(address)))
 	return nil
 }
 
@@ -619,21 +806,24 @@ func (p *PPU) Step() (bool, error) {
 	if p.cycle == 341 {
 		p.cycle = 0
 		p.scanline++
-		if p.scanline == 262 {
+		if p.scanline > p.preRenderLine {
 			p.scanline = 0
 		}
 	}
 	// logic starts here.
-	if p.showBackground {
+	// The scroll updates (copyX, copyY and increments) and the fetches happen if either layer is rendered,
+	// so $2005/$2006 writes during rendering take effect at the same cycles as the hardware.
+	// https://www.nesdev.org/wiki/PPU_scrolling#At_dot_257_of_each_scanline
+	if p.renderingEnabled() {
 		if 1 <= p.cycle && p.cycle <= 256 && p.scanline <= 239 {
 			if err := p.renderPixel(); err != nil {
 				return false, fmt.Errorf("Failed to render a pixel: %w", err)
 			}
 		}
-		if p.scanline == 261 && 280 <= p.cycle && p.cycle <= 304 {
+		if p.scanline == p.preRenderLine && 280 <= p.cycle && p.cycle <= 304 {
 			p.copyY()
 		}
-		if p.scanline < 240 || p.scanline == 261 {
+		if p.scanline < 240 || p.scanline == p.preRenderLine {
 			if 1 <= p.cycle && p.cycle <= 256 && p.cycle%8 == 0 {
 				p.incrementCoarseX()
 			}
@@ -646,17 +836,15 @@ func (p *PPU) Step() (bool, error) {
 			if p.cycle == 257 {
 				p.copyX()
 			}
+			if (1 <= p.cycle && p.cycle <= 256) || (321 <= p.cycle && p.cycle <= 336) {
+				p.shiftBackground()
+			}
 			if (0 < p.cycle && p.cycle <= 257) || 320 < p.cycle {
 				switch p.cycle % 8 {
 				case 0:
-					// PPU fetches tile data for current cycle "2 fetch cycles before".
-					// Here stores current cycle data for after the next.
-					p.tileDataBuffer[3] = p.tileDataBuffer[0] // attributeTableByte
-					p.tileDataBuffer[4] = p.tileDataBuffer[1] // lowTileByte
-					p.tileDataBuffer[5] = p.tileDataBuffer[2] // highTileByte
-					p.tileDataBuffer[0] = p.attributeTableByte
-					p.tileDataBuffer[1] = p.lowTileByte
-					p.tileDataBuffer[2] = p.highTileByte
+					// The tile fetched in the last 8 cycles is rendered after the current tile.
+					// The first 2 tiles of a scanline are fetched at 321-336 of the previous scanline.
+					p.loadBackground()
 				case 1:
 					if err := p.fetchNameTableByte(); err != nil {
 						return false, err
@@ -677,12 +865,27 @@ func (p *PPU) Step() (bool, error) {
 			}
 		}
 	}
+	// OAMADDR is set to 0 during each of ticks 257-320 of the pre-render and visible scanlines.
+	// https://www.nesdev.org/wiki/PPU_registers#OAMADDR
+	if p.renderingEnabled() && (p.scanline < 240 || p.scanline == p.preRenderLine) && 257 <= p.cycle && p.cycle <= 320 {
+		p.oamAddress = 0
+	}
+	if p.renderingEnabled() && (p.scanline < 240 || p.scanline == p.preRenderLine) && 257 <= p.cycle && p.cycle <= 320 && p.cycle%8 == 5 {
+		p.fetchSpritePattern()
+	}
+	// The last visible pixel was rendered, publishes the frame.
+	if p.scanline == 239 && p.cycle == 257 {
+		copy(p.front.Pix, p.picture.Pix)
+	}
+	if p.scanlineCallback != nil && p.scanline < 240 && p.cycle == 257 {
+		p.scanlineCallback(p.scanline)
+	}
 	// set vblank
 	if p.scanline == 241 && p.cycle == 1 {
 		p.updateNMI(true)
 	}
-	// clear vblank
-	if p.scanline == 261 && p.cycle == 1 {
+	// clear vblank, sprite 0 hit and sprite overflow at dot 1 of the pre-render line.
+	if p.scanline == p.preRenderLine && p.cycle == 1 {
 		p.spriteOverflow = false
 		p.spriteZeroHit = false
 		p.updateNMI(false)
@@ -703,3 +906,34 @@ func (p *PPU) Step() (bool, error) {
 		return false, nil
 	}
 }
+
+// saveState saves the registers and the rendering state, preRenderLine is not saved since SetRegion decides it.
+func (p *PPU) saveState(w *stateWriter) {
+	w.write(p.oamAddress, p.primaryOAM, p.secondaryNum, p.spriteOverflow, p.spriteZeroHit,
+		p.v, p.t, p.x, p.w, p.buffer, p.nmiOccurred, p.oldNMI, p.nmiOutput,
+		p.nameTableFlag, p.vramIncrementFlag, p.spriteTableFlag, p.backgroundTableFlag, p.spriteSizeFlag, p.masterSlaveSelectFlag,
+		p.grayScale, p.showLeftBackground, p.showLeftSprite, p.showBackground, p.showSprite,
+		p.emphasizeRed, p.emphasizeGreen, p.emphasizeBlue, p.register, p.paletteRAM.ram,
+		p.nameTableByte, p.attributeTableByte, p.lowTileByte, p.highTileByte,
+		p.patternShiftLow, p.patternShiftHigh, p.attributeShiftLow, p.attributeShiftHigh, p.cycle, p.scanline)
+	for _, s := range p.secondaryOAM {
+		w.write(s.index, s.y, s.tile, s.attribute, s.x)
+	}
+}
+
+func (p *PPU) loadState(r *stateReader) {
+	r.read(&p.oamAddress, &p.primaryOAM, &p.secondaryNum, &p.spriteOverflow, &p.spriteZeroHit,
+		&p.v, &p.t, &p.x, &p.w, &p.buffer, &p.nmiOccurred, &p.oldNMI, &p.nmiOutput,
+		&p.nameTableFlag, &p.vramIncrementFlag, &p.spriteTableFlag, &p.backgroundTableFlag, &p.spriteSizeFlag, &p.masterSlaveSelectFlag,
+		&p.grayScale, &p.showLeftBackground, &p.showLeftSprite, &p.showBackground, &p.showSprite,
+		&p.emphasizeRed, &p.emphasizeGreen, &p.emphasizeBlue, &p.register, &p.paletteRAM.ram,
+		&p.nameTableByte, &p.attributeTableByte, &p.lowTileByte, &p.highTileByte,
+		&p.patternShiftLow, &p.patternShiftHigh, &p.attributeShiftLow, &p.attributeShiftHigh, &p.cycle, &p.scanline)
+	for i := range p.secondaryOAM {
+		s := &p.secondaryOAM[i]
+		r.read(&s.index, &s.y, &s.tile, &s.attribute, &s.x)
+	}
+	r.checkRange("Secondary OAM sprites", p.secondaryNum, len(p.secondaryOAM)+1)
+	r.checkRange("PPU cycle", p.cycle, 341)
+	r.checkRange("Scanline", p.scanline, p.preRenderLine+1)
+}
@@ -0,0 +1,111 @@
+package nes
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// PPU snapshot test compares PPU states at the first instruction of each scanline with a log recorded by this emulator,
+// so that changes of the PPU timing show up as the first diverged scanline.
+// Log format:
+//   FRAME:0 SL:241 CYC:5 V:0000 T:0000 X:0 S0:0 OV:0
+// The logs can be (re)generated by `go test ./nes -run TestPPUSnapshot -update`, see testdata/README.md.
+// TODO(jyane): Compare with logs converted from a reference emulator for a PPU timing test ROM, e.g. a sprite 0 hit test.
+
+var update = flag.Bool("update", false, "update reference logs")
+
+var ppuSnapshotTests = []struct {
+	rom    string
+	log    string
+	frames uint64
+}{
+	{"../integration/testdata/sample1.nes", "testdata/sample1_ppu_snapshot.log", 2},
+}
+
+func ppuLogLine(frame uint64, p *PPU) string {
+	b2i := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	return fmt.Sprintf("FRAME:%d SL:%d CYC:%d V:%04X T:%04X X:%d S0:%d OV:%d",
+		frame, p.scanline, p.cycle, p.v, p.t, p.x, b2i(p.spriteZeroHit), b2i(p.spriteOverflow))
+}
+
+// runPPULog runs the console for the frames and returns the PPU states at the first instruction of each scanline.
+func runPPULog(c *NesConsole, frames uint64) ([]string, error) {
+	lines := []string{}
+	scanline := c.ppu.scanline
+	for c.currentFrame < frames {
+		if _, err := c.step(); err != nil {
+			return nil, err
+		}
+		if c.ppu.scanline != scanline {
+			scanline = c.ppu.scanline
+			lines = append(lines, ppuLogLine(c.currentFrame, c.ppu))
+		}
+	}
+	return lines, nil
+}
+
+func TestPPUSnapshot(t *testing.T) {
+	for _, tt := range ppuSnapshotTests {
+		b, err := ioutil.ReadFile(tt.rom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cartridge, err := NewCartridge(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		console, _ := NewConsole(cartridge, false /* debug */)
+		c := console.(*NesConsole)
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := runPPULog(c, tt.frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *update {
+			f, err := os.Create(tt.log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range got {
+				fmt.Fprintln(f, line)
+			}
+			f.Close()
+			continue
+		}
+		in, err := os.Open(tt.log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		scanner := bufio.NewScanner(in)
+		i := 0
+		for ; scanner.Scan(); i++ {
+			want := scanner.Text()
+			if len(got) <= i {
+				t.Fatalf("%s: PPU snapshot ended at line %d, want: %s", tt.rom, i+1, want)
+			}
+			if got[i] != want {
+				// The first divergence is the most interesting.
+				before := "initial state"
+				if 0 < i {
+					before = got[i-1]
+				}
+				t.Fatalf("%s: PPU snapshot diverged at line %d\nbefore: %s\ngot:    %s\nwant:   %s", tt.rom, i+1, before, got[i], want)
+			}
+		}
+		if i < len(got) {
+			t.Errorf("%s: PPU snapshot has extra lines from line %d: %s", tt.rom, i+1, got[i])
+		}
+	}
+}
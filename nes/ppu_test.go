@@ -0,0 +1,713 @@
+package nes
+
+import (
+	"image/color"
+	"testing"
+)
+
+// newTestCartridge creates a cartridge from PRG ROM and CHR ROM with a minimal INES header.
+func newTestCartridge(mapper byte, prgROM []byte, chrROM []byte) *Cartridge {
+	header := []byte{'N', 'E', 'S', msDOSEOF,
+		byte(len(prgROM) / prgROMSizeUnit), byte(len(chrROM) / chrROMSizeUnit),
+		(mapper & 0x0F) << 4, mapper & 0xF0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := append(append(header, prgROM...), chrROM...)
+	cartridge, err := NewCartridge(data)
+	if err != nil {
+		panic(err)
+	}
+	return cartridge
+}
+
+func newTestPPU() *PPU {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	return NewPPU(NewPPUBus(NewRAM(), cartridge))
+}
+
+func TestPPUPreRenderClearsSpriteFlags(t *testing.T) {
+	p := newTestPPU()
+	p.scanline = 260
+	p.cycle = 340
+	p.spriteOverflow = true
+	p.spriteZeroHit = true
+	// 260:340 -> 261:0
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.spriteOverflow || !p.spriteZeroHit {
+		t.Fatalf("The flags are cleared before dot 1 of the pre-render line: scanline=%d, cycle=%d", p.scanline, p.cycle)
+	}
+	// 261:0 -> 261:1
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if p.spriteOverflow || p.spriteZeroHit {
+		t.Fatalf("The flags are not cleared at dot 1 of the pre-render line: scanline=%d, cycle=%d", p.scanline, p.cycle)
+	}
+	if got := p.readPPUSTATUS(); got&0x60 != 0 {
+		t.Errorf("PPUSTATUS after the pre-render line: got=0x%02x, want bit 5 and 6 cleared", got)
+	}
+}
+
+func TestPPUOAMADDRResetDuringSpriteFetches(t *testing.T) {
+	p := newTestPPU()
+	p.writePPUMASK(0x18) // show background and sprites.
+	p.writeOAMADDR(0x10)
+	p.scanline = 0
+	p.cycle = 255
+	// 0:255 -> 0:256
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if p.oamAddress != 0x10 {
+		t.Fatalf("OAMADDR before the sprite tile fetches: got=0x%02x, want=0x10", p.oamAddress)
+	}
+	for p.cycle < 320 {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.oamAddress != 0 {
+		t.Errorf("OAMADDR after the sprite tile fetches: got=0x%02x, want=0x00", p.oamAddress)
+	}
+}
+
+func TestPPUOAMADDRKeptWhenRenderingDisabled(t *testing.T) {
+	p := newTestPPU()
+	p.writeOAMADDR(0x10)
+	p.scanline = 0
+	p.cycle = 256
+	for p.cycle < 320 {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.oamAddress != 0x10 {
+		t.Errorf("OAMADDR with rendering disabled: got=0x%02x, want=0x10", p.oamAddress)
+	}
+}
+
+func TestPPURegistersString(t *testing.T) {
+	p := newTestPPU()
+	p.writePPUCTRL(0b10111101)
+	p.writePPUMASK(0b01011010)
+	got := p.registersString()
+	want := "PPUCTRL:   nametable=$2400, increment=32, sprite table=$1000, background table=$1000, sprite size=8x16, master/slave=0, NMI=true\n" +
+		"PPUMASK:   grayscale=false, left background=true, left sprite=false, background=true, sprite=true, emphasis(RGB)=false,true,false\n" +
+		"PPUSTATUS: sprite overflow=false, sprite 0 hit=false, vblank=false\n" +
+		"Internal:  v=0x0000, t=0x0400, x=0, w=false, OAMADDR=0x00\n"
+	if got != want {
+		t.Errorf("registersString:\ngot:\n%swant:\n%s", got, want)
+	}
+}
+
+func TestPPUDATAReadDuringRendering(t *testing.T) {
+	tests := []struct {
+		name     string
+		mask     byte
+		scanline int
+		v        uint16
+		want     uint16
+	}{
+		// coarse X 3 -> 4, fine Y 2 -> 3
+		{"rendering", 0x08, 100, 0x2003, 0x3004},
+		// coarse X 31 -> 0 switches the horizontal name table, fine Y 7 -> 0 increments coarse Y.
+		{"rendering wrap", 0x18, 100, 0x701F, 0x0420},
+		{"vblank", 0x08, 241, 0x2003, 0x2004},
+		{"rendering disabled", 0x00, 100, 0x2003, 0x2004},
+	}
+	for _, tt := range tests {
+		p := newTestPPU()
+		p.writePPUMASK(tt.mask)
+		p.scanline = tt.scanline
+		p.v = tt.v
+		if _, err := p.readPPUDATA(); err != nil {
+			t.Fatal(err)
+		}
+		if p.v != tt.want {
+			t.Errorf("%s: v after reading PPUDATA: got=0x%04x, want=0x%04x", tt.name, p.v, tt.want)
+		}
+	}
+}
+
+func TestPPUBackdropChangedMidFrame(t *testing.T) {
+	p := newTestPPU()
+	p.Reset()
+	setBackdrop := func(c byte) {
+		p.writePPUADDR(0x3F)
+		p.writePPUADDR(0x00)
+		if err := p.writePPUDATA(c); err != nil {
+			t.Fatal(err)
+		}
+		p.writePPUADDR(0x20)
+		p.writePPUADDR(0x00)
+	}
+	setBackdrop(0x01)
+	p.writePPUMASK(0x0A) // background and the leftmost 8 pixels.
+	for {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+		// Changes the backdrop in the horizontal blank with rendering disabled like games do.
+		if p.scanline == 120 && p.cycle == 260 {
+			p.writePPUMASK(0x00)
+			setBackdrop(0x16)
+			p.writePPUMASK(0x0A)
+		}
+		if ok, _ := p.Frame(); ok {
+			break
+		}
+	}
+	if got, want := p.front.RGBAAt(10, 10), colors[0x01]; got != want {
+		t.Errorf("Backdrop at the top: got=%v, want=%v", got, want)
+	}
+	if got, want := p.front.RGBAAt(10, 200), colors[0x16]; got != want {
+		t.Errorf("Backdrop at the bottom: got=%v, want=%v", got, want)
+	}
+}
+
+func TestPPUOAMDATAWriteDuringRendering(t *testing.T) {
+	tests := []struct {
+		name        string
+		mask        byte
+		scanline    int
+		wantData    byte
+		wantAddress byte
+	}{
+		{"vblank", 0x18, 241, 0xAB, 0x12},
+		{"rendering disabled", 0x00, 100, 0xAB, 0x12},
+		// The data is not written (OAM keeps $FF from power-on) and only the high 6 bits are incremented.
+		{"rendering", 0x18, 100, 0xFF, 0x15},
+		{"pre-render line", 0x08, 261, 0xFF, 0x15},
+	}
+	for _, tt := range tests {
+		p := newTestPPU()
+		p.writePPUMASK(tt.mask)
+		p.scanline = tt.scanline
+		p.writeOAMADDR(0x11)
+		p.writeOAMDATA(0xAB)
+		if got := p.primaryOAM[0x11]; got != tt.wantData {
+			t.Errorf("%s: OAM data: got=0x%02x, want=0x%02x", tt.name, got, tt.wantData)
+		}
+		if got := p.oamAddress; got != tt.wantAddress {
+			t.Errorf("%s: OAMADDR: got=0x%02x, want=0x%02x", tt.name, got, tt.wantAddress)
+		}
+	}
+}
+
+func TestPPUPowerOnOAM(t *testing.T) {
+	p := newTestPPU()
+	for i, got := range p.primaryOAM {
+		if got != 0xFF {
+			t.Fatalf("OAM[%d] on power-on: got=0x%02x, want=0xff", i, got)
+		}
+	}
+	// Reset doesn't change OAM.
+	p.primaryOAM[0] = 0x12
+	p.Reset()
+	if got := p.primaryOAM[0]; got != 0x12 {
+		t.Errorf("OAM[0] after reset: got=0x%02x, want=0x12", got)
+	}
+}
+
+func TestPPUSTATUSResetsWriteLatch(t *testing.T) {
+	p := newTestPPU()
+	// A stray high byte, then reading $2002 makes the next write the high byte again.
+	p.writePPUADDR(0x21)
+	p.readPPUSTATUS()
+	p.writePPUADDR(0x3F)
+	p.writePPUADDR(0x10)
+	if p.v != 0x3F10 {
+		t.Errorf("PPUADDR after $2006, $2002, $2006, $2006: got=0x%04x, want=0x3f10", p.v)
+	}
+	if p.w {
+		t.Error("The write latch is set after the 2 writes")
+	}
+	// The same latch is shared with PPUSCROLL.
+	p.writePPUSCROLL(0x7D)
+	p.readPPUSTATUS()
+	p.writePPUSCROLL(0x5E)
+	if p.x != 6 || p.t&0x1F != 0x0B || !p.w {
+		t.Errorf("PPUSCROLL after $2005, $2002, $2005: got fineX=%d, coarseX=%d, w=%t, want fineX=6, coarseX=11, w=true", p.x, p.t&0x1F, p.w)
+	}
+}
+
+func TestBackgroundFineXScroll(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	for i := 0x10; i < 0x18; i++ {
+		chrROM[i] = 0xFF // tile 1 is filled with the color 1.
+	}
+	for x := byte(0); x < 8; x++ {
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+		p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+		// Tile 1 at the column 1 and 2 of the first row, the attribute selects palette 1 for the top-left 16x16
+		// and palette 2 for the top-right 16x16.
+		for _, address := range []uint16{0x2001, 0x2002} {
+			if err := p.bus.write(address, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := p.bus.write(0x23C0, 0x09); err != nil {
+			t.Fatal(err)
+		}
+		p.paletteRAM.write(0x3F00, 0x0F)
+		p.paletteRAM.write(0x3F05, 0x16)
+		p.paletteRAM.write(0x3F09, 0x2A)
+		p.writePPUSCROLL(x)
+		p.writePPUSCROLL(0)
+		p.writePPUMASK(0x0A)
+		p.scanline = p.preRenderLine
+		p.cycle = 0
+		for !(p.scanline == 1 && p.cycle == 0) {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for px := 0; px < 32; px++ {
+			// The tiles are at 8-23 and shifted left by fine X, the palette changes at 16.
+			want := colors[0x0F]
+			if 8 <= px+int(x) && px+int(x) < 16 {
+				want = colors[0x16]
+			} else if 16 <= px+int(x) && px+int(x) < 24 {
+				want = colors[0x2A]
+			}
+			if got := p.picture.RGBAAt(px, 0); got != want {
+				t.Errorf("fine X=%d: pixel %d: got=%v, want=%v", x, px, got, want)
+			}
+		}
+	}
+}
+
+func TestMidFrameScrollSplit(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	for i := 0x10; i < 0x18; i++ {
+		chrROM[i] = 0xFF // tile 1 is filled with the color 1.
+	}
+	// stepTo steps the PPU until the scanline and the cycle.
+	stepTo := func(p *PPU, scanline, cycle int) {
+		for !(p.scanline == scanline && p.cycle == cycle) {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name string
+		// split writes registers at scanline 100, cycle 300.
+		split     func(p *PPU)
+		wantSplit int // the first scanline which shows the name table at $2800
+	}{
+		// The second $2006 write copies t to v immediately, so the next scanline uses the new v.
+		{"$2006", func(p *PPU) { p.writePPUADDR(0x28); p.writePPUADDR(0x00) }, 101},
+		// The nametable bits of $2000 and Y of $2005 are copied to v only at the pre-render line.
+		{"$2000 and $2005", func(p *PPU) { p.writePPUCTRL(0x02); p.writePPUSCROLL(0); p.writePPUSCROLL(0) }, -1},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+		p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+		// The name table at $2800 is filled with tile 1, horizontal mirroring makes it different from $2000.
+		for address := uint16(0x2800); address < 0x2BC0; address++ {
+			if err := p.bus.write(address, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.paletteRAM.write(0x3F00, 0x0F)
+		p.paletteRAM.write(0x3F01, 0x16)
+		p.writePPUMASK(0x0A)
+		p.scanline = p.preRenderLine
+		p.cycle = 0
+		stepTo(p, 100, 300)
+		tt.split(p)
+		stepTo(p, 110, 0)
+		for y := 90; y < 110; y++ {
+			want := colors[0x0F]
+			if tt.wantSplit != -1 && tt.wantSplit <= y {
+				want = colors[0x16]
+			}
+			for x := 0; x < 256; x += 8 {
+				if got := p.picture.RGBAAt(x, y); got != want {
+					t.Errorf("%s: pixel (%d, %d): got=%v, want=%v", tt.name, x, y, got, want)
+				}
+			}
+		}
+		// t is copied to v at the pre-render line, the next frame starts from $2800.
+		stepTo(p, 0, 0)
+		if got, want := 0x2000|p.v&0x0C00, uint16(0x2800); got != want {
+			t.Errorf("%s: the name table of the next frame: got=0x%04x, want=0x%04x", tt.name, got, want)
+		}
+	}
+}
+
+func TestPPUADDRIgnoresHighBits(t *testing.T) {
+	p := newTestPPU()
+	p.t = 0x4000
+	p.writePPUADDR(0xFF)
+	p.writePPUADDR(0x12)
+	if got, want := p.v, uint16(0x3F12); got != want {
+		t.Errorf("v after $2006 = 0xFF, 0x12: got=0x%04x, want=0x%04x", got, want)
+	}
+}
+
+func TestSpriteZeroHitEdges(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	for i := 0x10; i < 0x18; i++ {
+		chrROM[i] = 0xFF // tile 1 is filled with the color 1.
+	}
+	tests := []struct {
+		name string
+		x    byte
+		mask byte
+		want bool
+	}{
+		{"x=255", 255, 0x1E, false},
+		{"x=0 with left clipping", 0, 0x18, false},
+		{"x=0 without left clipping", 0, 0x1E, true},
+		{"x=4 with left clipping", 4, 0x18, true},
+		{"x=10", 10, 0x18, true},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+		p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+		// The background is opaque everywhere.
+		for address := uint16(0x2000); address < 0x23C0; address++ {
+			if err := p.bus.write(address, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		copy(p.primaryOAM[:], []byte{10, 1, 0, tt.x})
+		for i := 4; i < len(p.primaryOAM); i++ {
+			p.primaryOAM[i] = 0xFF
+		}
+		p.writePPUMASK(tt.mask)
+		p.scanline = p.preRenderLine
+		p.cycle = 0
+		for p.scanline != 20 {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if p.spriteZeroHit != tt.want {
+			t.Errorf("%s: sprite 0 hit: got=%t, want=%t", tt.name, p.spriteZeroHit, tt.want)
+		}
+	}
+}
+
+func TestSpriteZeroHitTiming(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	for i := 0x10; i < 0x18; i++ {
+		chrROM[i] = 0xFF // tile 1 is filled with the color 1.
+	}
+	tests := []struct {
+		name      string
+		x         byte
+		mask      byte
+		wantCycle int // the cycle which renders the first overlapping pixel
+	}{
+		{"x=40", 40, 0x1E, 41},
+		{"x=4 with background left clipping", 4, 0x1C, 9},
+		{"x=4 with sprite left clipping", 4, 0x1A, 9},
+		{"x=254", 254, 0x1E, 255},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+		p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+		for address := uint16(0x2000); address < 0x23C0; address++ {
+			if err := p.bus.write(address, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Y=29 draws sprite 0 from scanline 30.
+		copy(p.primaryOAM[:], []byte{29, 1, 0, tt.x})
+		for i := 4; i < len(p.primaryOAM); i++ {
+			p.primaryOAM[i] = 0xFF
+		}
+		p.writePPUMASK(tt.mask)
+		p.scanline = p.preRenderLine
+		p.cycle = 0
+		for !p.spriteZeroHit {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if p.scanline == 240 {
+				t.Fatalf("%s: sprite 0 hit didn't happen", tt.name)
+			}
+		}
+		if p.scanline != 30 || p.cycle != tt.wantCycle {
+			t.Errorf("%s: sprite 0 hit at scanline=%d, cycle=%d, want scanline=30, cycle=%d", tt.name, p.scanline, p.cycle, tt.wantCycle)
+		}
+	}
+}
+
+func TestTransparentSpritePixelShowsNextSprite(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	for i := 0x10; i < 0x18; i++ {
+		chrROM[i] = 0xFF // tile 1 is filled with the color 1.
+	}
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+	p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+	p.paletteRAM.write(0x3F00, 0x0F)
+	p.paletteRAM.write(0x3F11, 0x16)
+	p.paletteRAM.write(0x3F15, 0x2A)
+	p.writePPUMASK(0x1E)
+	// Sprite 0 with the transparent tile 0 is in front of sprite 1 with palette 1.
+	p.secondaryOAM[0] = sprite{index: 0, y: 9, tile: 0, x: 16}
+	p.secondaryOAM[1] = sprite{index: 1, y: 9, tile: 1, attribute: 1, x: 16}
+	p.secondaryNum = 2
+	p.scanline = 10
+	p.cycle = 17
+	if err := p.renderPixel(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.picture.RGBAAt(16, 10), colors[0x2A]; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
+
+func TestSpriteOverflow(t *testing.T) {
+	// Each case sets OAM bytes on top of OAM filled with $FF, which is never in range, and evaluates scanline 10.
+	tests := []struct {
+		name     string
+		oam      map[int]byte
+		rendered bool
+		want     bool
+	}{
+		{"8 sprites", inRangeSprites(8, nil), true, false},
+		{"9 sprites", inRangeSprites(9, nil), true, true},
+		{"rendering disabled", inRangeSprites(9, nil), false, false},
+		// Sprite 8 is out of range, then the PPU reads the tile of sprite 9 as Y.
+		{"false positive by tile", inRangeSprites(8, map[int]byte{9*4 + 1: 8}), true, true},
+		{"false positive by X", inRangeSprites(8, map[int]byte{11*4 + 3: 5}), true, true},
+		// Sprite 9 is in range, but the PPU reads its tile as Y and misses it.
+		{"false negative", inRangeSprites(8, map[int]byte{9 * 4: 8}), true, false},
+	}
+	for _, tt := range tests {
+		p := newTestPPU()
+		for i := range p.primaryOAM {
+			p.primaryOAM[i] = 0xFF
+		}
+		for address, data := range tt.oam {
+			p.primaryOAM[address] = data
+		}
+		if tt.rendered {
+			p.writePPUMASK(0x18)
+		}
+		p.scanline = 9
+		p.evaluateSprite()
+		if p.spriteOverflow != tt.want {
+			t.Errorf("%s: sprite overflow: got=%t, want=%t", tt.name, p.spriteOverflow, tt.want)
+		}
+		if p.secondaryNum > 8 {
+			t.Errorf("%s: sprites on the scanline: got=%d, want<=8", tt.name, p.secondaryNum)
+		}
+	}
+}
+
+// inRangeSprites returns OAM bytes which put the first n sprites on scanline 10, with the extra bytes.
+func inRangeSprites(n int, extra map[int]byte) map[int]byte {
+	oam := map[int]byte{}
+	for i := 0; i < n; i++ {
+		oam[i*4] = 5
+	}
+	for address, data := range extra {
+		oam[address] = data
+	}
+	return oam
+}
+
+func TestSpritePatternAddress8x16(t *testing.T) {
+	p := newTestPPU()
+	p.writePPUCTRL(0x20) // 8x16
+	// Tile 0x13 selects $1000 and the tiles 0x12 (top) and 0x13 (bottom).
+	for row := 0; row < 16; row++ {
+		for _, flip := range []bool{false, true} {
+			s := sprite{tile: 0x13}
+			if flip {
+				s.attribute = 0x80
+			}
+			tile, tileRow := uint16(0x12), row
+			if flip {
+				tileRow = 15 - row
+			}
+			if 8 <= tileRow {
+				tile, tileRow = 0x13, tileRow-8
+			}
+			want := 0x1000 + tile*16 + uint16(tileRow)
+			if got := p.spritePatternAddress(s, row); got != want {
+				t.Errorf("Row %d, vertical flip=%t: got=0x%04x, want=0x%04x (tile 0x%02x, row %d)", row, flip, got, want, tile, tileRow)
+			}
+		}
+	}
+	// The first and the last rows are easy to get wrong.
+	for _, tt := range []struct {
+		attribute byte
+		row       int
+		want      uint16
+	}{
+		{0x00, 0, 0x1120},
+		{0x00, 15, 0x1137},
+		{0x80, 0, 0x1137},
+		{0x80, 15, 0x1120},
+	} {
+		if got := p.spritePatternAddress(sprite{tile: 0x13, attribute: tt.attribute}, tt.row); got != tt.want {
+			t.Errorf("Row %d, attribute=0x%02x: got=0x%04x, want=0x%04x", tt.row, tt.attribute, got, tt.want)
+		}
+	}
+}
+
+func TestRenderSprite8x16(t *testing.T) {
+	chrROM := make([]byte, chrROMSizeUnit)
+	// Tile 0x02 (top) row 0 and tile 0x03 (bottom) row 7 have different columns opaque.
+	chrROM[0x02*16] = 0x80
+	chrROM[0x03*16+7] = 0x40
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+	p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+	p.writePPUCTRL(0x20)
+	p.writePPUMASK(0x14)
+	tests := []struct {
+		attribute byte
+		scanline  int
+		x         int // the opaque column
+	}{
+		{0x00, 10, 0},
+		{0x00, 25, 1},
+		{0x80, 10, 1}, // the bottom row of the bottom tile comes to the top.
+		{0x80, 25, 0},
+	}
+	for _, tt := range tests {
+		// Y is the top - 1, the sprite covers scanlines 10-25.
+		p.secondaryOAM[0] = sprite{y: 9, tile: 0x02, attribute: tt.attribute, x: 0}
+		p.secondaryNum = 1
+		p.scanline = tt.scanline
+		for x := 0; x < 2; x++ {
+			p.cycle = x + 1
+			_, got, err := p.renderSpritePixel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := byte(0)
+			if x == tt.x {
+				want = 1
+			}
+			if got != want {
+				t.Errorf("attribute=0x%02x, scanline=%d, x=%d: got=%d, want=%d", tt.attribute, tt.scanline, x, got, want)
+			}
+		}
+	}
+}
+
+func TestPPUGrayscale(t *testing.T) {
+	p := newTestPPU()
+	// Showing nothing renders the backdrop color $3F00.
+	p.writePPUMASK(0x01)
+	p.scanline = 0
+	p.cycle = 1
+	for value := byte(0); value < 0x40; value++ {
+		p.paletteRAM.write(0x3F00, value)
+		if err := p.renderPixel(); err != nil {
+			t.Fatal(err)
+		}
+		// Every hue of a row becomes the gray of the row, e.g. $16 -> $10.
+		if got, want := p.picture.RGBAAt(0, 0), colors[value&0x30]; got != want {
+			t.Errorf("Grayscale 0x%02x: got=%v, want=%v (0x%02x)", value, got, want, value&0x30)
+		}
+	}
+}
+
+func TestPPUColorEmphasis(t *testing.T) {
+	p := newTestPPU()
+	p.scanline = 0
+	p.cycle = 1
+	// $30 is white, $16 is red and $10 is the gray of its row.
+	white := colors[0x30]
+	gray := colors[0x10]
+	tests := []struct {
+		value byte
+		mask  byte
+		want  color.RGBA
+	}{
+		{0x30, 0x00, white},
+		{0x30, 0x20, color.RGBA{white.R, emphasisAttenuate(white.G), emphasisAttenuate(white.B), 255}},
+		{0x30, 0x40, color.RGBA{emphasisAttenuate(white.R), white.G, emphasisAttenuate(white.B), 255}},
+		{0x30, 0x80, color.RGBA{emphasisAttenuate(white.R), emphasisAttenuate(white.G), white.B, 255}},
+		{0x30, 0x60, color.RGBA{white.R, white.G, emphasisAttenuate(white.B), 255}},
+		// All bits darken every channel.
+		{0x30, 0xE0, color.RGBA{emphasisAttenuate(white.R), emphasisAttenuate(white.G), emphasisAttenuate(white.B), 255}},
+		// Grayscale is applied first, then the gray is emphasized.
+		{0x16, 0x21, color.RGBA{gray.R, emphasisAttenuate(gray.G), emphasisAttenuate(gray.B), 255}},
+	}
+	for _, tt := range tests {
+		p.paletteRAM.write(0x3F00, tt.value)
+		p.writePPUMASK(tt.mask)
+		if err := p.renderPixel(); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.picture.RGBAAt(0, 0); got != tt.want {
+			t.Errorf("0x%02x, PPUMASK 0x%02x: got=%v, want=%v", tt.value, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestPPULayerMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		bg      bool
+		sprites bool
+		want    byte
+	}{
+		{"all", true, true, 0x2A},
+		{"background only", true, false, 0x16},
+		{"sprites only", false, true, 0x2A},
+		{"none", false, false, 0x0F},
+	}
+	for _, test := range tests {
+		chrROM := make([]byte, chrROMSizeUnit)
+		for i := 0x10; i < 0x18; i++ {
+			chrROM[i] = 0xFF // tile 1 is filled with the color 1.
+		}
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), chrROM)
+		p := NewPPU(NewPPUBus(NewRAM(), cartridge))
+		p.paletteRAM.write(0x3F00, 0x0F)
+		p.paletteRAM.write(0x3F01, 0x16)
+		p.paletteRAM.write(0x3F11, 0x2A)
+		p.writePPUMASK(0x1E)
+		p.SetLayerMask(test.bg, test.sprites)
+		// An opaque background pixel under an opaque sprite 0 pixel.
+		p.patternShiftLow = 0xFFFF
+		p.secondaryOAM[0] = sprite{index: 0, y: 9, tile: 1, x: 16}
+		p.secondaryNum = 1
+		p.scanline = 10
+		p.cycle = 17
+		if err := p.renderPixel(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := p.picture.RGBAAt(16, 10), colors[test.want]; got != want {
+			t.Errorf("%s: got=%v, want=%v", test.name, got, want)
+		}
+		if !p.spriteZeroHit {
+			t.Errorf("%s: sprite 0 hit should not be affected by the layer mask", test.name)
+		}
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	p := newTestPPU()
+	p.paletteRAM.write(0x3F00, 0x0F)
+	p.paletteRAM.write(0x3F05, 0x16)
+	p.paletteRAM.write(0x3F1F, 0x2A)
+	img, err := p.Palettes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, colors[0x0F]},
+		{5*16 + 15, 15, colors[0x16]},
+		{15*16 + 8, 16 + 8, colors[0x2A]},
+	}
+	for _, tt := range tests {
+		if got := img.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("(%d, %d): got=%v, want=%v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
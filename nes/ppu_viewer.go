@@ -0,0 +1,97 @@
+package nes
+
+import (
+	"image"
+	"image/color"
+)
+
+// Viewers for debugging, these render PPU memory as images without affecting the PPU state.
+
+// tileColor returns a color of a pixel value in a tile with the background palette.
+func (p *PPU) tileColor(palette byte, value byte) color.RGBA {
+	if value == 0 {
+		return colors[p.paletteRAM.read(0x3F00)&0x3F]
+	}
+	return colors[p.paletteRAM.read(0x3F00|uint16(palette<<2|value))&0x3F]
+}
+
+// drawTile draws a 8x8 tile from the pattern table at (x, y).
+func (p *PPU) drawTile(img *image.RGBA, x, y int, table uint16, tile byte, palette byte) error {
+	for row := 0; row < 8; row++ {
+		address := table + uint16(tile)*16 + uint16(row)
+		low, err := p.bus.read(address)
+		if err != nil {
+			return err
+		}
+		high, err := p.bus.read(address + 8)
+		if err != nil {
+			return err
+		}
+		for col := 0; col < 8; col++ {
+			shift := 7 - col
+			value := (high>>shift&1)<<1 | low>>shift&1
+			img.SetRGBA(x+col, y+row, p.tileColor(palette, value))
+		}
+	}
+	return nil
+}
+
+// PatternTables renders both pattern tables ($0000 and $1000) side by side as a 256x128 image.
+func (p *PPU) PatternTables() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 128))
+	for table := 0; table < 2; table++ {
+		for i := 0; i < 256; i++ {
+			x := table*128 + (i%16)*8
+			y := (i / 16) * 8
+			if err := p.drawTile(img, x, y, uint16(table)*0x1000, byte(i), 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return img, nil
+}
+
+// NameTables renders 4 name tables ($2000, $2400, $2800 and $2C00) as a 512x480 image.
+func (p *PPU) NameTables() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width*2, height*2))
+	table := 0x1000 * uint16(p.backgroundTableFlag)
+	for n := 0; n < 4; n++ {
+		base := 0x2000 + uint16(n)*0x400
+		for ty := 0; ty < 30; ty++ {
+			for tx := 0; tx < 32; tx++ {
+				tile, err := p.bus.read(base + uint16(ty*32+tx))
+				if err != nil {
+					return nil, err
+				}
+				attribute, err := p.bus.read(base + 0x3C0 + uint16((ty/4)*8+tx/4))
+				if err != nil {
+					return nil, err
+				}
+				palette := attribute >> (((ty % 4) / 2 * 4) + ((tx % 4) / 2 * 2)) & 3
+				x := (n%2)*width + tx*8
+				y := (n/2)*height + ty*8
+				if err := p.drawTile(img, x, y, table, tile, palette); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// Palettes renders 32 colors of palette RAM ($3F00-$3F1F) as a 256x32 image, the background palettes are on
+// the top row and the sprite palettes are on the bottom row, each color is a 16x16 square.
+func (p *PPU) Palettes() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 32))
+	for i := 0; i < 32; i++ {
+		c := colors[p.paletteRAM.read(0x3F00|uint16(i))&0x3F]
+		x := (i % 16) * 16
+		y := (i / 16) * 16
+		for dy := 0; dy < 16; dy++ {
+			for dx := 0; dx < 16; dx++ {
+				img.SetRGBA(x+dx, y+dy, c)
+			}
+		}
+	}
+	return img, nil
+}
@@ -5,11 +5,13 @@ import "fmt"
 type PPUBus struct {
 	vram      *RAM
 	cartridge *Cartridge
+	// extraVRAM is 2KB VRAM on the cartridge for the four-screen mirroring.
+	extraVRAM *RAM
 }
 
 // NewPPUBus creates a new Bus for PPU
 func NewPPUBus(vram *RAM, cartridge *Cartridge) *PPUBus {
-	return &PPUBus{vram, cartridge}
+	return &PPUBus{vram: vram, cartridge: cartridge, extraVRAM: NewRAM()}
 }
 
 // https://www.nesdev.org/wiki/Mirroring
@@ -28,24 +30,44 @@ func NewPPUBus(vram *RAM, cartridge *Cartridge) *PPUBus {
 //        |           |           |
 //        +-----------+-----------+
 //      (0,479)   (256,479)   (511,479)
-var offsets = [][]uint16{
-	{0x0000, 0x0400, 0x0400, 0x0800}, // horizontal cartridge mirror=0
-	{0x0000, 0x0000, 0x0800, 0x0800}, // vertical   cartridge mirror=1
+// This is an array indexed by MirrorMode since it's looked up on every name table fetch.
+var nameTablePages = [...][4]uint16{
+	// 1KB page of the VRAM for $2000, $2400, $2800 and $2C00, pages 2 and 3 are the extra VRAM on the cartridge.
+	horizontal:       {0, 0, 1, 1}, // cartridge mirror=0
+	vertical:         {0, 1, 0, 1}, // cartridge mirror=1
+	fourScreen:       {0, 1, 2, 3},
+	singleScreenLow:  {0, 0, 0, 0},
+	singleScreenHigh: {1, 1, 1, 1},
 }
 
-func (b *PPUBus) vramAddress(address uint16) uint16 {
+// vramAddress converts a name table address ($2000-$2FFF) to an address of the 2KB VRAM followed by the 2KB extra VRAM.
+func (b *PPUBus) vramAddress(address uint16) (uint16, error) {
 	mode := b.cartridge.Mirror()
-	switch {
-	case address < 0x2400:
-		address = address - 0x2000 - offsets[mode][0]
-	case address < 0x2800:
-		address = address - 0x2000 - offsets[mode][1]
-	case address < 0x2C00:
-		address = address - 0x2000 - offsets[mode][2]
-	default: // address < 0x3000
-		address = address - 0x2000 - offsets[mode][3]
+	if mode < 0 || int(mode) >= len(nameTablePages) {
+		return 0, fmt.Errorf("Name table mirroring mode %v is not supported, address=0x%04x", mode, address)
+	}
+	if address < 0x2000 || 0x3000 <= address {
+		return 0, fmt.Errorf("Not a name table address: 0x%04x", address)
+	}
+	table := (address - 0x2000) / 0x400
+	return nameTablePages[mode][table]*0x400 + address%0x400, nil
+}
+
+// readVRAM reads the VRAM or the extra VRAM by the address from vramAddress.
+func (b *PPUBus) readVRAM(address uint16) byte {
+	if address < 0x800 {
+		return b.vram.read(address)
+	}
+	return b.extraVRAM.read(address - 0x800)
+}
+
+// writeVRAM writes the VRAM or the extra VRAM by the address from vramAddress.
+func (b *PPUBus) writeVRAM(address uint16, data byte) {
+	if address < 0x800 {
+		b.vram.write(address, data)
+		return
 	}
-	return address
+	b.extraVRAM.write(address-0x800, data)
 }
 
 // read reads data.
@@ -66,10 +88,18 @@ func (b *PPUBus) read(address uint16) (byte, error) {
 	case address < 0x2000:
 		return b.cartridge.ReadFromPPU(address)
 	case address < 0x3000:
-		return b.vram.read(b.vramAddress(address)), nil
+		a, err := b.vramAddress(address)
+		if err != nil {
+			return 0, err
+		}
+		return b.readVRAM(a), nil
 	case address < 0x3F00:
 		// Mirror
-		return b.vram.read(b.vramAddress(address - 0x1000)), nil
+		a, err := b.vramAddress(address - 0x1000)
+		if err != nil {
+			return 0, err
+		}
+		return b.readVRAM(a), nil
 	default:
 		return 0, fmt.Errorf("Unknown PPU bus read: 0x%04x", address)
 	}
@@ -82,12 +112,30 @@ func (b *PPUBus) write(address uint16, data byte) error {
 	case address < 0x2000:
 		return b.cartridge.WriteFromPPU(address, data)
 	case address < 0x3000:
-		b.vram.write(b.vramAddress(address), data)
+		a, err := b.vramAddress(address)
+		if err != nil {
+			return err
+		}
+		b.writeVRAM(a, data)
 	case address < 0x3F00:
 		// Mirror
-		b.vram.write(b.vramAddress(address-0x1000), data)
+		a, err := b.vramAddress(address - 0x1000)
+		if err != nil {
+			return err
+		}
+		b.writeVRAM(a, data)
 	default:
 		return fmt.Errorf("Unknown PPU bus write: address=0x%04x, data=0x%02x", address, data)
 	}
 	return nil
 }
+
+func (b *PPUBus) saveState(w *stateWriter) {
+	b.vram.saveState(w)
+	b.extraVRAM.saveState(w)
+}
+
+func (b *PPUBus) loadState(r *stateReader) {
+	b.vram.loadState(r)
+	b.extraVRAM.loadState(r)
+}
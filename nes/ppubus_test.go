@@ -0,0 +1,132 @@
+package nes
+
+import "testing"
+
+func TestVRAMAddress(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	b := NewPPUBus(NewRAM(), cartridge)
+	tests := []struct {
+		flags6  byte
+		address uint16
+		want    uint16
+	}{
+		{0, 0x2000, 0x0000}, // horizontal
+		{0, 0x2400, 0x0000},
+		{0, 0x2800, 0x0400},
+		{0, 0x2FFF, 0x07FF},
+		{1, 0x2000, 0x0000}, // vertical
+		{1, 0x2400, 0x0400},
+		{1, 0x2800, 0x0000},
+		{1, 0x2FFF, 0x07FF},
+	}
+	for _, tt := range tests {
+		cartridge.flags6 = tt.flags6
+		got, err := b.vramAddress(tt.address)
+		if err != nil {
+			t.Fatalf("vramAddress(0x%04x) returned an error: %v", tt.address, err)
+		}
+		if got != tt.want {
+			t.Errorf("vramAddress(0x%04x) with flags6=%d: got=0x%04x, want=0x%04x", tt.address, tt.flags6, got, tt.want)
+		}
+	}
+}
+
+func TestVRAMAddressOutOfRange(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	b := NewPPUBus(NewRAM(), cartridge)
+	// $3000 would be 0x1000 on the 2KB VRAM.
+	if _, err := b.vramAddress(0x3000); err == nil {
+		t.Errorf("vramAddress(0x3000) returned no error")
+	}
+}
+
+// mirrorMapper is a mapper which controls the mirroring.
+type mirrorMapper struct {
+	Mapper
+	mode MirrorMode
+}
+
+func (m *mirrorMapper) mirror() (MirrorMode, bool) {
+	return m.mode, true
+}
+
+func TestVRAMAddressByMirrorMode(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	m := &mirrorMapper{Mapper: cartridge.Mapper}
+	cartridge.Mapper = m
+	b := NewPPUBus(NewRAM(), cartridge)
+	tests := []struct {
+		mode MirrorMode
+		want [4]uint16 // for $2000, $2400, $2800 and $2C00, 0x0800- is the extra VRAM.
+	}{
+		{horizontal, [4]uint16{0x0000, 0x0000, 0x0400, 0x0400}},
+		{vertical, [4]uint16{0x0000, 0x0400, 0x0000, 0x0400}},
+		{singleScreenLow, [4]uint16{0x0000, 0x0000, 0x0000, 0x0000}},
+		{singleScreenHigh, [4]uint16{0x0400, 0x0400, 0x0400, 0x0400}},
+		{fourScreen, [4]uint16{0x0000, 0x0400, 0x0800, 0x0C00}},
+	}
+	for _, tt := range tests {
+		m.mode = tt.mode
+		for i, want := range tt.want {
+			address := 0x2000 + uint16(i)*0x400 + 0x123
+			got, err := b.vramAddress(address)
+			if err != nil {
+				t.Fatalf("vramAddress(0x%04x) returned an error: %v", address, err)
+			}
+			if got != want+0x123 {
+				t.Errorf("vramAddress(0x%04x) with %v: got=0x%04x, want=0x%04x", address, tt.mode, got, want+0x123)
+			}
+		}
+	}
+}
+
+func TestVRAMAddressUnknownMirrorMode(t *testing.T) {
+	cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+	m := &mirrorMapper{Mapper: cartridge.Mapper}
+	cartridge.Mapper = m
+	b := NewPPUBus(NewRAM(), cartridge)
+	for _, mode := range []MirrorMode{-1, singleScreenHigh + 1} {
+		m.mode = mode
+		if _, err := b.vramAddress(0x2000); err == nil {
+			t.Errorf("vramAddress with %v: got=nil, want an error", mode)
+		}
+	}
+}
+
+func TestNameTableMirroring(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags6 byte
+		want   [4]byte // read from $2000, $2400, $2800 and $2C00
+	}{
+		// $2000 = $2400, $2800 = $2C00
+		{"horizontal", 0, [4]byte{0x11, 0x11, 0x13, 0x13}},
+		// $2000 = $2800, $2400 = $2C00
+		{"vertical", 1, [4]byte{0x12, 0x13, 0x12, 0x13}},
+		// The extra VRAM on the cartridge.
+		{"four-screen", 8, [4]byte{0x10, 0x11, 0x12, 0x13}},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+		cartridge.flags6 = tt.flags6
+		b := NewPPUBus(NewRAM(), cartridge)
+		for i := 0; i < 4; i++ {
+			if err := b.write(0x2000+uint16(i)*0x400+0x123, 0x10+byte(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 4; i++ {
+			// $3000-$3EFF mirrors $2000-$2EFF.
+			for _, base := range []uint16{0x2000, 0x3000} {
+				address := base + uint16(i)*0x400 + 0x123
+				got, err := b.read(address)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("%s: read(0x%04x): got=0x%02x, want=0x%02x", tt.name, address, got, tt.want[i])
+				}
+			}
+		}
+	}
+}
@@ -18,3 +18,11 @@ func (r *RAM) read(address uint16) byte {
 func (r *RAM) write(address uint16, x byte) {
 	r.data[address] = x
 }
+
+func (r *RAM) saveState(w *stateWriter) {
+	w.write(r.data)
+}
+
+func (r *RAM) loadState(s *stateReader) {
+	s.read(&r.data)
+}
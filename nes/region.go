@@ -0,0 +1,108 @@
+package nes
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Region is a TV system which decides the timing of the console.
+// https://www.nesdev.org/wiki/Cycle_reference_chart
+type Region int
+
+const (
+	NTSC Region = iota
+	PAL
+)
+
+func (r Region) String() string {
+	switch r {
+	case PAL:
+		return "PAL"
+	default:
+		return "NTSC"
+	}
+}
+
+// FrameRate returns frames per second.
+func (r Region) FrameRate() float64 {
+	switch r {
+	case PAL:
+		return 50.0070 // 1662607/33247.5
+	default:
+		return 60.0988 // 39375000/655171
+	}
+}
+
+// Tags in No-Intro/GoodNES style file names, e.g. "Game (E).nes".
+var filenameRegions = []struct {
+	tag    string
+	region Region
+}{
+	{"(E)", PAL},
+	{"(Europe)", PAL},
+	{"(PAL)", PAL},
+	{"(U)", NTSC},
+	{"(USA)", NTSC},
+	{"(J)", NTSC},
+	{"(Japan)", NTSC},
+}
+
+// DetectRegion detects the region of the cartridge, the header is preferred over the file name.
+// NTSC is returned when nothing indicates the region.
+func DetectRegion(cartridge *Cartridge, filename string) Region {
+	if cartridge.isNES20() {
+		// 0: NTSC, 1: PAL, 2: multiple-region, 3: Dendy
+		switch cartridge.flags12 & 3 {
+		case 0:
+			return NTSC
+		case 1:
+			return PAL
+		}
+	} else if cartridge.flags9&1 == 1 {
+		// Most iNES dumps don't set this bit even for PAL games, so only the set bit is trusted.
+		return PAL
+	}
+	name := filepath.Base(filename)
+	for _, f := range filenameRegions {
+		if strings.Contains(name, f.tag) {
+			return f.region
+		}
+	}
+	return NTSC
+}
+
+// ParseRegion parses "ntsc" or "pal".
+func ParseRegion(s string) (Region, bool) {
+	switch strings.ToLower(s) {
+	case "ntsc":
+		return NTSC, true
+	case "pal":
+		return PAL, true
+	}
+	return NTSC, false
+}
+
+// SetRegion switches the timing of the console.
+// TODO(jyane): The CPU clock and the APU rates are still NTSC's.
+func (c *NesConsole) SetRegion(r Region) {
+	c.region = r
+	switch r {
+	case PAL:
+		c.ppu.preRenderLine = 311
+	default:
+		c.ppu.preRenderLine = 261
+	}
+}
+
+// ppuCycles returns how many PPU cycles the CPU cycles take.
+// PPU runs 3 cycles per CPU cycle for NTSC and 3.2 cycles for PAL, multiplied by the CPU clock divider.
+func (c *NesConsole) ppuCycles(cycles int) int {
+	cycles *= c.cpuDivider
+	if c.region != PAL {
+		return cycles * 3
+	}
+	c.ppuRemainder += cycles * 16
+	n := c.ppuRemainder / 5
+	c.ppuRemainder %= 5
+	return n
+}
@@ -0,0 +1,60 @@
+package nes
+
+import "testing"
+
+func TestDetectRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags7   byte
+		flags9   byte
+		flags12  byte
+		filename string
+		want     Region
+	}{
+		{"no hint", 0x00, 0x00, 0x00, "rom/game.nes", NTSC},
+		{"file name PAL", 0x00, 0x00, 0x00, "rom/Game (E).nes", PAL},
+		{"file name NTSC", 0x00, 0x00, 0x00, "rom/Game (U).nes", NTSC},
+		{"iNES PAL", 0x00, 0x01, 0x00, "rom/game.nes", PAL},
+		{"iNES PAL over file name", 0x00, 0x01, 0x00, "rom/Game (J).nes", PAL},
+		{"iNES NTSC bit is not trusted", 0x00, 0x00, 0x00, "rom/Game (Europe).nes", PAL},
+		{"NES 2.0 NTSC over file name", 0x08, 0x00, 0x00, "rom/Game (E).nes", NTSC},
+		{"NES 2.0 PAL over file name", 0x08, 0x00, 0x01, "rom/Game (U).nes", PAL},
+		{"NES 2.0 multiple-region", 0x08, 0x00, 0x02, "rom/Game (E).nes", PAL},
+		{"tag in a directory", 0x00, 0x00, 0x00, "rom (E)/game.nes", NTSC},
+	}
+	for _, tt := range tests {
+		cartridge := newTestCartridge(0, make([]byte, prgROMSizeUnit), make([]byte, chrROMSizeUnit))
+		cartridge.flags7 = tt.flags7
+		cartridge.flags9 = tt.flags9
+		cartridge.flags12 = tt.flags12
+		if got := DetectRegion(cartridge, tt.filename); got != tt.want {
+			t.Errorf("%s: got=%s, want=%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPALFrame(t *testing.T) {
+	c := newTestConsole()
+	c.SetRegion(PAL)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	cycles := 0
+	for frames := 0; frames < 3; {
+		v, err := c.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Frame(); ok {
+			frames++
+			if frames == 2 {
+				cycles = 0
+			}
+		}
+		cycles += v
+	}
+	// A PAL frame takes 341x312 PPU cycles, 33247.5 CPU cycles.
+	if cycles < 33246 || 33250 < cycles {
+		t.Errorf("CPU cycles of a PAL frame: got=%d, want=33247.5", cycles)
+	}
+}
@@ -0,0 +1,79 @@
+package nes
+
+// rewindInterval is how many frames are between rewind snapshots.
+const rewindInterval = 6
+
+// rewindBuffer is a ring buffer of states, the oldest state is dropped when it is full.
+type rewindBuffer struct {
+	states [][]byte
+	start  int // index of the oldest state
+	n      int
+}
+
+func newRewindBuffer(capacity int) *rewindBuffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &rewindBuffer{states: make([][]byte, capacity)}
+}
+
+func (b *rewindBuffer) push(state []byte) {
+	if b.n == len(b.states) {
+		b.states[b.start] = state
+		b.start = (b.start + 1) % len(b.states)
+		return
+	}
+	b.states[(b.start+b.n)%len(b.states)] = state
+	b.n++
+}
+
+// pop removes the newest state, false if the buffer is empty.
+func (b *rewindBuffer) pop() ([]byte, bool) {
+	if b.n == 0 {
+		return nil, false
+	}
+	b.n--
+	i := (b.start + b.n) % len(b.states)
+	state := b.states[i]
+	b.states[i] = nil
+	return state, true
+}
+
+// EnableRewind keeps states of the last seconds for Rewind, a state is taken every rewindInterval frames.
+func EnableRewind(seconds int) Option {
+	return func(c *NesConsole) {
+		c.rewind = newRewindBuffer(seconds * 60 / rewindInterval)
+	}
+}
+
+// snapshotRewind takes a state if a frame for the rewind has completed, this is called between CPU steps
+// after the PPU caught up with the CPU, so that the state can be resumed by Step.
+func (c *NesConsole) snapshotRewind() error {
+	if !c.rewindDue {
+		return nil
+	}
+	c.rewindDue = false
+	state, err := c.SaveState()
+	if err != nil {
+		return err
+	}
+	c.rewind.push(state)
+	return nil
+}
+
+// Rewind goes back to the last state taken for the rewind, false if there is no state to go back.
+// The audio out is muted until the next frame completes, so that holding the rewind doesn't play broken sounds.
+func (c *NesConsole) Rewind() bool {
+	if c.rewind == nil {
+		return false
+	}
+	state, ok := c.rewind.pop()
+	if !ok {
+		return false
+	}
+	if err := c.LoadState(state); err != nil {
+		return false
+	}
+	c.apu.muted = true
+	return true
+}
@@ -0,0 +1,84 @@
+package nes
+
+import "testing"
+
+func TestRewindBuffer(t *testing.T) {
+	b := newRewindBuffer(3)
+	for i := byte(1); i <= 5; i++ {
+		b.push([]byte{i})
+	}
+	// 1 and 2 are dropped.
+	for _, want := range []byte{5, 4, 3} {
+		state, ok := b.pop()
+		if !ok || state[0] != want {
+			t.Errorf("pop: got=(%v, %v), want=([%d], true)", state, ok, want)
+		}
+	}
+	if _, ok := b.pop(); ok {
+		t.Error("pop from the empty buffer: got=true, want=false")
+	}
+}
+
+func TestRewind(t *testing.T) {
+	c := newTestConsole()
+	EnableRewind(1)(c)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 20)
+	// States are taken at frame 6, 12 and 18.
+	for _, want := range []uint64{18, 12, 6} {
+		if !c.Rewind() {
+			t.Fatal("Rewind: got=false, want=true")
+		}
+		if c.currentFrame != want {
+			t.Errorf("frame after Rewind: got=%d, want=%d", c.currentFrame, want)
+		}
+	}
+	if c.Rewind() {
+		t.Error("Rewind without states: got=true, want=false")
+	}
+	if !c.apu.muted {
+		t.Error("muted after Rewind: got=false, want=true")
+	}
+	stepFrames(t, c, 1)
+	if c.apu.muted {
+		t.Error("muted after a frame: got=true, want=false")
+	}
+}
+
+func TestRewindIsBounded(t *testing.T) {
+	c := newTestConsole()
+	EnableRewind(1)(c)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 120)
+	if got, want := c.rewind.n, 60/rewindInterval; got != want {
+		t.Errorf("states: got=%d, want=%d", got, want)
+	}
+}
+
+func TestRewindDisabled(t *testing.T) {
+	c := newTestConsole()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 10)
+	if c.Rewind() {
+		t.Error("Rewind without EnableRewind: got=true, want=false")
+	}
+}
+
+func TestMutedAPU(t *testing.T) {
+	a := NewAPU()
+	out := make(chan float32, 16)
+	a.SetAudioOut(out, defaultSampleRate)
+	a.muted = true
+	for i := 0; i < 1000; i++ {
+		a.Step()
+	}
+	if len(out) != 0 {
+		t.Errorf("samples while muted: got=%d, want=0", len(out))
+	}
+}
@@ -0,0 +1,150 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Save states are serialized field by field in little endian by stateWriter and deserialized by stateReader.
+// Each component has saveState and loadState, which must write and read the same fields in the same order.
+
+type stateWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+// write writes a fixed-size value, e.g. bool, byte, uint16, int or an array of them.
+func (w *stateWriter) write(values ...interface{}) {
+	for _, v := range values {
+		if w.err != nil {
+			return
+		}
+		// int is not a fixed-size value.
+		if i, ok := v.(int); ok {
+			v = int64(i)
+		}
+		w.err = binary.Write(&w.buf, binary.LittleEndian, v)
+	}
+}
+
+func (w *stateWriter) bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, fmt.Errorf("Failed to write a state: %w", w.err)
+	}
+	return w.buf.Bytes(), nil
+}
+
+type stateReader struct {
+	buf *bytes.Reader
+	err error
+}
+
+func newStateReader(data []byte) *stateReader {
+	return &stateReader{buf: bytes.NewReader(data)}
+}
+
+// read reads a fixed-size value to the pointer, e.g. *bool, *byte, *uint16, *int or a pointer to an array of them.
+func (r *stateReader) read(pointers ...interface{}) {
+	for _, p := range pointers {
+		if r.err != nil {
+			return
+		}
+		if i, ok := p.(*int); ok {
+			var v int64
+			r.err = binary.Read(r.buf, binary.LittleEndian, &v)
+			*i = int(v)
+			continue
+		}
+		r.err = binary.Read(r.buf, binary.LittleEndian, p)
+	}
+}
+
+// checkRange fails the read if a restored value is not in [0, n), e.g. a bank number of a corrupt state.
+func (r *stateReader) checkRange(name string, value int, n int) {
+	if r.err == nil && (value < 0 || n <= value) {
+		r.err = fmt.Errorf("%s is out of range: %d, want [0, %d)", name, value, n)
+	}
+}
+
+func (r *stateReader) error() error {
+	if r.err != nil {
+		return fmt.Errorf("Failed to read a state: %w", r.err)
+	}
+	return nil
+}
+
+var stateMagic = [4]byte{'J', 'N', 'S', 'S'}
+
+// stateVersion must be incremented when the layout of a state changes.
+const stateVersion uint16 = 2
+
+func (c *NesConsole) saveState(w *stateWriter) {
+	w.write(stateMagic, stateVersion, c.cartridge.MapperIndex(), uint32(len(c.cartridge.prgROM)))
+	c.cpu.saveState(w)
+	c.cpu.bus.saveState(w)
+	c.ppu.saveState(w)
+	c.ppu.bus.saveState(w)
+	c.apu.saveState(w)
+	c.controller.saveState(w)
+	c.controller2.saveState(w)
+	c.cartridge.saveState(w)
+	w.write(c.currentFrame, c.lastFrame, c.ppuRemainder, c.lagFrame, c.lagFrames)
+}
+
+func (c *NesConsole) loadState(r *stateReader) error {
+	var magic [4]byte
+	var version, mapper uint16
+	var prgSize uint32
+	r.read(&magic, &version, &mapper, &prgSize)
+	if err := r.error(); err != nil {
+		return err
+	}
+	if magic != stateMagic {
+		return fmt.Errorf("Not a state: magic=%v", magic)
+	}
+	if version != stateVersion {
+		return fmt.Errorf("Unsupported state version: got=%d, want=%d", version, stateVersion)
+	}
+	if mapper != c.cartridge.MapperIndex() || int(prgSize) != len(c.cartridge.prgROM) {
+		return fmt.Errorf("The state is for another ROM: mapper=%d, PRG ROM size=%d", mapper, prgSize)
+	}
+	c.cpu.loadState(r)
+	c.cpu.bus.loadState(r)
+	c.ppu.loadState(r)
+	c.ppu.bus.loadState(r)
+	c.apu.loadState(r)
+	c.controller.loadState(r)
+	c.controller2.loadState(r)
+	c.cartridge.loadState(r)
+	r.read(&c.currentFrame, &c.lastFrame, &c.ppuRemainder, &c.lagFrame, &c.lagFrames)
+	if err := r.error(); err != nil {
+		return err
+	}
+	if r.buf.Len() != 0 {
+		return fmt.Errorf("The state has %d trailing bytes", r.buf.Len())
+	}
+	return nil
+}
+
+// SaveState serializes the console, the state can be restored by LoadState on the same ROM.
+func (c *NesConsole) SaveState() ([]byte, error) {
+	w := &stateWriter{}
+	c.saveState(w)
+	return w.bytes()
+}
+
+// LoadState restores the console from a state, the console is unchanged if it fails.
+func (c *NesConsole) LoadState(data []byte) error {
+	backup, err := c.SaveState()
+	if err != nil {
+		return err
+	}
+	if err := c.loadState(newStateReader(data)); err != nil {
+		if rerr := c.loadState(newStateReader(backup)); rerr != nil {
+			return fmt.Errorf("Failed to restore the state: %v: %w", rerr, err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package nes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func stepFrames(t *testing.T, c *NesConsole, frames uint64) {
+	t.Helper()
+	target := c.currentFrame + frames
+	for c.currentFrame < target {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	c := newTestROMConsole(testROMRunning, "running", false)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 2)
+	saved, err := c.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 3)
+	want, err := c.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadState(saved); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 3)
+	got, err := c.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("The console after LoadState diverged from the original run")
+	}
+	// The message was written to PRG RAM before the state was saved.
+	message, err := c.testROMMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "running" {
+		t.Errorf("PRG RAM: got=%q, want=%q", message, "running")
+	}
+}
+
+func TestLoadStateRestoresMapper(t *testing.T) {
+	c := newTestROMConsole(testROMRunning, "", false)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	m := c.cartridge.Mapper.(*mapper1)
+	m.prgBank = 0x03
+	m.chrBank0 = 0x05
+	saved, err := c.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.prgBank = 0x00
+	m.chrBank0 = 0x00
+	if err := c.LoadState(saved); err != nil {
+		t.Fatal(err)
+	}
+	if m.prgBank != 0x03 || m.chrBank0 != 0x05 {
+		t.Errorf("MMC1 banks: got=(%d, %d), want=(3, 5)", m.prgBank, m.chrBank0)
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	c := newTestROMConsole(testROMRunning, "", false)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	stepFrames(t, c, 1)
+	saved, err := c.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newTestConsole().SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	badMagic := append([]byte{}, saved...)
+	badMagic[0] = 'X'
+	// corrupt returns a state saved with an out of range value, the console is restored after that.
+	corrupt := func(f func()) []byte {
+		t.Helper()
+		f()
+		data, err := c.SaveState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.LoadState(saved); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", badMagic},
+		{"truncated", saved[:len(saved)/2]},
+		{"trailing data", append(append([]byte{}, saved...), 0x00)},
+		{"another ROM", other},
+		{"secondary OAM sprites", corrupt(func() { c.ppu.secondaryNum = 9 })},
+		{"PPU cycle", corrupt(func() { c.ppu.cycle = 341 })},
+		{"scanline", corrupt(func() { c.ppu.scanline = -1 })},
+		{"pulse duty", corrupt(func() { c.apu.pulse1.dutyIndex = 8 })},
+		{"triangle sequence", corrupt(func() { c.apu.triangle.sequenceIndex = 32 })},
+		{"noise shift register", corrupt(func() { c.apu.noise.shiftRegister = 0x8000 })},
+	}
+	for _, tt := range tests {
+		stepFrames(t, c, 1)
+		want, err := c.SaveState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.LoadState(tt.data); err == nil {
+			t.Errorf("%s: got=nil, want an error", tt.name)
+		}
+		got, err := c.SaveState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: the console changed after the failed LoadState", tt.name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package nes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Test ROMs report the result through PRG RAM, $6001-$6003 has the signature $DE $B0 $61 once the status is valid.
+// $6000: $80 running, $81 the reset button needs to be pressed, $00-$7F the result code (0 means passed).
+// $6004-: the message as a zero-terminated string.
+// https://www.nesdev.org/wiki/Emulator_tests
+const (
+	testROMRunning    = 0x80
+	testROMNeedsReset = 0x81
+	// The reset should be pressed after 100ms at least, here waits 6 frames.
+	testROMResetDelay = 6
+)
+
+var testROMSignature = [3]byte{0xDE, 0xB0, 0x61}
+
+// TestROMResult is the result reported by a test ROM.
+type TestROMResult struct {
+	Status  byte
+	Message string
+}
+
+// ExitCode returns the exit status for the result, 0 if the test passed, otherwise the result code.
+func (r TestROMResult) ExitCode() int {
+	return int(r.Status)
+}
+
+// RunTestROM executes the test ROM until it reports the result in at most n frames.
+// This is supposed to be called after Reset.
+func (c *NesConsole) RunTestROM(n int) (TestROMResult, error) {
+	reset := -1
+	for frame := 0; frame < n; {
+		if _, err := c.Step(); err != nil {
+			return TestROMResult{}, err
+		}
+		if _, ok := c.Frame(); !ok {
+			continue
+		}
+		frame++
+		if frame == reset {
+			if err := c.Reset(); err != nil {
+				return TestROMResult{}, err
+			}
+			continue
+		}
+		status, ok, err := c.testROMStatus()
+		if err != nil {
+			return TestROMResult{}, err
+		}
+		if !ok || status == testROMRunning {
+			continue
+		}
+		if status == testROMNeedsReset {
+			if reset < frame {
+				reset = frame + testROMResetDelay
+			}
+			continue
+		}
+		message, err := c.testROMMessage()
+		if err != nil {
+			return TestROMResult{}, err
+		}
+		return TestROMResult{Status: status, Message: message}, nil
+	}
+	return TestROMResult{}, fmt.Errorf("The test ROM didn't report the result in %d frames.", n)
+}
+
+// testROMStatus returns the status at $6000, false if the signature is not written yet.
+func (c *NesConsole) testROMStatus() (byte, bool, error) {
+	for i, want := range testROMSignature {
+		got, err := c.cpu.bus.read(0x6001 + uint16(i))
+		if err != nil {
+			return 0, false, fmt.Errorf("Failed to read the test ROM signature: %w", err)
+		}
+		if got != want {
+			return 0, false, nil
+		}
+	}
+	status, err := c.cpu.bus.read(0x6000)
+	if err != nil {
+		return 0, false, fmt.Errorf("Failed to read the test ROM status: %w", err)
+	}
+	return status, true, nil
+}
+
+// testROMMessage reads the zero-terminated message from $6004.
+func (c *NesConsole) testROMMessage() (string, error) {
+	var b strings.Builder
+	for address := uint16(0x6004); address < 0x8000; address++ {
+		data, err := c.cpu.bus.read(address)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read the test ROM message: %w", err)
+		}
+		if data == 0 {
+			break
+		}
+		b.WriteByte(data)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,88 @@
+package nes
+
+import "testing"
+
+// newTestROMConsole creates a console running a test ROM which writes the signature and message, then reports status.
+// If needsReset is true, the ROM asks for the reset once before reporting the status.
+func newTestROMConsole(status byte, message string, needsReset bool) *NesConsole {
+	return newTestROMConsoleWithMapper(1, status, message, needsReset)
+}
+
+// newTestROMConsoleWithMapper is newTestROMConsole on the mapper, which must fix $C000 to the last 16KB at power-on.
+func newTestROMConsoleWithMapper(mapper byte, status byte, message string, needsReset bool) *NesConsole {
+	sta := func(data byte, address uint16) []byte {
+		return []byte{0xA9, data, 0x8D, byte(address), byte(address >> 8)} // LDA #data, STA address
+	}
+	var program []byte
+	for i, b := range testROMSignature {
+		program = append(program, sta(b, 0x6001+uint16(i))...)
+	}
+	for i, b := range []byte(message + "\x00") {
+		program = append(program, sta(b, 0x6004+uint16(i))...)
+	}
+	// loop jumps to itself.
+	loop := func() []byte {
+		address := 0xC000 + uint16(len(program))
+		return []byte{0x4C, byte(address), byte(address >> 8)} // JMP address
+	}
+	if needsReset {
+		// LDA $6010, BNE +11, INC $6010, then asks for the reset. $6010 survives the reset.
+		program = append(program, 0xAD, 0x10, 0x60, 0xD0, 11, 0xEE, 0x10, 0x60)
+		program = append(program, sta(testROMNeedsReset, 0x6000)...)
+		program = append(program, loop()...)
+	}
+	program = append(program, sta(status, 0x6000)...)
+	program = append(program, loop()...)
+	prgROM := make([]byte, prgROMSizeUnit*2)
+	copy(prgROM[prgROMSizeUnit:], program)
+	// Reset vector: $C000
+	prgROM[0x7FFC] = 0x00
+	prgROM[0x7FFD] = 0xC0
+	cartridge := newTestCartridge(mapper, prgROM, make([]byte, chrROMSizeUnit))
+	console, _ := NewConsole(cartridge, false /* debug */)
+	return console.(*NesConsole)
+}
+
+func TestRunTestROM(t *testing.T) {
+	tests := []struct {
+		name         string
+		mapper       byte
+		status       byte
+		needsReset   bool
+		wantExitCode int
+	}{
+		{"passed", 1, 0x00, false, 0},
+		{"failed", 1, 0x01, false, 1},
+		{"failed with code", 1, 0x03, false, 3},
+		{"passed after reset", 1, 0x00, true, 0},
+		// NROM without the battery has PRG RAM served by the cartridge.
+		{"passed on NROM", 0, 0x00, false, 0},
+	}
+	for _, tt := range tests {
+		c := newTestROMConsoleWithMapper(tt.mapper, tt.status, tt.name, tt.needsReset)
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		result, err := c.RunTestROM(60)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := result.ExitCode(); got != tt.wantExitCode {
+			t.Errorf("%s: exit code: got=%d, want=%d", tt.name, got, tt.wantExitCode)
+		}
+		if result.Message != tt.name {
+			t.Errorf("%s: message: got=%q, want=%q", tt.name, result.Message, tt.name)
+		}
+	}
+}
+
+func TestRunTestROMTimeout(t *testing.T) {
+	// The ROM keeps running.
+	c := newTestROMConsole(testROMRunning, "", false)
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RunTestROM(3); err == nil {
+		t.Error("RunTestROM without the result: got=nil, want an error")
+	}
+}
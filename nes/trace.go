@@ -0,0 +1,46 @@
+package nes
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// traceLine formats the current CPU state in nestest.log format.
+// e.g. "C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7"
+func (c *NesConsole) traceLine(cycles uint64) (string, error) {
+	raw, assembly, err := c.cpu.disassemble(c.cpu.pc)
+	if err != nil {
+		return "", err
+	}
+	bytes := make([]string, len(raw))
+	for i, b := range raw {
+		bytes[i] = fmt.Sprintf("%02X", b)
+	}
+	return fmt.Sprintf("%04X  %-8s  %-32sA:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d",
+		c.cpu.pc, strings.Join(bytes, " "), assembly,
+		c.cpu.a, c.cpu.x, c.cpu.y, c.cpu.p.encode(), c.cpu.s,
+		c.ppu.scanline, c.ppu.cycle, cycles), nil
+}
+
+// Trace executes n instructions and writes the trace to w in nestest.log format.
+// This is supposed to be called after Reset.
+func (c *NesConsole) Trace(w io.Writer, n int) error {
+	// Reset takes 7 cycles.
+	cycles := uint64(7)
+	for i := 0; i < n; i++ {
+		line, err := c.traceLine(cycles)
+		if err != nil {
+			return fmt.Errorf("Failed to trace: %w", err)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		v, err := c.Step()
+		if err != nil {
+			return err
+		}
+		cycles += uint64(v)
+	}
+	return nil
+}
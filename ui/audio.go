@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gordonklaus/portaudio"
 )
@@ -9,29 +10,56 @@ import (
 const sampleRate = 44100
 
 type audio struct {
-	stream  *portaudio.Stream
-	channel chan float32
+	stream          *portaudio.Stream
+	channel         chan float32
+	framesPerBuffer int
 }
 
-func newAudio() *audio {
+// framesPerBuffer converts the audio latency to the number of frames per buffer.
+// Larger buffers are stable against hiccups of the emulation but the sound is delayed.
+// 0 lets PortAudio choose the buffer size.
+func framesPerBuffer(latency time.Duration) int {
+	if latency <= 0 {
+		return 0
+	}
+	frames := int(latency * sampleRate / time.Second)
+	if frames < 1 {
+		frames = 1
+	}
+	return frames
+}
+
+func newAudio(latency time.Duration) *audio {
 	a := &audio{}
 	a.channel = make(chan float32, sampleRate)
+	a.framesPerBuffer = framesPerBuffer(latency)
 	return a
 }
 
+// clear drops the samples which are not played yet.
+func (a *audio) clear() {
+	for {
+		select {
+		case <-a.channel:
+		default:
+			return
+		}
+	}
+}
+
 func (a *audio) start() error {
 	portaudio.Initialize()
 	cb := func(out []float32) {
 		for i := range out {
 			select {
 			case x := <-a.channel:
-				out[i] = x * 0.05
+				out[i] = x
 			default:
 				out[i] = 0
 			}
 		}
 	}
-	stream, err := portaudio.OpenDefaultStream(0, 2, sampleRate, 0, cb)
+	stream, err := portaudio.OpenDefaultStream(0, 2, sampleRate, a.framesPerBuffer, cb)
 	if err != nil {
 		return fmt.Errorf("Failed to open the audio stream: %w", err)
 	}
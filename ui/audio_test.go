@@ -0,0 +1,35 @@
+package ui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFramesPerBuffer(t *testing.T) {
+	tests := []struct {
+		latency time.Duration
+		want    int
+	}{
+		{0, 0},
+		{-time.Millisecond, 0},
+		{10 * time.Millisecond, 441},
+		{100 * time.Millisecond, 4410},
+		{time.Microsecond, 1},
+	}
+	for _, tt := range tests {
+		if got := framesPerBuffer(tt.latency); got != tt.want {
+			t.Errorf("framesPerBuffer(%v): got=%d, want=%d", tt.latency, got, tt.want)
+		}
+	}
+}
+
+func TestAudioClear(t *testing.T) {
+	a := newAudio(0)
+	for i := 0; i < 100; i++ {
+		a.channel <- 0.5
+	}
+	a.clear()
+	if got := len(a.channel); got != 0 {
+		t.Errorf("samples after clear: got=%d, want=0", got)
+	}
+}
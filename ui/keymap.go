@@ -0,0 +1,32 @@
+package ui
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+
+	"github.com/jyane/jnes/nes"
+)
+
+// Keymap maps keyboard keys to the buttons of 1P controller.
+type Keymap struct {
+	// Buttons is indexed by the buttons, e.g. nes.ButtonA.
+	Buttons [8]glfw.Key
+	// TurboA and TurboB press A and B repeatedly while they are held.
+	TurboA glfw.Key
+	TurboB glfw.Key
+}
+
+// DefaultKeymap returns WASD for directions, J for A, H for B, G for Start, F for Select, U for turbo A and Y for turbo B.
+func DefaultKeymap() Keymap {
+	var k Keymap
+	k.Buttons[nes.ButtonRight] = glfw.KeyD
+	k.Buttons[nes.ButtonLeft] = glfw.KeyA
+	k.Buttons[nes.ButtonDown] = glfw.KeyS
+	k.Buttons[nes.ButtonUp] = glfw.KeyW
+	k.Buttons[nes.ButtonStart] = glfw.KeyG
+	k.Buttons[nes.ButtonSelect] = glfw.KeyF
+	k.Buttons[nes.ButtonB] = glfw.KeyH
+	k.Buttons[nes.ButtonA] = glfw.KeyJ
+	k.TurboA = glfw.KeyU
+	k.TurboB = glfw.KeyY
+	return k
+}
@@ -0,0 +1,45 @@
+package ui
+
+import (
+	"image"
+
+	"github.com/golang/glog"
+
+	"github.com/jyane/jnes/nes"
+)
+
+// overlay is a debug view which replaces the screen, cycled by the Tab key.
+type overlay int
+
+const (
+	overlayNone overlay = iota
+	overlayNameTables
+	overlayPatternTables
+	overlayPalettes
+	overlayNum
+)
+
+func (o overlay) next() overlay {
+	return (o + 1) % overlayNum
+}
+
+// image returns an image to show for the overlay, the frame is returned as is if no overlay is selected.
+func (o overlay) image(console nes.Console, frame *image.RGBA) *image.RGBA {
+	var img *image.RGBA
+	var err error
+	switch o {
+	case overlayNameTables:
+		img, err = console.NameTables()
+	case overlayPatternTables:
+		img, err = console.PatternTables()
+	case overlayPalettes:
+		img, err = console.Palettes()
+	default:
+		return frame
+	}
+	if err != nil {
+		glog.Warningf("Failed to render the overlay: %v\n", err)
+		return frame
+	}
+	return img
+}
@@ -0,0 +1,14 @@
+package ui
+
+import "testing"
+
+func TestOverlayNext(t *testing.T) {
+	want := []overlay{overlayNameTables, overlayPatternTables, overlayPalettes, overlayNone, overlayNameTables}
+	o := overlayNone
+	for i, w := range want {
+		o = o.next()
+		if o != w {
+			t.Errorf("next %d: got=%d, want=%d", i+1, o, w)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package ui
+
+import "github.com/jyane/jnes/nes"
+
+// framesPerSecond is the frame rate which the rapid fire rate is based on.
+const framesPerSecond = 60
+
+// rapidFire presses A and B repeatedly while the turbo keys are held, e.g. for shoot 'em ups.
+type rapidFire struct {
+	half  int // frames of each pressed and released phase.
+	frame int // frames since the turbo keys were pressed.
+}
+
+// newRapidFire creates a rapid fire which presses buttons rate times per second, the rate is clamped to 1-30.
+func newRapidFire(rate int) *rapidFire {
+	if rate < 1 {
+		rate = 1
+	}
+	half := framesPerSecond / (rate * 2)
+	if half < 1 {
+		half = 1
+	}
+	return &rapidFire{half: half}
+}
+
+// apply presses A and B of the keys in the pressed phases if the turbo keys are held, this is called once per frame.
+func (r *rapidFire) apply(keys [8]bool, turboA, turboB bool) [8]bool {
+	if !turboA && !turboB {
+		// The next press starts from the pressed phase.
+		r.frame = 0
+		return keys
+	}
+	pressed := r.frame/r.half%2 == 0
+	r.frame++
+	if turboA && pressed {
+		keys[nes.ButtonA] = true
+	}
+	if turboB && pressed {
+		keys[nes.ButtonB] = true
+	}
+	return keys
+}
@@ -0,0 +1,56 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/jyane/jnes/nes"
+)
+
+func TestRapidFire(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int
+		want []bool // A in each frame while turbo A is held.
+	}{
+		{"15Hz", 15, []bool{true, true, false, false, true, true, false, false}},
+		{"30Hz", 30, []bool{true, false, true, false}},
+		{"over 30Hz", 60, []bool{true, false, true, false}},
+		{"10Hz", 10, []bool{true, true, true, false, false, false, true}},
+	}
+	for _, tt := range tests {
+		r := newRapidFire(tt.rate)
+		for i, want := range tt.want {
+			keys := r.apply([8]bool{}, true, false)
+			if keys[nes.ButtonA] != want || keys[nes.ButtonB] {
+				t.Errorf("%s: frame %d: got A=%t, B=%t, want A=%t, B=false", tt.name, i, keys[nes.ButtonA], keys[nes.ButtonB], want)
+			}
+		}
+	}
+}
+
+func TestRapidFireRestartsOnPress(t *testing.T) {
+	r := newRapidFire(15)
+	for i := 0; i < 3; i++ {
+		r.apply([8]bool{}, false, true)
+	}
+	// Releasing the key resets the phase, the next press is effective immediately.
+	if keys := r.apply([8]bool{}, false, false); keys[nes.ButtonB] {
+		t.Error("B is pressed after the turbo key is released")
+	}
+	if keys := r.apply([8]bool{}, false, true); !keys[nes.ButtonB] {
+		t.Error("B is not pressed on the first frame of the turbo key")
+	}
+}
+
+func TestRapidFireKeepsNormalButtons(t *testing.T) {
+	r := newRapidFire(15)
+	var keys [8]bool
+	keys[nes.ButtonA] = true
+	keys[nes.ButtonStart] = true
+	for i := 0; i < 4; i++ {
+		got := r.apply(keys, true, true)
+		if !got[nes.ButtonA] || !got[nes.ButtonStart] {
+			t.Errorf("frame %d: held A and Start are released by the rapid fire: %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// saveScreenshot writes the frame to dir as screenshot_20060102_150405.000.png by the time, and returns the path.
+func saveScreenshot(dir string, frame *image.RGBA, now time.Time) (string, error) {
+	if frame == nil {
+		return "", fmt.Errorf("No frame has been rendered yet")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Failed to create the directory: %w", err)
+	}
+	path := filepath.Join(dir, "screenshot_"+now.Format("20060102_150405.000")+".png")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create a screenshot file: %w", err)
+	}
+	if err := png.Encode(f, frame); err != nil {
+		f.Close()
+		return "", fmt.Errorf("Failed to encode a screenshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,46 @@
+package ui
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveScreenshot(t *testing.T) {
+	dir := t.TempDir()
+	frame := image.NewRGBA(image.Rect(0, 0, 256, 240))
+	frame.Set(10, 20, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+	now := time.Date(2021, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	path, err := saveScreenshot(dir, frame, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "screenshot_20210102_030405.006.png"); path != want {
+		t.Errorf("path: got=%s, want=%s", path, want)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != frame.Bounds() {
+		t.Errorf("bounds: got=%v, want=%v", got.Bounds(), frame.Bounds())
+	}
+	if r, g, b, _ := got.At(10, 20).RGBA(); r>>8 != 0xFF || g != 0 || b != 0 {
+		t.Errorf("pixel: got=(%d, %d, %d), want=(255, 0, 0)", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestSaveScreenshotWithoutFrame(t *testing.T) {
+	if _, err := saveScreenshot(t.TempDir(), nil, time.Now()); err == nil {
+		t.Error("saveScreenshot without a frame: got=nil, want an error")
+	}
+}
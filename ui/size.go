@@ -0,0 +1,49 @@
+package ui
+
+import (
+	"math"
+
+	"github.com/golang/glog"
+)
+
+// NES PPU generates 256x240 pixels.
+const (
+	nesWidth     = 256
+	nesHeight    = 240
+	defaultScale = 4
+)
+
+// WindowSize resolves the window size from -scale, -width and -height, 0 means unspecified.
+// The aspect ratio of NES is always kept, and the window is never smaller than the NES screen.
+// When scale and width/height are specified at the same time, scale is prioritized.
+func WindowSize(scale, width, height int) (int, int) {
+	if 0 < scale {
+		if 0 < width || 0 < height {
+			glog.Warningf("Both -scale and -width/-height are specified, using -scale=%d\n", scale)
+		}
+		return nesWidth * scale, nesHeight * scale
+	}
+	var s float64
+	switch {
+	case 0 < width && 0 < height:
+		// Fitting into the given window with keeping the aspect ratio.
+		s = math.Min(float64(width)/nesWidth, float64(height)/nesHeight)
+		if width*nesHeight != height*nesWidth {
+			glog.Warningf("-width=%d and -height=%d don't match the NES aspect ratio\n", width, height)
+		}
+	case 0 < width:
+		s = float64(width) / nesWidth
+	case 0 < height:
+		s = float64(height) / nesHeight
+	default:
+		s = defaultScale
+	}
+	if s < 1 {
+		glog.Warningf("The window is too small, clamped to %dx%d\n", nesWidth, nesHeight)
+		s = 1
+	}
+	if s != math.Trunc(s) {
+		glog.Warningf("The NES scale %.2f is not an integer, pixels will be distorted\n", s)
+	}
+	return int(nesWidth * s), int(nesHeight * s)
+}
@@ -0,0 +1,27 @@
+package ui
+
+import "testing"
+
+func TestWindowSize(t *testing.T) {
+	tests := []struct {
+		name                  string
+		scale, width, height  int
+		wantWidth, wantHeight int
+	}{
+		{"default", 0, 0, 0, 1024, 960},
+		{"scale", 2, 0, 0, 512, 480},
+		{"scale prioritized", 3, 1024, 100, 768, 720},
+		{"width only", 0, 512, 0, 512, 480},
+		{"height only", 0, 0, 720, 768, 720},
+		{"conflicting width and height", 0, 1024, 480, 512, 480},
+		{"non-integer scale", 0, 640, 0, 640, 600},
+		{"clamped", 0, 100, 100, 256, 240},
+	}
+	for _, tt := range tests {
+		w, h := WindowSize(tt.scale, tt.width, tt.height)
+		if w != tt.wantWidth || h != tt.wantHeight {
+			t.Errorf("%s: WindowSize(%d, %d, %d): got=%dx%d, want=%dx%d",
+				tt.name, tt.scale, tt.width, tt.height, w, h, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
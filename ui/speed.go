@@ -0,0 +1,52 @@
+package ui
+
+// TurboMode decides how the fast-forward key works.
+type TurboMode int
+
+const (
+	// TurboHold fast-forwards while the key is held.
+	TurboHold TurboMode = iota
+	// TurboToggle switches fast-forward on and off on each key press.
+	TurboToggle
+)
+
+// speed controls the emulation speed, the fast-forward key switches between the normal speed and the turbo speed.
+type speed struct {
+	mode   TurboMode
+	factor int // the speed while fast-forwarding, e.g. 4 means 4x.
+	turbo  bool
+}
+
+func newSpeed(mode TurboMode, factor int) *speed {
+	s := &speed{mode: mode}
+	s.SetSpeed(factor)
+	return s
+}
+
+// SetSpeed sets the fast-forward speed, the speed less than 1 is treated as 1.
+func (s *speed) SetSpeed(factor int) {
+	if factor < 1 {
+		factor = 1
+	}
+	s.factor = factor
+}
+
+// key updates the state by a key event of the fast-forward key, pressed is false when the key is released.
+func (s *speed) key(pressed bool) {
+	switch s.mode {
+	case TurboToggle:
+		if pressed {
+			s.turbo = !s.turbo
+		}
+	default:
+		s.turbo = pressed
+	}
+}
+
+// multiplier returns how many times faster than the normal speed the emulation should run.
+func (s *speed) multiplier() int {
+	if s.turbo {
+		return s.factor
+	}
+	return 1
+}
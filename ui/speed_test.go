@@ -0,0 +1,35 @@
+package ui
+
+import "testing"
+
+func TestSpeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   TurboMode
+		events []bool // true: pressed, false: released
+		want   []int
+	}{
+		{"hold", TurboHold, []bool{true, false, true, true, false}, []int{4, 1, 4, 4, 1}},
+		{"toggle", TurboToggle, []bool{true, false, true, false, false}, []int{4, 4, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		s := newSpeed(tt.mode, 4)
+		if got := s.multiplier(); got != 1 {
+			t.Errorf("%s: initial multiplier: got=%d, want=1", tt.name, got)
+		}
+		for i, pressed := range tt.events {
+			s.key(pressed)
+			if got := s.multiplier(); got != tt.want[i] {
+				t.Errorf("%s: multiplier after event %d: got=%d, want=%d", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSetSpeedMinimum(t *testing.T) {
+	s := newSpeed(TurboHold, 0)
+	s.key(true)
+	if got := s.multiplier(); got != 1 {
+		t.Errorf("multiplier with speed 0: got=%d, want=1", got)
+	}
+}
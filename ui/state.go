@@ -0,0 +1,29 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jyane/jnes/nes"
+)
+
+// saveStateFile saves the state of the console to the path.
+func saveStateFile(console nes.Console, path string) error {
+	data, err := console.SaveState()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write a state: %w", err)
+	}
+	return nil
+}
+
+// loadStateFile loads the state of the console from the path.
+func loadStateFile(console nes.Console, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read a state: %w", err)
+	}
+	return console.LoadState(data)
+}
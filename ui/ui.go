@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"image"
 	"time"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
@@ -10,56 +11,203 @@ import (
 	"github.com/jyane/jnes/nes"
 )
 
-func mainLoop(window *glfw.Window, console nes.Console, program uint32, audio *audio) {
+// persistTicks is how many ticks (16ms) are between persist calls, about a second.
+const persistTicks = 60
+
+func mainLoop(window *glfw.Window, console nes.Console, program uint32, audio *audio, speed *speed, keymap Keymap, rapid *rapidFire, statePath string, screenshotDir string, persist func() error) error {
+	current := overlayNone
+	// last is the last completed frame, screenshots take this rather than the frame being rendered.
+	var last *image.RGBA
+	show := func(frame *image.RGBA) {
+		last = frame
+		updateTexture(program, current.image(console, frame))
+		window.SwapBuffers()
+	}
+	rewinding := false
+	// paused stops the emulation, advance runs a frame while paused.
+	paused := false
+	advance := false
+	// Layers shown on the screen for debugging.
+	showBackground, showSprites := true, true
+	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		if action == glfw.Repeat {
+			return
+		}
+		switch key {
+		case glfw.KeyTab:
+			if action == glfw.Press {
+				current = current.next()
+			}
+		case glfw.KeyF1:
+			if action == glfw.Press {
+				showBackground = !showBackground
+				console.SetLayerMask(showBackground, showSprites)
+			}
+		case glfw.KeyF2:
+			if action == glfw.Press {
+				showSprites = !showSprites
+				console.SetLayerMask(showBackground, showSprites)
+			}
+		case glfw.KeySpace:
+			// fast-forward
+			speed.key(action == glfw.Press)
+		case glfw.KeyP:
+			if action == glfw.Press {
+				paused = !paused
+				audio.clear()
+			}
+		case glfw.KeyN:
+			if action == glfw.Press && paused {
+				advance = true
+			}
+		case glfw.KeyR:
+			if action == glfw.Press {
+				if err := console.Reset(); err != nil {
+					glog.Errorln(err)
+				}
+			}
+		case glfw.KeyBackspace:
+			rewinding = action == glfw.Press
+		case glfw.KeyF5:
+			if action == glfw.Press {
+				if err := saveStateFile(console, statePath); err != nil {
+					glog.Errorln(err)
+				} else {
+					glog.Infof("Saved the state to %s\n", statePath)
+				}
+			}
+		case glfw.KeyF12:
+			if action == glfw.Press {
+				if path, err := saveScreenshot(screenshotDir, last, time.Now()); err != nil {
+					glog.Errorln(err)
+				} else {
+					glog.Infof("Saved a screenshot to %s\n", path)
+				}
+			}
+		case glfw.KeyF9:
+			if action == glfw.Press {
+				if err := loadStateFile(console, statePath); err != nil {
+					glog.Errorln(err)
+				} else {
+					glog.Infof("Loaded the state from %s\n", statePath)
+				}
+			}
+		}
+	})
+	console.SetInputSource(func() [8]bool {
+		return getKeys(window, keymap, rapid)
+	})
+	ticks := 0
 	for range time.Tick(16 * time.Millisecond) {
+		ticks++
+		if ticks%persistTicks == 0 {
+			if err := persist(); err != nil {
+				glog.Errorln(err)
+			}
+		}
+		if paused {
+			if advance {
+				advance = false
+				frame, err := stepFrame(console)
+				if err != nil {
+					return err
+				}
+				// Drops the sound of the frame to keep silent while paused.
+				audio.clear()
+				show(frame)
+			}
+			glfw.PollEvents()
+			if window.ShouldClose() {
+				return nil
+			}
+			continue
+		}
+		if rewinding {
+			// Goes back a state per tick and shows the frame after it.
+			if console.Rewind() {
+				frame, err := stepFrame(console)
+				if err != nil {
+					return err
+				}
+				show(frame)
+			}
+			glfw.PollEvents()
+			if window.ShouldClose() {
+				return nil
+			}
+			continue
+		}
 		currentCycles := 0
-		for currentCycles < nes.CPUFrequency/60 {
+		for currentCycles < nes.CPUFrequency/60*speed.multiplier() {
 			cycles, err := console.Step()
 			if err != nil {
-				glog.Fatalln(err)
+				return err
 			}
 			frame, ok := console.Frame()
 			if ok {
-				updateTexture(program, frame)
-				window.SwapBuffers()
+				show(frame)
 				glfw.PollEvents()
-				console.SetButtons(getKeys(window))
 			}
 			currentCycles += cycles
 		}
+		// The audio plays at the normal speed, so the samples while fast-forwarding are dropped not to pile up delays.
+		if 1 < speed.multiplier() {
+			audio.clear()
+		}
 		if window.ShouldClose() {
-			return
+			return nil
+		}
+	}
+	return nil
+}
+
+// stepFrame steps the console until a frame completes.
+func stepFrame(console nes.Console) (*image.RGBA, error) {
+	for {
+		if _, err := console.Step(); err != nil {
+			return nil, err
+		}
+		if frame, ok := console.Frame(); ok {
+			return frame, nil
 		}
 	}
 }
 
 // Start is the main entrypoint.
-func Start(console nes.Console, width int, height int) {
-	err := glfw.Init()
-	if err != nil {
-		glog.Fatalln(err)
+// audioLatency is the size of audio buffers, 0 lets the audio library choose it.
+// turboMode and turboSpeed configure the fast-forward key (Space), the sound is dropped while fast-forwarding.
+// keymap maps keys to the buttons, the turbo A/B keys press the buttons rapidFireRate times per second.
+// F5 saves the state to statePath and F9 loads it, Backspace rewinds while it's held if the console enables rewind.
+// P pauses and resumes the emulation, N advances a frame while paused, R presses the reset button.
+// F1 and F2 show or hide the background and the sprites for debugging.
+// F12 writes a screenshot of the last frame to screenshotDir as PNG.
+// persist is called about every second while running, e.g. to save the battery-backed RAM.
+// This returns when the window is closed, or an error if the emulation fails.
+func Start(console nes.Console, width int, height int, audioLatency time.Duration, turboMode TurboMode, turboSpeed int, keymap Keymap, rapidFireRate int, statePath string, screenshotDir string, persist func() error) error {
+	if err := glfw.Init(); err != nil {
+		return err
 	}
 	defer glfw.Terminate()
 	window, err := glfw.CreateWindow(width, height, "JNES", nil, nil)
 	if err != nil {
-		glog.Fatalln(err)
+		return err
 	}
 	window.MakeContextCurrent()
 	if err := gl.Init(); err != nil {
-		glog.Fatalln(err)
+		return err
 	}
 	program, err := newProgram()
 	if err != nil {
-		glog.Fatalln(err)
+		return err
 	}
 	gl.UseProgram(program)
 	glfw.WindowHint(glfw.ContextVersionMajor, 3)
 	glfw.WindowHint(glfw.ContextVersionMinor, 3)
-	audio := newAudio()
-	console.SetAudioOut(audio.channel)
+	audio := newAudio(audioLatency)
+	console.SetAudioOut(audio.channel, sampleRate)
 	if err := audio.start(); err != nil {
-		glog.Fatalln(err)
+		return err
 	}
 	defer audio.terminate()
-	mainLoop(window, console, program, audio)
+	return mainLoop(window, console, program, audio, newSpeed(turboMode, turboSpeed), keymap, newRapidFire(rapidFireRate), statePath, screenshotDir, persist)
 }
@@ -7,7 +7,6 @@ import (
 
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
-	"github.com/jyane/jnes/nes"
 )
 
 // Shaders for a 2D texture.
@@ -119,16 +118,11 @@ func updateTexture(program uint32, image *image.RGBA) {
 	gl.DrawArrays(gl.TRIANGLE_FAN, 0, 4)
 }
 
-// getKey gets the state of keyboard, WASD for directions, J for primary.
-func getKeys(window *glfw.Window) [8]bool {
+// getKeys gets the state of keyboard by the keymap, the turbo keys are applied through the rapid fire.
+func getKeys(window *glfw.Window, keymap Keymap, rapid *rapidFire) [8]bool {
 	var keys [8]bool
-	keys[nes.ButtonRight] = window.GetKey(glfw.KeyD) == glfw.Press
-	keys[nes.ButtonLeft] = window.GetKey(glfw.KeyA) == glfw.Press
-	keys[nes.ButtonDown] = window.GetKey(glfw.KeyS) == glfw.Press
-	keys[nes.ButtonUp] = window.GetKey(glfw.KeyW) == glfw.Press
-	keys[nes.ButtonStart] = window.GetKey(glfw.KeyG) == glfw.Press
-	keys[nes.ButtonSelect] = window.GetKey(glfw.KeyF) == glfw.Press
-	keys[nes.ButtonB] = window.GetKey(glfw.KeyH) == glfw.Press
-	keys[nes.ButtonA] = window.GetKey(glfw.KeyJ) == glfw.Press
-	return keys
+	for button, key := range keymap.Buttons {
+		keys[button] = window.GetKey(key) == glfw.Press
+	}
+	return rapid.apply(keys, window.GetKey(keymap.TurboA) == glfw.Press, window.GetKey(keymap.TurboB) == glfw.Press)
 }